@@ -2,16 +2,35 @@ package main
 
 import (
 	"./util"
+	"flag"
+	"fmt"
 	"log"
+	"os"
 	"runtime"
+	"strings"
 	"time"
 
-	as "github.com/vulkan-go/asche"
 	"github.com/vulkan-go/glfw/v3.3/glfw"
 	vk "github.com/vulkan-go/vulkan"
 	"github.com/xlab/closer"
 )
 
+var (
+	headless = flag.Bool("headless", false, "render offscreen without a window or swapchain")
+	capture  = flag.String("capture", "", "write the headless frame to this PNG file")
+	model    = flag.String("model", "", "add this OBJ or glTF model to the scene")
+	skybox   = flag.String("skybox", "", "draw this equirectangular image, or six comma separated face images, as the skybox")
+	memStats = flag.Bool("memstats", false, "log the device memory statistics on exit")
+	memJSON  = flag.String("memstats-json", "", "write the device memory statistics as JSON to this file on exit")
+	frames   = flag.Int("frames", 2, "number of frames the CPU may record ahead of the GPU, 1 to 3")
+	dynamic  = flag.Bool("dynamic", false, "record every frame, culling the meshes out of view")
+
+	golden          = flag.String("golden", "", "compare headless renders against the golden PNGs in this directory")
+	goldenUpdate    = flag.Bool("golden-update", false, "record new golden PNGs instead of comparing")
+	goldenTolerance = flag.Uint("golden-tolerance", 2, "per-channel difference still matching a golden pixel")
+	goldenMaxBad    = flag.Int("golden-max-bad", 0, "number of pixels allowed to differ from a golden image")
+)
+
 func init() {
 	runtime.LockOSThread()
 	log.SetFlags(log.Lshortfile)
@@ -19,86 +38,81 @@ func init() {
 
 type Application struct {
 	*util.SpinningCube
-	debugEnabled bool
-	windowHandle *glfw.Window
-}
-
-func (a *Application) VulkanSurface(instance vk.Instance) (surface vk.Surface) {
-	surfPtr, err := a.windowHandle.CreateWindowSurface(instance, nil)
-	if err != nil {
-		log.Println(err)
-		return vk.NullSurface
-	}
-	return vk.SurfaceFromPointer(surfPtr)
-}
-
-func (a *Application) VulkanAppName() string {
-	return "LOOOOL"
-}
-
-func (a *Application) VulkanLayers() []string {
-	return []string{
-		//"VK_LAYER_GOOGLE_threading",
-		// "VK_LAYER_LUNARG_parameter_validation",
-		// "VK_LAYER_LUNARG_object_tracker",
-		// "VK_LAYER_LUNARG_core_validation",
-		// "VK_LAYER_LUNARG_api_dump",
-		// "VK_LAYER_LUNARG_swapchain",
-		// "VK_LAYER_GOOGLE_unique_objects",
-	}
-}
-
-func (a *Application) VulkanDebug() bool {
-	return false // a.debugEnabled
-}
-
-func (a *Application) VulkanDeviceExtensions() []string {
-	return []string{
-		"VK_KHR_swapchain",
-	}
-}
 
-func (a *Application) VulkanSwapchainDimensions() *as.SwapchainDimensions {
-	return &as.SwapchainDimensions{
-		Width: 500, Height: 500, Format: vk.FormatB8g8r8a8Unorm,
-	}
-}
-
-func (a *Application) VulkanInstanceExtensions() []string {
-	extensions := a.windowHandle.GetRequiredInstanceExtensions()
-	if a.debugEnabled {
-		extensions = append(extensions, "VK_EXT_debug_report")
-	}
-	return extensions
+	// framebuffer size of the window, used when the surface
+	// leaves the swapchain extent up to the application.
+	width  uint32
+	height uint32
 }
 
-func NewApplication(debugEnabled bool) *Application {
+func NewApplication() *Application {
 	return &Application{
 		SpinningCube: util.NewSpinningCube(0),
 
-		debugEnabled: debugEnabled,
+		width:  500,
+		height: 500,
 	}
 }
 
 func main() {
+	flag.Parse()
+	if *golden != "" {
+		runGolden()
+		return
+	}
+	if *headless {
+		runHeadless()
+		return
+	}
+
 	orPanic(glfw.Init())
 	vk.SetGetInstanceProcAddr(glfw.GetVulkanGetInstanceProcAddress())
 	orPanic(vk.Init())
 	defer closer.Close()
 
-	app := NewApplication(true)
-	reqDim := app.VulkanSwapchainDimensions()
+	app := NewApplication()
+	if *model != "" {
+		orPanic(app.LoadModel(*model))
+	}
+	if *skybox != "" {
+		orPanic(app.SetSkybox(skyboxRef(*skybox)))
+	}
+	if *dynamic {
+		orPanic(app.SetRecordFunc(drawVisible))
+	}
 	glfw.WindowHint(glfw.ClientAPI, glfw.NoAPI)
-	window, err := glfw.CreateWindow(int(reqDim.Width), int(reqDim.Height), "FieboLib Vulkan Test :D", nil, nil)
+	window, err := glfw.CreateWindow(int(app.width), int(app.height), "FieboLib Vulkan Test :D", nil, nil)
 	orPanic(err)
-	app.windowHandle = window
+	window.SetFramebufferSizeCallback(func(w *glfw.Window, width int, height int) {
+		app.width, app.height = uint32(width), uint32(height)
+	})
 
-	// creates a new platform, also initializes Vulkan context in the app
-	platform, err := as.NewPlatform(app)
-	orPanic(err)
+	// F12 saves a screenshot of the next presented frame
+	var captureRequested bool
+	window.SetKeyCallback(func(w *glfw.Window, key glfw.Key, scancode int,
+		action glfw.Action, mods glfw.ModifierKey) {
+		if key == glfw.KeyF12 && action == glfw.Press {
+			captureRequested = true
+		}
+	})
 
-	dim := app.Context().SwapchainDimensions()
-	log.Printf("Initialized %s with %+v swapchain", app.VulkanAppName(), dim)
+	// creates the device and swapchain and prepares the app to render
+	w, err := util.NewWindow(app.SpinningCube, util.WindowOptions{
+		InstanceExtensions: window.GetRequiredInstanceExtensions(),
+		CreateSurface: func(instance vk.Instance) (vk.Surface, error) {
+			surfPtr, err := window.CreateWindowSurface(instance, nil)
+			if err != nil {
+				return vk.NullSurface, err
+			}
+			return vk.SurfaceFromPointer(surfPtr), nil
+		},
+		Size: func() (uint32, uint32) {
+			return app.width, app.height
+		},
+		FramesInFlight: *frames,
+	})
+	orPanic(err)
+	log.Printf("Initialized with %+v swapchain, %d frames in flight", w.Dimensions(), *frames)
 
 	// some sync logic
 	doneC := make(chan struct{}, 2)
@@ -114,8 +128,8 @@ func main() {
 	for {
 		select {
 		case <-exitC:
-			app.Destroy()
-			platform.Destroy()
+			reportMemory(app)
+			w.Destroy()
 			window.Destroy()
 			glfw.Terminate()
 			fpsTicker.Stop()
@@ -127,20 +141,118 @@ func main() {
 				continue
 			}
 			glfw.PollEvents()
+			if app.width == 0 || app.height == 0 {
+				// minimized, there is no surface area to present to
+				continue
+			}
 			app.NextFrame()
+			// records the frame while the GPU may still render the previous
+			// ones, the swapchain is recreated when it no longer matches the
+			// window and the app rebuilds its size dependent resources
+			orPanic(w.Frame())
 
-			imageIdx, outdated, err := app.Context().AcquireNextImage()
-			orPanic(err)
-			if outdated {
-				imageIdx, _, err = app.Context().AcquireNextImage()
-				orPanic(err)
+			if captureRequested {
+				captureRequested = false
+				name := fmt.Sprintf("screenshot-%d.png", time.Now().Unix())
+				if err := app.CapturePNG(name); err != nil {
+					log.Println(err)
+				} else {
+					log.Println("Saved", name)
+				}
 			}
-			_, err = app.Context().PresentImage(imageIdx)
-			orPanic(err)
 		}
 	}
 }
 
+// skyboxRef returns the cubemap of the -skybox flag.
+func skyboxRef(arg string) util.CubemapRef {
+	var ref util.CubemapRef
+	names := strings.Split(arg, ",")
+	if len(names) != len(ref.Faces) {
+		ref.Equirect = util.TextureRef{Name: arg}
+		return ref
+	}
+	for i, name := range names {
+		ref.Faces[i] = util.TextureRef{Name: name}
+	}
+	return ref
+}
+
+// drawVisible records the skybox and the meshes in view.
+func drawVisible(f *util.Frame) {
+	f.DrawSkybox()
+	for i := 0; i < f.Meshes(); i++ {
+		if f.Visible(i) {
+			f.DrawMesh(i)
+		}
+	}
+}
+
+func runHeadless() {
+	orPanic(vk.SetDefaultGetInstanceProcAddr())
+	orPanic(vk.Init())
+
+	app := NewApplication()
+	// a single frame is rendered, often in CI, leave the user cache alone
+	app.SetPipelineCachePath("")
+	if *model != "" {
+		orPanic(app.LoadModel(*model))
+	}
+	if *skybox != "" {
+		orPanic(app.SetSkybox(skyboxRef(*skybox)))
+	}
+	if *dynamic {
+		orPanic(app.SetRecordFunc(drawVisible))
+	}
+	h, err := util.NewHeadless(app.SpinningCube, app.width, app.height)
+	orPanic(err)
+	defer h.Destroy()
+
+	app.NextFrame()
+	img, err := h.Render()
+	orPanic(err)
+	log.Printf("Rendered %v headless frame", img.Bounds().Size())
+	reportMemory(app)
+	if *capture != "" {
+		orPanic(util.SavePNG(*capture, img))
+	}
+}
+
+// reportMemory reports the device memory statistics as the -memstats
+// and -memstats-json flags ask for.
+func reportMemory(app *Application) {
+	stats := app.MemoryStats()
+	if *memStats {
+		log.Printf("Device memory: %v", stats)
+	}
+	if *memJSON != "" {
+		f, err := os.Create(*memJSON)
+		orPanic(err)
+		defer f.Close()
+		orPanic(stats.WriteJSON(f))
+	}
+}
+
+func runGolden() {
+	if *goldenTolerance > 255 {
+		log.Fatalf("-golden-tolerance %d out of range 0 to 255", *goldenTolerance)
+	}
+	orPanic(vk.SetDefaultGetInstanceProcAddr())
+	orPanic(vk.Init())
+
+	err := util.RunGoldenCases(util.DefaultGoldenCases, util.GoldenOptions{
+		Dir:          *golden,
+		Width:        256,
+		Height:       256,
+		Tolerance:    uint8(*goldenTolerance),
+		MaxBadPixels: *goldenMaxBad,
+		Update:       *goldenUpdate,
+	})
+	if err != nil {
+		log.Fatalln(err)
+	}
+}
+
 func orPanic(err interface{}) {
 	switch v := err.(type) {
 	case error:
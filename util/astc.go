@@ -0,0 +1,620 @@
+package util
+
+import (
+	"encoding/binary"
+	"math/bits"
+)
+
+// CPU decoder of the ASTC LDR block formats, used when the device can't
+// sample them. Texels are written in rows of the block width. Blocks the
+// LDR profile can't decode, HDR and malformed ones, decode to magenta.
+
+var astcErrorColor = [4]byte{255, 0, 255, 255}
+
+// astcRange is a range of integer sequence encoded values, each a trit or
+// a quint, if any, above bits low bits.
+type astcRange struct {
+	trit, quint bool
+	bits        uint
+}
+
+// astcRanges are the value ranges, from 2 to 256, the weight ranges are
+// the first 12 of them.
+var astcRanges = [...]astcRange{
+	{bits: 1}, {trit: true}, {bits: 2}, {quint: true},
+	{trit: true, bits: 1}, {bits: 3}, {quint: true, bits: 1}, {trit: true, bits: 2},
+	{bits: 4}, {quint: true, bits: 2}, {trit: true, bits: 3}, {bits: 5},
+	{quint: true, bits: 3}, {trit: true, bits: 4}, {bits: 6}, {quint: true, bits: 4},
+	{trit: true, bits: 5}, {bits: 7}, {quint: true, bits: 5}, {trit: true, bits: 6},
+	{bits: 8},
+}
+
+// size returns the number of bits n values of the range are encoded in.
+func (r astcRange) size(n int) int {
+	size := n * int(r.bits)
+	if r.trit {
+		size += (8*n + 4) / 5
+	}
+	if r.quint {
+		size += (7*n + 2) / 3
+	}
+	return size
+}
+
+// astcBlockMode is the weight grid of a block.
+type astcBlockMode struct {
+	width, height int
+	dualPlane     bool
+	weights       astcRange
+}
+
+func decodeASTCBlockMode(mode int) (m astcBlockMode, ok bool) {
+	bit := func(i uint) int { return mode >> i & 1 }
+	a := mode >> 5 & 3
+	var r int
+	high, dual := bit(9), bit(10)
+	if mode&3 != 0 {
+		r = mode&3<<1 | bit(4)
+		b := mode >> 7 & 3
+		switch mode >> 2 & 3 {
+		case 0:
+			m.width, m.height = b+4, a+2
+		case 1:
+			m.width, m.height = b+8, a+2
+		case 2:
+			m.width, m.height = a+2, b+8
+		default:
+			if bit(8) == 0 {
+				m.width, m.height = a+2, bit(7)+6
+			} else {
+				m.width, m.height = bit(7)+2, a+2
+			}
+		}
+	} else {
+		r = mode>>2&3<<1 | bit(4)
+		if r < 2 {
+			return m, false
+		}
+		switch mode >> 7 & 3 {
+		case 0:
+			m.width, m.height = 12, a+2
+		case 1:
+			m.width, m.height = a+2, 12
+		case 2:
+			// bits 9 and 10 are the height
+			m.width, m.height = a+6, mode>>9&3+6
+			high, dual = 0, 0
+		default:
+			switch a {
+			case 0:
+				m.width, m.height = 6, 10
+			case 1:
+				m.width, m.height = 10, 6
+			default:
+				return m, false
+			}
+		}
+	}
+	m.dualPlane = dual == 1
+	m.weights = astcRanges[r-2+6*high]
+	return m, true
+}
+
+// decodeASTCIntegers decodes n integer sequence encoded values of rng from
+// r, which reads 0 past the end of the sequence.
+func decodeASTCIntegers(r *bitReader, n int, rng astcRange) []int {
+	v := make([]int, n)
+	switch {
+	case rng.trit:
+		for i := 0; i < n; i += 5 {
+			var m [5]int
+			m[0] = r.read(rng.bits)
+			t := r.read(2)
+			m[1] = r.read(rng.bits)
+			t |= r.read(2) << 2
+			m[2] = r.read(rng.bits)
+			t |= r.read(1) << 4
+			m[3] = r.read(rng.bits)
+			t |= r.read(2) << 5
+			m[4] = r.read(rng.bits)
+			t |= r.read(1) << 7
+			trits := decodeTrits(t)
+			for j := 0; j < 5 && i+j < n; j++ {
+				v[i+j] = trits[j]<<rng.bits | m[j]
+			}
+		}
+	case rng.quint:
+		for i := 0; i < n; i += 3 {
+			var m [3]int
+			m[0] = r.read(rng.bits)
+			q := r.read(3)
+			m[1] = r.read(rng.bits)
+			q |= r.read(2) << 3
+			m[2] = r.read(rng.bits)
+			q |= r.read(2) << 5
+			quints := decodeQuints(q)
+			for j := 0; j < 3 && i+j < n; j++ {
+				v[i+j] = quints[j]<<rng.bits | m[j]
+			}
+		}
+	default:
+		for i := range v {
+			v[i] = r.read(rng.bits)
+		}
+	}
+	return v
+}
+
+// decodeTrits unpacks the 5 trits packed into 8 bits.
+func decodeTrits(t int) [5]int {
+	var c, t3, t4 int
+	if t>>2&7 == 7 {
+		c = t>>5&7<<2 | t&3
+		t3, t4 = 2, 2
+	} else {
+		c = t & 0x1f
+		if t>>5&3 == 3 {
+			t3, t4 = t>>7&1, 2
+		} else {
+			t3, t4 = t>>5&3, t>>7&1
+		}
+	}
+	bit := func(i uint) int { return c >> i & 1 }
+	var t0, t1, t2 int
+	switch {
+	case c&3 == 3:
+		t0, t1, t2 = bit(3)<<1|bit(2)&^bit(3), bit(4), 2
+	case c>>2&3 == 3:
+		t0, t1, t2 = c&3, 2, 2
+	default:
+		t0, t1, t2 = bit(1)<<1|bit(0)&^bit(1), c>>2&3, bit(4)
+	}
+	return [5]int{t0, t1, t2, t3, t4}
+}
+
+// decodeQuints unpacks the 3 quints packed into 7 bits.
+func decodeQuints(q int) [3]int {
+	bit := func(i uint) int { return q >> i & 1 }
+	if q>>1&3 == 3 && q>>5&3 == 0 {
+		return [3]int{4, 4, bit(0)<<2 | (bit(4)&^bit(0))<<1 | bit(3)&^bit(0)}
+	}
+	var c, q2 int
+	if q>>1&3 == 3 {
+		c = q>>3&3<<3 | ^q>>5&3<<1 | bit(0)
+		q2 = 4
+	} else {
+		c = q & 0x1f
+		q2 = q >> 5 & 3
+	}
+	if c&7 == 5 {
+		return [3]int{c >> 3 & 3, 4, q2}
+	}
+	return [3]int{c & 7, c >> 3 & 3, q2}
+}
+
+// replicateBits widens the n bit v to the given bits by repeating it.
+func replicateBits(v int, n, to uint) int {
+	r, have := 0, uint(0)
+	for ; have < to; have += n {
+		r = r<<n | v
+	}
+	return r >> (have - to)
+}
+
+// astcUnquant is how a value of a trit or quint range scales to the full
+// range: B's bits are the low bits of the value named by the letters, a
+// being bit 0, and C is the scale of the trit or quint.
+type astcUnquant struct {
+	b string
+	c int
+}
+
+// astcColorUnquant and astcWeightUnquant are indexed by whether the range
+// has quints and its bits.
+var astcColorUnquant = [2][7]astcUnquant{
+	{1: {"000000000", 204}, 2: {"b000b0bb0", 93}, 3: {"cb000cbcb", 44},
+		4: {"dcb000dcb", 22}, 5: {"edcb000ed", 11}, 6: {"fedcb000f", 5}},
+	{1: {"000000000", 113}, 2: {"b0000bb00", 54}, 3: {"cb0000cbc", 26},
+		4: {"dcb0000dc", 13}, 5: {"edcb0000e", 6}},
+}
+
+var astcWeightUnquant = [2][4]astcUnquant{
+	{1: {"0000000", 50}, 2: {"b000b0b", 23}, 3: {"cb000cb", 11}},
+	{1: {"0000000", 28}, 2: {"b0000b0", 13}},
+}
+
+// unquantize scales the value v of rng, D its trit or quint and A its
+// lowest bit repeated: T = D*C + B, inverted by A, shifted down 2 bits and
+// with the top bit of A.
+func (u astcUnquant) unquantize(v int, rng astcRange) int {
+	m := v & (1<<rng.bits - 1)
+	b := 0
+	for _, l := range u.b {
+		b <<= 1
+		if l != '0' {
+			b |= m >> uint(l-'a') & 1
+		}
+	}
+	t := v>>rng.bits*u.c + b
+	a, top := 0, 1<<uint(len(u.b)-2)
+	if m&1 == 1 {
+		a = 1<<uint(len(u.b)) - 1
+	}
+	return a&top | (t^a)>>2
+}
+
+func unquantizeColor(v int, rng astcRange) int {
+	if !rng.trit && !rng.quint {
+		return replicateBits(v, rng.bits, 8)
+	}
+	q := 0
+	if rng.quint {
+		q = 1
+	}
+	return astcColorUnquant[q][rng.bits].unquantize(v, rng)
+}
+
+// unquantizeWeight maps the weight v of rng to 0-64.
+func unquantizeWeight(v int, rng astcRange) int {
+	var w int
+	switch {
+	case !rng.trit && !rng.quint:
+		w = replicateBits(v, rng.bits, 6)
+	case rng.bits == 0 && rng.trit:
+		w = [3]int{0, 32, 63}[v]
+	case rng.bits == 0:
+		w = [5]int{0, 16, 32, 47, 63}[v]
+	default:
+		q := 0
+		if rng.quint {
+			q = 1
+		}
+		w = astcWeightUnquant[q][rng.bits].unquantize(v, rng)
+	}
+	if w > 32 {
+		w++
+	}
+	return w
+}
+
+// decodeASTC decodes an ASTC block of w x h texels into RGBA8 texels. The
+// RGB channels of sRGB blocks are interpolated as the sRGB decode mode asks.
+func decodeASTC(b []byte, w, h int, srgb bool, texels []byte) {
+	r := &bitReader{
+		lo: binary.LittleEndian.Uint64(b),
+		hi: binary.LittleEndian.Uint64(b[8:]),
+	}
+	if !decodeASTCBlock(r, w, h, srgb, texels) {
+		for i := 0; i < w*h; i++ {
+			copy(texels[4*i:], astcErrorColor[:])
+		}
+	}
+}
+
+func decodeASTCBlock(r *bitReader, w, h int, srgb bool, texels []byte) bool {
+	mode := r.read(11)
+	if mode&0x1ff == 0x1fc {
+		return decodeASTCVoidExtent(r, mode, w*h, texels)
+	}
+	m, ok := decodeASTCBlockMode(mode)
+	if !ok || m.width > w || m.height > h {
+		return false
+	}
+	planes := 1
+	if m.dualPlane {
+		planes = 2
+	}
+	weightCount := m.width * m.height * planes
+	weightBits := m.weights.size(weightCount)
+	if weightCount > 64 || weightBits < 24 || weightBits > 96 {
+		return false
+	}
+
+	partitions := r.read(2) + 1
+	if partitions == 4 && m.dualPlane {
+		return false
+	}
+	belowWeights := 128 - uint(weightBits)
+	var cems [4]int
+	seed := 0
+	if partitions == 1 {
+		cems[0] = r.read(4)
+	} else {
+		seed = r.read(10)
+		cem := r.read(6)
+		if cem&3 == 0 {
+			for i := range cems {
+				cems[i] = cem >> 2
+			}
+		} else {
+			// the rest of the modes of the partitions is below the weights
+			extra := uint(3*partitions - 4)
+			belowWeights -= extra
+			pos := r.pos
+			r.pos = belowWeights
+			cem |= r.read(extra) << 6
+			r.pos = pos
+			class := cem&3 - 1
+			cem >>= 2
+			for i := 0; i < partitions; i++ {
+				cems[i] = (class + cem>>uint(i)&1) << 2
+			}
+			cem >>= uint(partitions)
+			for i := 0; i < partitions; i++ {
+				cems[i] |= cem >> (2 * uint(i)) & 3
+			}
+		}
+	}
+	colorStart := r.pos
+	ccs := -1
+	if m.dualPlane {
+		belowWeights -= 2
+		r.pos = belowWeights
+		ccs = r.read(2)
+	}
+
+	colorCount := 0
+	for _, cem := range cems[:partitions] {
+		switch cem {
+		case 2, 3, 7, 11, 14, 15:
+			// HDR endpoints
+			return false
+		}
+		colorCount += 2 * (cem>>2 + 1)
+	}
+	if colorCount > 18 || belowWeights < colorStart {
+		return false
+	}
+	// colors use the largest range that fits, at least 0-5
+	level := len(astcRanges) - 1
+	for level >= 0 && astcRanges[level].size(colorCount) > int(belowWeights-colorStart) {
+		level--
+	}
+	if level < 4 {
+		return false
+	}
+	colorRange := astcRanges[level]
+	colors := decodeASTCIntegers(r.sub(colorStart, uint(colorRange.size(colorCount))),
+		colorCount, colorRange)
+	for i, v := range colors {
+		colors[i] = unquantizeColor(v, colorRange)
+	}
+	var endpoints [4][2][4]int
+	for i, cem := range cems[:partitions] {
+		endpoints[i] = astcEndpoints(cem, colors)
+		colors = colors[2*(cem>>2+1):]
+	}
+
+	// weights are stored bit reversed from the end of the block
+	rev := &bitReader{lo: bits.Reverse64(r.hi), hi: bits.Reverse64(r.lo)}
+	weights := decodeASTCIntegers(rev.sub(0, uint(weightBits)), weightCount, m.weights)
+	for i, v := range weights {
+		weights[i] = unquantizeWeight(v, m.weights)
+	}
+	var planeWeights [2][]int
+	for p := 0; p < planes; p++ {
+		grid := make([]int, (m.width+1)*(m.height+1))
+		for i := 0; i < m.width*m.height; i++ {
+			grid[i/m.width*(m.width+1)+i%m.width] = weights[planes*i+p]
+		}
+		planeWeights[p] = astcInfill(grid, m.width, m.height, w, h)
+	}
+
+	for i := 0; i < w*h; i++ {
+		e := endpoints[0]
+		if partitions > 1 {
+			e = endpoints[astcPartition(seed, i%w, i/w, partitions, w*h < 31)]
+		}
+		for c := 0; c < 4; c++ {
+			wt := planeWeights[0][i]
+			if c == ccs {
+				wt = planeWeights[1][i]
+			}
+			c0, c1 := e[0][c]<<8|e[0][c], e[1][c]<<8|e[1][c]
+			if srgb && c < 3 {
+				c0, c1 = e[0][c]<<8|0x80, e[1][c]<<8|0x80
+			}
+			texels[4*i+c] = byte((c0*(64-wt) + c1*wt + 32) / 64 >> 8)
+		}
+	}
+	return true
+}
+
+// decodeASTCVoidExtent decodes a block of n texels of a single color.
+func decodeASTCVoidExtent(r *bitReader, mode, n int, texels []byte) bool {
+	// bit 9 marks HDR colors, bits 10 and 11 are reserved as 1
+	if mode>>9&1 == 1 || mode>>10&1 == 0 || r.read(1) == 0 {
+		return false
+	}
+	minS, maxS, minT, maxT := r.read(13), r.read(13), r.read(13), r.read(13)
+	const none = 0x1fff
+	if !(minS == none && maxS == none && minT == none && maxT == none) &&
+		(minS >= maxS || minT >= maxT) {
+		return false
+	}
+	var color [4]byte
+	for c := range color {
+		color[c] = byte(r.read(16) >> 8)
+	}
+	for i := 0; i < n; i++ {
+		copy(texels[4*i:], color[:])
+	}
+	return true
+}
+
+// astcEndpoints decodes the endpoints of the color endpoint mode cem from
+// the first of colors.
+func astcEndpoints(cem int, v []int) (e [2][4]int) {
+	blueContract := func(r, g, b, a int) [4]int {
+		return [4]int{(r + b) >> 1, (g + b) >> 1, b, a}
+	}
+	switch cem {
+	case 0:
+		e[0] = [4]int{v[0], v[0], v[0], 255}
+		e[1] = [4]int{v[1], v[1], v[1], 255}
+	case 1:
+		l0 := v[0]>>2 | v[1]&0xc0
+		l1 := l0 + v[1]&0x3f
+		if l1 > 255 {
+			l1 = 255
+		}
+		e[0] = [4]int{l0, l0, l0, 255}
+		e[1] = [4]int{l1, l1, l1, 255}
+	case 4:
+		e[0] = [4]int{v[0], v[0], v[0], v[2]}
+		e[1] = [4]int{v[1], v[1], v[1], v[3]}
+	case 5:
+		v[1], v[0] = bitTransferSigned(v[1], v[0])
+		v[3], v[2] = bitTransferSigned(v[3], v[2])
+		e[0] = [4]int{v[0], v[0], v[0], v[2]}
+		l := v[0] + v[1]
+		e[1] = [4]int{l, l, l, v[2] + v[3]}
+	case 6:
+		e[0] = [4]int{v[0] * v[3] >> 8, v[1] * v[3] >> 8, v[2] * v[3] >> 8, 255}
+		e[1] = [4]int{v[0], v[1], v[2], 255}
+	case 8, 12:
+		a0, a1 := 255, 255
+		if cem == 12 {
+			a0, a1 = v[6], v[7]
+		}
+		if v[1]+v[3]+v[5] >= v[0]+v[2]+v[4] {
+			e[0] = [4]int{v[0], v[2], v[4], a0}
+			e[1] = [4]int{v[1], v[3], v[5], a1}
+		} else {
+			e[0] = blueContract(v[1], v[3], v[5], a1)
+			e[1] = blueContract(v[0], v[2], v[4], a0)
+		}
+	case 9, 13:
+		v[1], v[0] = bitTransferSigned(v[1], v[0])
+		v[3], v[2] = bitTransferSigned(v[3], v[2])
+		v[5], v[4] = bitTransferSigned(v[5], v[4])
+		a0, a1 := 255, 255
+		if cem == 13 {
+			v[7], v[6] = bitTransferSigned(v[7], v[6])
+			a0, a1 = v[6], v[6]+v[7]
+		}
+		if v[1]+v[3]+v[5] >= 0 {
+			e[0] = [4]int{v[0], v[2], v[4], a0}
+			e[1] = [4]int{v[0] + v[1], v[2] + v[3], v[4] + v[5], a1}
+		} else {
+			e[0] = blueContract(v[0]+v[1], v[2]+v[3], v[4]+v[5], a1)
+			e[1] = blueContract(v[0], v[2], v[4], a0)
+		}
+	case 10:
+		e[0] = [4]int{v[0] * v[3] >> 8, v[1] * v[3] >> 8, v[2] * v[3] >> 8, v[4]}
+		e[1] = [4]int{v[0], v[1], v[2], v[5]}
+	}
+	for i := range e {
+		for c := range e[i] {
+			if e[i][c] < 0 {
+				e[i][c] = 0
+			} else if e[i][c] > 255 {
+				e[i][c] = 255
+			}
+		}
+	}
+	return e
+}
+
+// bitTransferSigned moves the top bit of a into b and returns a as a
+// signed 6-bit offset.
+func bitTransferSigned(a, b int) (int, int) {
+	b = b>>1 | a&0x80
+	a = a >> 1 & 0x3f
+	if a&0x20 != 0 {
+		a -= 0x40
+	}
+	return a, b
+}
+
+// astcInfill bilinearly interpolates the weights of the gw x gh grid, in
+// rows of gw+1 with a padding row and column, at the w x h texels.
+func astcInfill(grid []int, gw, gh, w, h int) []int {
+	ds := (1024 + w/2) / (w - 1)
+	dt := (1024 + h/2) / (h - 1)
+	out := make([]int, w*h)
+	for t := 0; t < h; t++ {
+		for s := 0; s < w; s++ {
+			gs := (ds*s*(gw-1) + 32) >> 6
+			gt := (dt*t*(gh-1) + 32) >> 6
+			js, fs := gs>>4, gs&0xf
+			jt, ft := gt>>4, gt&0xf
+			w11 := (fs*ft + 8) >> 4
+			w10 := ft - w11
+			w01 := fs - w11
+			w00 := 16 - fs - ft + w11
+			i := jt*(gw+1) + js
+			out[t*w+s] = (grid[i]*w00 + grid[i+1]*w01 +
+				grid[i+gw+1]*w10 + grid[i+gw+2]*w11 + 8) >> 4
+		}
+	}
+	return out
+}
+
+// astcPartition returns the partition of the texel at x, y of a block of
+// partitions partitions with seed.
+func astcPartition(seed, x, y, partitions int, small bool) int {
+	if small {
+		x, y = x<<1, y<<1
+	}
+	seed += (partitions - 1) * 1024
+	rnum := astcHash(uint32(seed))
+	// z is 0, the seeds scaling it are left out
+	var s [8]uint32
+	for i := range s {
+		s[i] = rnum >> (4 * uint(i)) & 0xf
+		s[i] *= s[i]
+	}
+	sh1, sh2 := uint(5), uint(5)
+	if seed&1 == 1 {
+		if seed&2 != 0 {
+			sh1 = 4
+		}
+		if partitions == 3 {
+			sh2 = 6
+		}
+	} else {
+		if partitions == 3 {
+			sh1 = 6
+		}
+		if seed&2 != 0 {
+			sh2 = 4
+		}
+	}
+	for i := 0; i < len(s); i += 2 {
+		s[i] >>= sh1
+		s[i+1] >>= sh2
+	}
+	ux, uy := uint32(x), uint32(y)
+	a := (s[0]*ux + s[1]*uy + rnum>>14) & 0x3f
+	b := (s[2]*ux + s[3]*uy + rnum>>10) & 0x3f
+	c := (s[4]*ux + s[5]*uy + rnum>>6) & 0x3f
+	d := (s[6]*ux + s[7]*uy + rnum>>2) & 0x3f
+	if partitions < 4 {
+		d = 0
+	}
+	if partitions < 3 {
+		c = 0
+	}
+	switch {
+	case a >= b && a >= c && a >= d:
+		return 0
+	case b >= c && b >= d:
+		return 1
+	case c >= d:
+		return 2
+	}
+	return 3
+}
+
+func astcHash(n uint32) uint32 {
+	n ^= n >> 15
+	n *= 0xeede0891
+	n ^= n >> 5
+	n += n << 16
+	n ^= n >> 7
+	n ^= n >> 3
+	n ^= n << 6
+	n ^= n >> 17
+	return n
+}
@@ -0,0 +1,237 @@
+package util
+
+import (
+	"math/bits"
+	"testing"
+
+	vk "github.com/vulkan-go/vulkan"
+)
+
+// astcBlock returns the block of the bits w wrote, followed by the weight
+// bits weights wrote, which fill the block bit reversed from its end.
+func astcBlock(t *testing.T, w, weights *bitWriter) []byte {
+	t.Helper()
+	if w.pos+weights.pos > 128 {
+		t.Fatalf("block of %d bits and %d weight bits", w.pos, weights.pos)
+	}
+	w.lo |= bits.Reverse64(weights.hi)
+	w.hi |= bits.Reverse64(weights.lo)
+	w.pos = 128
+	return w.block(t)
+}
+
+// astcVoidExtent returns a block of a single RGBA16 color.
+func astcVoidExtent(mode int, color [4]int) *bitWriter {
+	w := &bitWriter{}
+	w.write(mode, 11)
+	w.write(1, 1)
+	for i := 0; i < 4; i++ {
+		w.write(0x1fff, 13)
+	}
+	for _, c := range color {
+		w.write(c, 16)
+	}
+	return w
+}
+
+func checkASTC(t *testing.T, name string, texels []byte, w int, want func(x, y int) [4]byte) {
+	t.Helper()
+	for i := 0; i < len(texels)/4; i++ {
+		var got [4]byte
+		copy(got[:], texels[4*i:])
+		if wt := want(i%w, i/w); got != wt {
+			t.Errorf("%s: texel (%d,%d) is %v, want %v", name, i%w, i/w, got, wt)
+		}
+	}
+}
+
+func TestDecodeASTCVoidExtent(t *testing.T) {
+	texels := make([]byte, 4*6*5)
+	block := astcBlock(t, astcVoidExtent(0x5fc, [4]int{0xffff, 0x8000, 0, 0xffff}), &bitWriter{})
+	decodeASTC(block, 6, 5, false, texels)
+	checkASTC(t, "void extent", texels, 6, func(x, y int) [4]byte {
+		return [4]byte{255, 128, 0, 255}
+	})
+
+	for _, tc := range []struct {
+		name  string
+		block []byte
+	}{
+		{"HDR void extent", astcBlock(t, astcVoidExtent(0x7fc, [4]int{}), &bitWriter{})},
+		{"reserved block mode", make([]byte, 16)},
+	} {
+		decodeASTC(tc.block, 6, 5, false, texels)
+		checkASTC(t, tc.name, texels, 6, func(x, y int) [4]byte { return astcErrorColor })
+	}
+}
+
+func TestDecodeASTC(t *testing.T) {
+	texels := make([]byte, 4*16)
+	grey := func(v byte) [4]byte { return [4]byte{v, v, v, 255} }
+	ramp := [4]byte{0, 84, 171, 255}
+
+	// a 4x4 grid of 2-bit weights, the columns ramp from black to white
+	w := &bitWriter{}
+	w.write(0x42, 11)
+	w.write(0, 2)
+	w.write(0, 4)
+	w.write(0, 8)
+	w.write(255, 8)
+	weights := &bitWriter{}
+	for i := 0; i < 16; i++ {
+		weights.write(i%4, 2)
+	}
+	decodeASTC(astcBlock(t, w, weights), 4, 4, false, texels)
+	checkASTC(t, "luminance", texels, 4, func(x, y int) [4]byte { return grey(ramp[x]) })
+
+	// a 3x3 grid of 3-bit weights is interpolated at the 4x4 texels
+	w = &bitWriter{}
+	w.write(0x1bf, 11)
+	w.write(0, 2)
+	w.write(0, 4)
+	w.write(0, 8)
+	w.write(255, 8)
+	weights = &bitWriter{}
+	for i := 0; i < 9; i++ {
+		weights.write([3]int{0, 7, 0}[i%3], 3)
+	}
+	decodeASTC(astcBlock(t, w, weights), 4, 4, false, texels)
+	checkASTC(t, "infill", texels, 4, func(x, y int) [4]byte {
+		return grey([4]byte{0, 175, 175, 0}[x])
+	})
+
+	// dual plane, alpha has the second plane ramping by row
+	w = &bitWriter{}
+	w.write(0x442, 11)
+	w.write(0, 2)
+	w.write(4, 4)
+	for _, v := range []int{0, 255, 255, 0} {
+		w.write(v, 8)
+	}
+	w.write(0, 62-w.pos)
+	w.write(3, 2)
+	weights = &bitWriter{}
+	for i := 0; i < 16; i++ {
+		weights.write(i%4, 2)
+		weights.write(i/4, 2)
+	}
+	decodeASTC(astcBlock(t, w, weights), 4, 4, false, texels)
+	checkASTC(t, "dual plane", texels, 4, func(x, y int) [4]byte {
+		c := grey(ramp[x])
+		c[3] = [4]byte{255, 171, 84, 0}[y]
+		return c
+	})
+
+	// two partitions sharing the endpoint mode, black and white
+	const seed = 3
+	w = &bitWriter{}
+	w.write(0x42, 11)
+	w.write(1, 2)
+	w.write(seed, 10)
+	w.write(0, 6)
+	for _, v := range []int{0, 0, 255, 255} {
+		w.write(v, 8)
+	}
+	decodeASTC(astcBlock(t, w, &bitWriter{}), 4, 4, false, texels)
+	var used [2]bool
+	checkASTC(t, "partitions", texels, 4, func(x, y int) [4]byte {
+		p := astcPartition(seed, x, y, 2, true)
+		used[p] = true
+		return grey(byte(255 * p))
+	})
+	if !used[0] || !used[1] {
+		t.Errorf("partitions used %v, want both", used)
+	}
+
+	// a 2x2 grid of 1-bit weights is below the 24 weight bits allowed
+	w = &bitWriter{}
+	w.write(0x10e, 11)
+	weights = &bitWriter{}
+	weights.write(0xf, 4)
+	decodeASTC(astcBlock(t, w, weights), 4, 4, false, texels)
+	checkASTC(t, "too few weight bits", texels, 4, func(x, y int) [4]byte { return astcErrorColor })
+}
+
+func TestASTCIntegers(t *testing.T) {
+	// every 5 trits and 3 quints have an encoding
+	trits := map[[5]int]bool{}
+	for i := 0; i < 256; i++ {
+		trits[decodeTrits(i)] = true
+	}
+	if len(trits) != 243 {
+		t.Errorf("%d combinations of trits decoded, want 243", len(trits))
+	}
+	quints := map[[3]int]bool{}
+	for i := 0; i < 128; i++ {
+		quints[decodeQuints(i)] = true
+	}
+	if len(quints) != 125 {
+		t.Errorf("%d combinations of quints decoded, want 125", len(quints))
+	}
+
+	// the values of a range unquantize to distinct values spanning the full range
+	for level, rng := range astcRanges {
+		n := 1 << rng.bits
+		if rng.trit {
+			n *= 3
+		} else if rng.quint {
+			n *= 5
+		}
+		check := func(kind string, unquantize func(v int, rng astcRange) int, max int) {
+			seen := map[int]bool{}
+			lo, hi := max, 0
+			for v := 0; v < n; v++ {
+				u := unquantize(v, rng)
+				seen[u] = true
+				if u < lo {
+					lo = u
+				}
+				if u > hi {
+					hi = u
+				}
+			}
+			if len(seen) != n || lo != 0 || hi != max {
+				t.Errorf("%s range %d: %d distinct values from %d to %d, want %d from 0 to %d",
+					kind, n, len(seen), lo, hi, n, max)
+			}
+		}
+		// colors use the ranges from 6
+		if level >= 4 {
+			check("color", unquantizeColor, 255)
+		}
+		if level < 12 {
+			check("weight", unquantizeWeight, 64)
+		}
+	}
+}
+
+func TestDecompressASTC(t *testing.T) {
+	// 2x2 blocks of 6x6 texels cover a 7x7 image
+	colors := [4][4]int{
+		{0xffff, 0, 0, 0xffff}, {0, 0xffff, 0, 0xffff},
+		{0, 0, 0xffff, 0xffff}, {0xffff, 0xffff, 0xffff, 0},
+	}
+	var level []byte
+	for _, c := range colors {
+		level = append(level, astcBlock(t, astcVoidExtent(0x5fc, c), &bitWriter{})...)
+	}
+	img, err := decompress(&TextureImage{
+		Format: vk.FormatAstc6x6SrgbBlock,
+		Width:  7,
+		Height: 7,
+		Levels: [][]byte{level},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img.Format != vk.FormatR8g8b8a8Srgb {
+		t.Errorf("decompressed to format %d, want %d", img.Format, vk.FormatR8g8b8a8Srgb)
+	}
+	checkASTC(t, "image", img.Levels[0], 7, func(x, y int) [4]byte {
+		var c [4]byte
+		for i, v := range colors[y/6*2+x/6] {
+			c[i] = byte(v >> 8)
+		}
+		return c
+	})
+}
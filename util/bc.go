@@ -0,0 +1,438 @@
+package util
+
+import "encoding/binary"
+
+// CPU decoders of the BC1-BC5 and BC7 block formats, used when the device
+// can't sample them. Texels are written in rows of 4.
+
+// decodeBC1 decodes the color block b. With alpha, the 3-color mode has
+// a transparent black fourth color. BC2 and BC3 color blocks always use
+// the 4-color mode.
+func decodeBC1(b []byte, t *[16][4]byte, alpha, fourColors bool) {
+	c0 := binary.LittleEndian.Uint16(b[0:])
+	c1 := binary.LittleEndian.Uint16(b[2:])
+	var colors [4][4]byte
+	colors[0] = rgb565(c0)
+	colors[1] = rgb565(c1)
+	if c0 > c1 || fourColors {
+		for c := 0; c < 3; c++ {
+			colors[2][c] = byte((2*int(colors[0][c]) + int(colors[1][c]) + 1) / 3)
+			colors[3][c] = byte((int(colors[0][c]) + 2*int(colors[1][c]) + 1) / 3)
+		}
+		colors[2][3], colors[3][3] = 255, 255
+	} else {
+		for c := 0; c < 3; c++ {
+			colors[2][c] = byte((int(colors[0][c]) + int(colors[1][c])) / 2)
+		}
+		colors[2][3] = 255
+		colors[3] = [4]byte{0, 0, 0, 255}
+		if alpha {
+			colors[3][3] = 0
+		}
+	}
+	indices := binary.LittleEndian.Uint32(b[4:])
+	for i := range t {
+		t[i] = colors[indices>>(2*uint(i))&3]
+	}
+}
+
+func rgb565(c uint16) [4]byte {
+	r := byte(c >> 11 & 0x1f)
+	g := byte(c >> 5 & 0x3f)
+	bl := byte(c & 0x1f)
+	return [4]byte{r<<3 | r>>2, g<<2 | g>>4, bl<<3 | bl>>2, 255}
+}
+
+// decodeBC2 decodes explicit 4-bit alpha followed by a color block.
+func decodeBC2(b []byte, t *[16][4]byte) {
+	decodeBC1(b[8:], t, false, true)
+	alpha := binary.LittleEndian.Uint64(b)
+	for i := range t {
+		a := byte(alpha >> (4 * uint(i)) & 0xf)
+		t[i][3] = a<<4 | a
+	}
+}
+
+// decodeBC3 decodes interpolated alpha followed by a color block.
+func decodeBC3(b []byte, t *[16][4]byte) {
+	decodeBC1(b[8:], t, false, true)
+	var alpha [16]byte
+	decodeBC4Channel(b, &alpha, false)
+	for i := range t {
+		t[i][3] = alpha[i]
+	}
+}
+
+// decodeBC4 decodes a single channel block into red.
+func decodeBC4(b []byte, t *[16][4]byte, signed bool) {
+	var r [16]byte
+	decodeBC4Channel(b, &r, signed)
+	for i := range t {
+		t[i] = [4]byte{r[i], 0, 0, 255}
+		if signed {
+			t[i][3] = 127
+		}
+	}
+}
+
+// decodeBC5 decodes two single channel blocks into red and green.
+func decodeBC5(b []byte, t *[16][4]byte, signed bool) {
+	var r, g [16]byte
+	decodeBC4Channel(b, &r, signed)
+	decodeBC4Channel(b[8:], &g, signed)
+	for i := range t {
+		t[i] = [4]byte{r[i], g[i], 0, 255}
+		if signed {
+			t[i][3] = 127
+		}
+	}
+}
+
+// decodeBC4Channel decodes the 8 byte single channel block of BC3 alpha,
+// BC4 and BC5. Signed values are stored as two's complement bytes.
+func decodeBC4Channel(b []byte, out *[16]byte, signed bool) {
+	var e0, e1 int
+	var lo, hi int
+	if signed {
+		e0, e1 = int(int8(b[0])), int(int8(b[1]))
+		if e0 == -128 {
+			e0 = -127
+		}
+		if e1 == -128 {
+			e1 = -127
+		}
+		lo, hi = -127, 127
+	} else {
+		e0, e1 = int(b[0]), int(b[1])
+		lo, hi = 0, 255
+	}
+	var values [8]int
+	values[0], values[1] = e0, e1
+	if e0 > e1 {
+		for i := 1; i < 7; i++ {
+			values[i+1] = divRound((7-i)*e0+i*e1, 7)
+		}
+	} else {
+		for i := 1; i < 5; i++ {
+			values[i+1] = divRound((5-i)*e0+i*e1, 5)
+		}
+		values[6], values[7] = lo, hi
+	}
+	indices := uint64(b[2]) | uint64(b[3])<<8 | uint64(b[4])<<16 |
+		uint64(b[5])<<24 | uint64(b[6])<<32 | uint64(b[7])<<40
+	for i := range out {
+		out[i] = byte(values[indices>>(3*uint(i))&7])
+	}
+}
+
+// divRound divides n by d > 0, rounding to the nearest integer
+// and halves away from zero.
+func divRound(n, d int) int {
+	if n < 0 {
+		return -((-n + d/2) / d)
+	}
+	return (n + d/2) / d
+}
+
+// bc7Mode describes the encoding of a BC7 mode.
+type bc7Mode struct {
+	subsets        int
+	partitionBits  uint
+	rotationBits   uint
+	indexSelection uint
+	colorBits      uint
+	alphaBits      uint
+	endpointPBits  bool
+	sharedPBits    bool
+	indexBits      uint
+	indexBits2     uint
+}
+
+var bc7Modes = [8]bc7Mode{
+	{3, 4, 0, 0, 4, 0, true, false, 3, 0},
+	{2, 6, 0, 0, 6, 0, false, true, 3, 0},
+	{3, 6, 0, 0, 5, 0, false, false, 2, 0},
+	{2, 6, 0, 0, 7, 0, true, false, 2, 0},
+	{1, 0, 2, 1, 5, 6, false, false, 2, 3},
+	{1, 0, 2, 0, 7, 8, false, false, 2, 2},
+	{1, 0, 0, 0, 7, 7, true, false, 4, 0},
+	{2, 6, 0, 0, 5, 5, true, false, 2, 0},
+}
+
+// bitReader reads the bits of a little-endian block, lowest first.
+type bitReader struct {
+	lo, hi uint64
+	pos    uint
+}
+
+func (r *bitReader) read(n uint) int {
+	var v uint64
+	for i := uint(0); i < n; i++ {
+		p := r.pos + i
+		var bit uint64
+		if p < 64 {
+			bit = r.lo >> p & 1
+		} else {
+			bit = r.hi >> (p - 64) & 1
+		}
+		v |= bit << i
+	}
+	r.pos += n
+	return int(v)
+}
+
+// decodeBC7 decodes a BC7 block. Reserved modes decode to transparent black.
+func decodeBC7(b []byte, t *[16][4]byte) {
+	r := &bitReader{
+		lo: binary.LittleEndian.Uint64(b),
+		hi: binary.LittleEndian.Uint64(b[8:]),
+	}
+	mode := 0
+	for mode < 8 && r.read(1) == 0 {
+		mode++
+	}
+	if mode == 8 {
+		*t = [16][4]byte{}
+		return
+	}
+	m := bc7Modes[mode]
+	partition := r.read(m.partitionBits)
+	rotation := r.read(m.rotationBits)
+	indexSelection := r.read(m.indexSelection)
+
+	// endpoints of the subsets, channel by channel
+	n := 2 * m.subsets
+	var endpoints [6][4]int
+	for c := 0; c < 3; c++ {
+		for e := 0; e < n; e++ {
+			endpoints[e][c] = r.read(m.colorBits)
+		}
+	}
+	for e := 0; e < n; e++ {
+		if m.alphaBits > 0 {
+			endpoints[e][3] = r.read(m.alphaBits)
+		} else {
+			endpoints[e][3] = 255
+		}
+	}
+	colorBits, alphaBits := m.colorBits, m.alphaBits
+	if m.endpointPBits || m.sharedPBits {
+		var pbits [6]int
+		if m.endpointPBits {
+			for e := 0; e < n; e++ {
+				pbits[e] = r.read(1)
+			}
+		} else {
+			for s := 0; s < m.subsets; s++ {
+				p := r.read(1)
+				pbits[2*s], pbits[2*s+1] = p, p
+			}
+		}
+		for e := 0; e < n; e++ {
+			for c := 0; c < 3; c++ {
+				endpoints[e][c] = endpoints[e][c]<<1 | pbits[e]
+			}
+			if m.alphaBits > 0 {
+				endpoints[e][3] = endpoints[e][3]<<1 | pbits[e]
+			}
+		}
+		colorBits++
+		if alphaBits > 0 {
+			alphaBits++
+		}
+	}
+	for e := 0; e < n; e++ {
+		for c := 0; c < 3; c++ {
+			endpoints[e][c] = unquantize(endpoints[e][c], colorBits)
+		}
+		if alphaBits > 0 {
+			endpoints[e][3] = unquantize(endpoints[e][3], alphaBits)
+		}
+	}
+
+	// the anchor index of each subset is stored without its highest bit
+	var subsetOf [16]int
+	anchors := [3]int{0, -1, -1}
+	switch m.subsets {
+	case 2:
+		mask := bc7Partitions2[partition]
+		for i := range subsetOf {
+			subsetOf[i] = int(mask >> uint(i) & 1)
+		}
+		anchors[1] = int(bc7Anchors2[partition])
+	case 3:
+		p := bc7Partitions3[partition]
+		for i := range subsetOf {
+			subsetOf[i] = int(p >> (2 * uint(i)) & 3)
+		}
+		anchors[1] = int(bc7Anchors3a[partition])
+		anchors[2] = int(bc7Anchors3b[partition])
+	}
+	isAnchor := func(i int) bool {
+		return i == anchors[0] || i == anchors[1] || i == anchors[2]
+	}
+	var indices, indices2 [16]int
+	for i := range indices {
+		bits := m.indexBits
+		if isAnchor(i) {
+			bits--
+		}
+		indices[i] = r.read(bits)
+	}
+	if m.indexBits2 > 0 {
+		for i := range indices2 {
+			bits := m.indexBits2
+			if i == 0 {
+				bits--
+			}
+			indices2[i] = r.read(bits)
+		}
+	}
+
+	for i := range t {
+		s := subsetOf[i]
+		e0, e1 := endpoints[2*s], endpoints[2*s+1]
+		colorIndex, colorBits := indices[i], m.indexBits
+		alphaIndex, alphaBits := indices[i], m.indexBits
+		if m.indexBits2 > 0 {
+			alphaIndex, alphaBits = indices2[i], m.indexBits2
+			if indexSelection == 1 {
+				colorIndex, alphaIndex = alphaIndex, colorIndex
+				colorBits, alphaBits = alphaBits, colorBits
+			}
+		}
+		var px [4]byte
+		for c := 0; c < 3; c++ {
+			px[c] = byte(bc7Interpolate(e0[c], e1[c], colorIndex, colorBits))
+		}
+		px[3] = byte(bc7Interpolate(e0[3], e1[3], alphaIndex, alphaBits))
+		switch rotation {
+		case 1:
+			px[0], px[3] = px[3], px[0]
+		case 2:
+			px[1], px[3] = px[3], px[1]
+		case 3:
+			px[2], px[3] = px[3], px[2]
+		}
+		t[i] = px
+	}
+}
+
+// unquantize expands an n-bit endpoint component to 8 bits.
+func unquantize(v int, n uint) int {
+	v <<= 8 - n
+	return v | v>>n
+}
+
+var (
+	bc7Weights2 = []int{0, 21, 43, 64}
+	bc7Weights3 = []int{0, 9, 18, 27, 37, 46, 55, 64}
+	bc7Weights4 = []int{0, 4, 9, 13, 17, 21, 26, 30, 34, 38, 43, 47, 51, 55, 60, 64}
+)
+
+func bc7Interpolate(e0, e1, index int, bits uint) int {
+	var w int
+	switch bits {
+	case 2:
+		w = bc7Weights2[index]
+	case 3:
+		w = bc7Weights3[index]
+	default:
+		w = bc7Weights4[index]
+	}
+	return ((64-w)*e0 + w*e1 + 32) >> 6
+}
+
+// bc7Partitions2 holds the subset of each texel of the two subset
+// partitions as bits.
+var bc7Partitions2 = [64]uint16{
+	0xcccc, 0x8888, 0xeeee, 0xecc8, 0xc880, 0xfeec, 0xfec8, 0xec80,
+	0xc800, 0xffec, 0xfe80, 0xe800, 0xffe8, 0xff00, 0xfff0, 0xf000,
+	0xf710, 0x008e, 0x7100, 0x08ce, 0x008c, 0x7310, 0x3100, 0x8cce,
+	0x088c, 0x3110, 0x6666, 0x366c, 0x17e8, 0x0ff0, 0x718e, 0x399c,
+	0xaaaa, 0xf0f0, 0x5a5a, 0x33cc, 0x3c3c, 0x55aa, 0x9696, 0xa55a,
+	0x73ce, 0x13c8, 0x324c, 0x3bdc, 0x6996, 0xc33c, 0x9966, 0x0660,
+	0x0272, 0x04e4, 0x4e40, 0x2720, 0xc936, 0x936c, 0x39c6, 0x639c,
+	0x9336, 0x9cc6, 0x817e, 0xe718, 0xccf0, 0x0fcc, 0x7744, 0xee22,
+}
+
+// bc7Partitions3 holds the subset of each texel of the three subset
+// partitions in 2 bits.
+var bc7Partitions3 [64]uint32
+
+func init() {
+	for p, subsets := range bc7Partitions3Texels {
+		var v uint32
+		for i := range subsets {
+			v |= uint32(subsets[i]-'0') << (2 * uint(i))
+		}
+		bc7Partitions3[p] = v
+	}
+}
+
+var bc7Partitions3Texels = [64]string{
+	"0011001102212222", "0001001122112221", "0000200122112211", "0222002200110111",
+	"0000000011221122", "0011001100220022", "0022002211111111", "0011001122112211",
+	"0000000011112222", "0000111111112222", "0000111122222222", "0012001200120012",
+	"0112011201120112", "0122012201220122", "0011011211221222", "0011200122002220",
+	"0001001101121122", "0111001120012200", "0000112211221122", "0022002200221111",
+	"0111011102220222", "0001000122212221", "0000001101220122", "0000110022102210",
+	"0122012200110000", "0012001211222222", "0110122112210110", "0000011012211221",
+	"0022110211020022", "0110011020022222", "0011012201220011", "0000200022112221",
+	"0000000211221222", "0222002200120011", "0011001200220222", "0120012001200120",
+	"0000111122220000", "0120120120120120", "0120201212010120", "0011220011220011",
+	"0011112222000011", "0101010122222222", "0000000021212121", "0022112200221122",
+	"0022001100220011", "0220122102201221", "0101222222220101", "0000212121212121",
+	"0101010101012222", "0222011102220111", "0002111200021112", "0000211221122112",
+	"0222011101110222", "0002111211120002", "0110011001102222", "0000000021122112",
+	"0110011022222222", "0022001100110022", "0022112211220022", "0000000000002112",
+	"0002000100020001", "0222122202221222", "0101222222222222", "0111201122012220",
+}
+
+var bc7Anchors2 = [64]uint8{
+	15, 15, 15, 15, 15, 15, 15, 15,
+	15, 15, 15, 15, 15, 15, 15, 15,
+	15, 2, 8, 2, 2, 8, 8, 15,
+	2, 8, 2, 2, 8, 8, 2, 2,
+	15, 15, 6, 8, 2, 8, 15, 15,
+	2, 8, 2, 2, 2, 15, 15, 6,
+	6, 2, 6, 8, 15, 15, 2, 2,
+	15, 15, 15, 15, 15, 2, 2, 15,
+}
+
+var bc7Anchors3a = [64]uint8{
+	3, 3, 15, 15, 8, 3, 15, 15,
+	8, 8, 6, 6, 6, 5, 3, 3,
+	3, 3, 8, 15, 3, 3, 6, 10,
+	5, 8, 8, 6, 8, 5, 15, 15,
+	8, 15, 3, 5, 6, 10, 8, 15,
+	15, 3, 15, 5, 15, 15, 15, 15,
+	3, 15, 5, 5, 5, 8, 5, 10,
+	5, 10, 8, 13, 15, 12, 3, 3,
+}
+
+var bc7Anchors3b = [64]uint8{
+	15, 8, 8, 3, 15, 15, 3, 8,
+	15, 15, 15, 15, 15, 15, 15, 8,
+	15, 8, 15, 3, 15, 8, 15, 8,
+	3, 15, 6, 10, 15, 15, 10, 8,
+	15, 3, 15, 10, 10, 8, 9, 10,
+	6, 15, 8, 15, 3, 6, 6, 8,
+	15, 3, 15, 15, 15, 15, 15, 15,
+	15, 15, 15, 15, 3, 15, 15, 8,
+}
+
+// sub returns a reader of the n bits from pos, which reads 0 past them.
+func (r *bitReader) sub(pos, n uint) *bitReader {
+	s := &bitReader{}
+	r.pos = pos
+	for i := uint(0); i < n; i++ {
+		bit := uint64(r.read(1))
+		if i < 64 {
+			s.lo |= bit << i
+		} else {
+			s.hi |= bit << (i - 64)
+		}
+	}
+	return s
+}
@@ -0,0 +1,244 @@
+package util
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// CPU decoder of the BC6H block formats, used when the device can't sample
+// them. Texels are written in rows of 4 as R16G16B16A16_SFLOAT.
+
+// bc6hMode describes the encoding of a BC6H mode: the bits of its base
+// endpoint and of the deltas of the other endpoints, per channel, and where
+// their bits are in the block.
+type bc6hMode struct {
+	regions      int
+	transformed  bool
+	endpointBits uint
+	deltaBits    [3]uint
+	layout       []bc6hBits
+}
+
+// bc6hBits are bits of a field read in a row from the block, the first bit
+// read into bit first of the field and the others into the next bits, or the
+// previous ones when reversed.
+type bc6hBits struct {
+	field    int
+	first    uint
+	n        uint
+	reversed bool
+}
+
+// bc6hFieldPartition is the field of the partition d in the layouts, after
+// the red, green and blue components of the endpoints w, x, y and z.
+const bc6hFieldPartition = 12
+
+// bc6hModes are the modes by their mode bits, nil for the reserved ones.
+var bc6hModes [32]*bc6hMode
+
+func init() {
+	for _, m := range []struct {
+		bits         int
+		regions      int
+		transformed  bool
+		endpointBits uint
+		deltaBits    [3]uint
+		layout       string
+	}{
+		{0x00, 2, true, 10, [3]uint{5, 5, 5}, "gy4 by4 bz4 rw9:0 gw9:0 bw9:0 rx4:0 gz4 gy3:0 gx4:0 bz0 gz3:0 bx4:0 bz1 by3:0 ry4:0 bz2 rz4:0 bz3 d4:0"},
+		{0x01, 2, true, 7, [3]uint{6, 6, 6}, "gy5 gz4 gz5 rw6:0 bz0 bz1 by4 gw6:0 by5 bz2 gy4 bw6:0 bz3 bz5 bz4 rx5:0 gy3:0 gx5:0 gz3:0 bx5:0 by3:0 ry5:0 rz5:0 d4:0"},
+		{0x02, 2, true, 11, [3]uint{5, 4, 4}, "rw9:0 gw9:0 bw9:0 rx4:0 rw10 gy3:0 gx3:0 gw10 bz0 gz3:0 bx3:0 bw10 bz1 by3:0 ry4:0 bz2 rz4:0 bz3 d4:0"},
+		{0x06, 2, true, 11, [3]uint{4, 5, 4}, "rw9:0 gw9:0 bw9:0 rx3:0 rw10 gz4 gy3:0 gx4:0 gw10 gz3:0 bx3:0 bw10 bz1 by3:0 ry3:0 bz0 bz2 rz3:0 gy4 bz3 d4:0"},
+		{0x0a, 2, true, 11, [3]uint{4, 4, 5}, "rw9:0 gw9:0 bw9:0 rx3:0 rw10 by4 gy3:0 gx3:0 gw10 bz0 gz3:0 bx4:0 bw10 by3:0 ry3:0 bz1 bz2 rz3:0 bz4 bz3 d4:0"},
+		{0x0e, 2, true, 9, [3]uint{5, 5, 5}, "rw8:0 by4 gw8:0 gy4 bw8:0 bz4 rx4:0 gz4 gy3:0 gx4:0 bz0 gz3:0 bx4:0 bz1 by3:0 ry4:0 bz2 rz4:0 bz3 d4:0"},
+		{0x12, 2, true, 8, [3]uint{6, 5, 5}, "rw7:0 gz4 by4 gw7:0 bz2 gy4 bw7:0 bz3 bz4 rx5:0 gy3:0 gx4:0 bz0 gz3:0 bx4:0 bz1 by3:0 ry5:0 rz5:0 d4:0"},
+		{0x16, 2, true, 8, [3]uint{5, 6, 5}, "rw7:0 bz0 by4 gw7:0 gy5 gy4 bw7:0 gz5 bz4 rx4:0 gz4 gy3:0 gx5:0 gz3:0 bx4:0 bz1 by3:0 ry4:0 bz2 rz4:0 bz3 d4:0"},
+		{0x1a, 2, true, 8, [3]uint{5, 5, 6}, "rw7:0 bz1 by4 gw7:0 by5 gy4 bw7:0 bz5 bz4 rx4:0 gz4 gy3:0 gx4:0 bz0 gz3:0 bx5:0 by3:0 ry4:0 bz2 rz4:0 bz3 d4:0"},
+		{0x1e, 2, false, 6, [3]uint{6, 6, 6}, "rw5:0 gz4 bz0 bz1 by4 gw5:0 gy5 by5 bz2 gy4 bw5:0 gz5 bz3 bz5 bz4 rx5:0 gy3:0 gx5:0 gz3:0 bx5:0 by3:0 ry5:0 rz5:0 d4:0"},
+		{0x03, 1, false, 10, [3]uint{10, 10, 10}, "rw9:0 gw9:0 bw9:0 rx9:0 gx9:0 bx9:0"},
+		{0x07, 1, true, 11, [3]uint{9, 9, 9}, "rw9:0 gw9:0 bw9:0 rx8:0 rw10 gx8:0 gw10 bx8:0 bw10"},
+		{0x0b, 1, true, 12, [3]uint{8, 8, 8}, "rw9:0 gw9:0 bw9:0 rx7:0 rw10:11 gx7:0 gw10:11 bx7:0 bw10:11"},
+		{0x0f, 1, true, 16, [3]uint{4, 4, 4}, "rw9:0 gw9:0 bw9:0 rx3:0 rw10:15 gx3:0 gw10:15 bx3:0 bw10:15"},
+	} {
+		bc6hModes[m.bits] = &bc6hMode{
+			regions:      m.regions,
+			transformed:  m.transformed,
+			endpointBits: m.endpointBits,
+			deltaBits:    m.deltaBits,
+			layout:       parseBC6HLayout(m.layout),
+		}
+	}
+}
+
+// parseBC6HLayout parses the layout of a mode as written in the BC6H format
+// description: fields such as rw9:0 for bits 9 to 0 of the red component of
+// endpoint w, gy4 for a single bit, and bw10:15 for bits read reversed.
+func parseBC6HLayout(layout string) []bc6hBits {
+	var bits []bc6hBits
+	for _, f := range strings.Fields(layout) {
+		field := bc6hFieldPartition
+		name := f[:1]
+		if name != "d" {
+			name = f[:2]
+			field = 3*strings.IndexByte("wxyz", name[1]) + strings.IndexByte("rgb", name[0])
+		}
+		hiLo := strings.Split(f[len(name):], ":")
+		hi, err := strconv.Atoi(hiLo[0])
+		if err != nil {
+			panic(fmt.Sprintf("texture: BC6H layout field %q", f))
+		}
+		lo := hi
+		if len(hiLo) == 2 {
+			if lo, err = strconv.Atoi(hiLo[1]); err != nil {
+				panic(fmt.Sprintf("texture: BC6H layout field %q", f))
+			}
+		}
+		b := bc6hBits{field: field, first: uint(lo), n: uint(hi - lo + 1)}
+		if hi < lo {
+			b.n = uint(lo - hi + 1)
+			b.reversed = true
+		}
+		bits = append(bits, b)
+	}
+	return bits
+}
+
+// decodeBC6H decodes a BC6H block into half float texels, opaque.
+// Reserved modes decode to black.
+func decodeBC6H(b []byte, texels []byte, signed bool) {
+	r := &bitReader{
+		lo: binary.LittleEndian.Uint64(b),
+		hi: binary.LittleEndian.Uint64(b[8:]),
+	}
+	modeBits := r.read(2)
+	if modeBits > 1 {
+		modeBits |= r.read(3) << 2
+	}
+	m := bc6hModes[modeBits]
+	if m == nil {
+		for i := 0; i < 16; i++ {
+			binary.LittleEndian.PutUint64(texels[8*i:], 0x3c00<<48)
+		}
+		return
+	}
+
+	// the fields of the endpoints, then the partition
+	var fields [13]int
+	for _, bits := range m.layout {
+		for k := uint(0); k < bits.n; k++ {
+			pos := bits.first + k
+			if bits.reversed {
+				pos = bits.first - k
+			}
+			fields[bits.field] |= r.read(1) << pos
+		}
+	}
+	partition := fields[bc6hFieldPartition]
+
+	// the endpoints in the unquantized 16 bits
+	n := 2 * m.regions
+	var endpoints [4][3]int
+	for c := 0; c < 3; c++ {
+		base := fields[c]
+		if signed {
+			base = signExtend(base, m.endpointBits)
+		}
+		endpoints[0][c] = base
+		for e := 1; e < n; e++ {
+			v := fields[3*e+c]
+			if m.transformed {
+				// deltas from the base endpoint
+				v = (base + signExtend(v, m.deltaBits[c])) & (1<<m.endpointBits - 1)
+			}
+			if signed {
+				v = signExtend(v, m.endpointBits)
+			}
+			endpoints[e][c] = v
+		}
+		for e := 0; e < n; e++ {
+			endpoints[e][c] = bc6hUnquantize(endpoints[e][c], m.endpointBits, signed)
+		}
+	}
+
+	// the anchor index of each region is stored without its highest bit
+	indexBits := uint(4)
+	var regionOf [16]int
+	anchor := -1
+	if m.regions == 2 {
+		indexBits = 3
+		mask := bc7Partitions2[partition]
+		for i := range regionOf {
+			regionOf[i] = int(mask >> uint(i) & 1)
+		}
+		anchor = int(bc7Anchors2[partition])
+	}
+	for i := 0; i < 16; i++ {
+		bits := indexBits
+		if i == 0 || i == anchor {
+			bits--
+		}
+		index := r.read(bits)
+		e0, e1 := endpoints[2*regionOf[i]], endpoints[2*regionOf[i]+1]
+		var px [4]uint16
+		for c := 0; c < 3; c++ {
+			px[c] = bc6hFinishUnquantize(bc7Interpolate(e0[c], e1[c], index, indexBits), signed)
+		}
+		px[3] = 0x3c00
+		for c, v := range px {
+			binary.LittleEndian.PutUint16(texels[8*i+2*c:], v)
+		}
+	}
+}
+
+func signExtend(v int, bits uint) int {
+	shift := 32 - bits
+	return int(int32(uint32(v)<<shift) >> shift)
+}
+
+// bc6hUnquantize expands an endpoint component of bits bits to 16 bits,
+// 15 bits and a sign when signed.
+func bc6hUnquantize(v int, bits uint, signed bool) int {
+	if !signed {
+		switch {
+		case bits >= 15:
+			return v
+		case v == 0:
+			return 0
+		case v == 1<<bits-1:
+			return 0xffff
+		}
+		return (v<<16 + 0x8000) >> bits
+	}
+	if bits >= 16 {
+		return v
+	}
+	neg := v < 0
+	if neg {
+		v = -v
+	}
+	switch {
+	case v == 0:
+	case v >= 1<<(bits-1)-1:
+		v = 0x7fff
+	default:
+		v = (v<<15 + 0x4000) >> (bits - 1)
+	}
+	if neg {
+		return -v
+	}
+	return v
+}
+
+// bc6hFinishUnquantize scales an interpolated component to the bits
+// of a half float.
+func bc6hFinishUnquantize(v int, signed bool) uint16 {
+	if !signed {
+		return uint16(v * 31 >> 6)
+	}
+	if v < 0 {
+		return 0x8000 | uint16(-v*31>>5)
+	}
+	return uint16(v * 31 >> 5)
+}
@@ -0,0 +1,93 @@
+package util
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+// bc6hBlock writes the mode bits, the fields of the endpoints as given and
+// 4-bit indices counting up from texel 0, whose index has 3 bits.
+func bc6hBlock(t *testing.T, mode int, fields ...[2]int) []byte {
+	t.Helper()
+	w := &bitWriter{}
+	if mode < 2 {
+		w.write(mode, 2)
+	} else {
+		w.write(mode, 5)
+	}
+	for _, f := range fields {
+		w.write(f[0], uint(f[1]))
+	}
+	w.write(0, 3)
+	for i := 1; i < 16; i++ {
+		w.write(i, 4)
+	}
+	return w.block(t)
+}
+
+func checkBC6H(t *testing.T, name string, b []byte, signed bool, want [16]uint16) {
+	t.Helper()
+	texels := make([]byte, 16*8)
+	decodeBC6H(b, texels, signed)
+	for i, w := range want {
+		for c := 0; c < 4; c++ {
+			wc := w
+			if c == 3 {
+				wc = 0x3c00
+			}
+			if got := binary.LittleEndian.Uint16(texels[8*i+2*c:]); got != wc {
+				t.Errorf("%s: texel %d channel %d is %#04x, want %#04x", name, i, c, got, wc)
+			}
+		}
+	}
+}
+
+// the halves of the 16 index weights between the unsigned endpoints
+// 0 and 0xffff, and the signed ones -0x7fff and 0x7fff
+var (
+	bc6hUnsignedRamp = [16]uint16{
+		0x0000, 0x07c0, 0x1170, 0x1930, 0x20f0, 0x28b0, 0x3260, 0x3a20,
+		0x41df, 0x499f, 0x534f, 0x5b0f, 0x62cf, 0x6a8f, 0x743f, 0x7bff,
+	}
+	bc6hSignedRamp = [16]uint16{
+		0xfbff, 0xec7f, 0xd91f, 0xc99f, 0xba20, 0xaaa0, 0x9740, 0x87c0,
+		0x07c0, 0x1740, 0x2aa0, 0x3a20, 0x499f, 0x591f, 0x6c7f, 0x7bff,
+	}
+)
+
+func TestDecodeBC6HUntransformed(t *testing.T) {
+	// mode 0x03 has two 10-bit endpoints per channel
+	unsigned := bc6hBlock(t, 0x03,
+		[2]int{0, 10}, [2]int{0, 10}, [2]int{0, 10},
+		[2]int{1023, 10}, [2]int{1023, 10}, [2]int{1023, 10})
+	checkBC6H(t, "unsigned", unsigned, false, bc6hUnsignedRamp)
+
+	// -511 and 511 are the largest signed magnitudes
+	signed := bc6hBlock(t, 0x03,
+		[2]int{-511 & 0x3ff, 10}, [2]int{-511 & 0x3ff, 10}, [2]int{-511 & 0x3ff, 10},
+		[2]int{511, 10}, [2]int{511, 10}, [2]int{511, 10})
+	checkBC6H(t, "signed", signed, true, bc6hSignedRamp)
+}
+
+func TestDecodeBC6HTransformed(t *testing.T) {
+	// mode 0x07 has an 11-bit base endpoint, with bit 10 stored after
+	// the 9-bit delta of each channel; a delta of -1 from 0 wraps to 0x7ff
+	b := bc6hBlock(t, 0x07,
+		[2]int{0, 10}, [2]int{0, 10}, [2]int{0, 10},
+		[2]int{0x1ff, 9}, [2]int{0, 1},
+		[2]int{0x1ff, 9}, [2]int{0, 1},
+		[2]int{0x1ff, 9}, [2]int{0, 1})
+	checkBC6H(t, "unsigned", b, false, bc6hUnsignedRamp)
+	// signed, 0x7ff is -1, a small negative value
+	checkBC6H(t, "signed", b, true, [16]uint16{
+		0x0000, 0x8002, 0x8006, 0x8009, 0x800c, 0x800f, 0x8012, 0x8015,
+		0x8018, 0x801b, 0x801f, 0x8021, 0x8024, 0x8027, 0x802b, 0x802e,
+	})
+}
+
+func TestDecodeBC6HReserved(t *testing.T) {
+	b := make([]byte, 16)
+	// mode bits 10011 are reserved
+	b[0] = 0x13
+	checkBC6H(t, "reserved", b, false, [16]uint16{})
+}
@@ -0,0 +1,235 @@
+package util
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+// bitWriter writes the bits of a 128-bit little-endian block, lowest first,
+// as bitReader reads them.
+type bitWriter struct {
+	lo, hi uint64
+	pos    uint
+}
+
+func (w *bitWriter) write(v int, n uint) {
+	for i := uint(0); i < n; i++ {
+		bit := uint64(v>>i) & 1
+		if p := w.pos + i; p < 64 {
+			w.lo |= bit << p
+		} else {
+			w.hi |= bit << (p - 64)
+		}
+	}
+	w.pos += n
+}
+
+func (w *bitWriter) block(t *testing.T) []byte {
+	t.Helper()
+	if w.pos != 128 {
+		t.Fatalf("block of %d bits", w.pos)
+	}
+	b := make([]byte, 16)
+	binary.LittleEndian.PutUint64(b, w.lo)
+	binary.LittleEndian.PutUint64(b[8:], w.hi)
+	return b
+}
+
+// bc1Block returns a BC1 color block with the texels of each row indexing
+// the colors 0 to 3.
+func bc1Block(c0, c1 uint16) []byte {
+	b := make([]byte, 8)
+	binary.LittleEndian.PutUint16(b, c0)
+	binary.LittleEndian.PutUint16(b[2:], c1)
+	binary.LittleEndian.PutUint32(b[4:], 0xe4e4e4e4)
+	return b
+}
+
+// bc4Block returns a single channel block with texel i indexing value i%8.
+func bc4Block(e0, e1 byte) []byte {
+	b := []byte{e0, e1, 0, 0, 0, 0, 0, 0}
+	var indices uint64
+	for i := uint(0); i < 16; i++ {
+		indices |= uint64(i%8) << (3 * i)
+	}
+	for i := 0; i < 6; i++ {
+		b[2+i] = byte(indices >> (8 * uint(i)))
+	}
+	return b
+}
+
+func checkBlock(t *testing.T, name string, got *[16][4]byte, want func(i int) [4]byte) {
+	t.Helper()
+	for i := range got {
+		if w := want(i); got[i] != w {
+			t.Errorf("%s: texel (%d,%d) is %v, want %v", name, i%4, i/4, got[i], w)
+		}
+	}
+}
+
+// rowColors returns the colors by the column of the texel.
+func rowColors(colors ...[4]byte) func(i int) [4]byte {
+	return func(i int) [4]byte {
+		return colors[i%4]
+	}
+}
+
+func TestDecodeBC1(t *testing.T) {
+	var got [16][4]byte
+	// red and blue, interpolated in thirds
+	decodeBC1(bc1Block(0xf800, 0x001f), &got, true, false)
+	checkBlock(t, "4 colors", &got, rowColors(
+		[4]byte{255, 0, 0, 255}, [4]byte{0, 0, 255, 255},
+		[4]byte{170, 0, 85, 255}, [4]byte{85, 0, 170, 255},
+	))
+
+	// c0 <= c1 selects 3 colors and black
+	decodeBC1(bc1Block(0x001f, 0xf800), &got, true, false)
+	checkBlock(t, "3 colors with alpha", &got, rowColors(
+		[4]byte{0, 0, 255, 255}, [4]byte{255, 0, 0, 255},
+		[4]byte{127, 0, 127, 255}, [4]byte{0, 0, 0, 0},
+	))
+	decodeBC1(bc1Block(0x001f, 0xf800), &got, false, false)
+	checkBlock(t, "3 colors", &got, rowColors(
+		[4]byte{0, 0, 255, 255}, [4]byte{255, 0, 0, 255},
+		[4]byte{127, 0, 127, 255}, [4]byte{0, 0, 0, 255},
+	))
+}
+
+func TestDecodeBC2(t *testing.T) {
+	var alpha uint64
+	for i := uint(0); i < 16; i++ {
+		alpha |= uint64(i) << (4 * i)
+	}
+	b := make([]byte, 8, 16)
+	binary.LittleEndian.PutUint64(b, alpha)
+	// the color block always has 4 colors
+	b = append(b, bc1Block(0x001f, 0xf800)...)
+	var got [16][4]byte
+	decodeBC2(b, &got)
+	colors := [4][3]byte{{0, 0, 255}, {255, 0, 0}, {85, 0, 170}, {170, 0, 85}}
+	checkBlock(t, "BC2", &got, func(i int) [4]byte {
+		c := colors[i%4]
+		return [4]byte{c[0], c[1], c[2], byte(17 * i)}
+	})
+}
+
+func TestDecodeBC3(t *testing.T) {
+	b := append(bc4Block(255, 0), bc1Block(0xf800, 0x001f)...)
+	var got [16][4]byte
+	decodeBC3(b, &got)
+	alpha := [8]byte{255, 0, 219, 182, 146, 109, 73, 36}
+	colors := [4][3]byte{{255, 0, 0}, {0, 0, 255}, {170, 0, 85}, {85, 0, 170}}
+	checkBlock(t, "BC3", &got, func(i int) [4]byte {
+		c := colors[i%4]
+		return [4]byte{c[0], c[1], c[2], alpha[i%8]}
+	})
+}
+
+func TestDecodeBC4(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		e0, e1 byte
+		signed bool
+		values [8]int
+	}{
+		{"unsigned 8 values", 255, 0, false, [8]int{255, 0, 219, 182, 146, 109, 73, 36}},
+		{"unsigned 6 values", 0, 255, false, [8]int{0, 255, 51, 102, 153, 204, 0, 255}},
+		{"signed 8 values", 0x7f, 0x81, true, [8]int{127, -127, 91, 54, 18, -18, -54, -91}},
+		{"signed 6 values", 0x81, 0x7f, true, [8]int{-127, 127, -76, -25, 25, 76, -127, 127}},
+		// -128 is read as -127
+		{"signed -128", 0x80, 0x7f, true, [8]int{-127, 127, -76, -25, 25, 76, -127, 127}},
+	} {
+		var got [16][4]byte
+		decodeBC4(bc4Block(tc.e0, tc.e1), &got, tc.signed)
+		checkBlock(t, tc.name, &got, func(i int) [4]byte {
+			if tc.signed {
+				return [4]byte{byte(int8(tc.values[i%8])), 0, 0, 127}
+			}
+			return [4]byte{byte(tc.values[i%8]), 0, 0, 255}
+		})
+	}
+}
+
+func TestDecodeBC5(t *testing.T) {
+	red := [8]int{-127, 127, -76, -25, 25, 76, -127, 127}
+	green := [8]int{127, -127, 91, 54, 18, -18, -54, -91}
+	var got [16][4]byte
+	decodeBC5(append(bc4Block(0x80, 0x7f), bc4Block(0x7f, 0x81)...), &got, true)
+	checkBlock(t, "signed", &got, func(i int) [4]byte {
+		return [4]byte{byte(int8(red[i%8])), byte(int8(green[i%8])), 0, 127}
+	})
+	decodeBC5(append(bc4Block(255, 0), bc4Block(0, 255)...), &got, false)
+	checkBlock(t, "unsigned", &got, func(i int) [4]byte {
+		r := [8]byte{255, 0, 219, 182, 146, 109, 73, 36}
+		g := [8]byte{0, 255, 51, 102, 153, 204, 0, 255}
+		return [4]byte{r[i%8], g[i%8], 0, 255}
+	})
+}
+
+func TestDecodeBC7(t *testing.T) {
+	var got [16][4]byte
+
+	// mode 6: 7-bit endpoints with a p-bit each, 4-bit indices
+	w := &bitWriter{}
+	w.write(1<<6, 7)
+	for _, v := range []int{0, 127, 127, 127, 0, 0, 127, 127} {
+		w.write(v, 7)
+	}
+	// both p-bits set
+	w.write(3, 2)
+	w.write(0, 3)
+	for i := 1; i < 16; i++ {
+		w.write(i, 4)
+	}
+	decodeBC7(w.block(t), &got)
+	red := [16]byte{1, 17, 37, 53, 68, 84, 104, 120, 136, 152, 172, 188, 203, 219, 239, 255}
+	checkBlock(t, "mode 6", &got, func(i int) [4]byte {
+		return [4]byte{red[i], 255, 1, 255}
+	})
+
+	// mode 5: rotation 1 swaps red and alpha
+	w = &bitWriter{}
+	w.write(1<<5, 6)
+	w.write(1, 2)
+	for _, v := range []int{0, 127, 0, 0, 0, 0} {
+		w.write(v, 7)
+	}
+	w.write(255, 8)
+	w.write(255, 8)
+	w.write(1, 1)
+	for i := 1; i < 16; i++ {
+		w.write(1, 2)
+	}
+	w.write(0, 31)
+	decodeBC7(w.block(t), &got)
+	checkBlock(t, "mode 5", &got, func(i int) [4]byte {
+		return [4]byte{255, 0, 0, 84}
+	})
+
+	// mode 1: partition 13 puts the bottom two rows in subset 1,
+	// with texel 15 as its anchor
+	w = &bitWriter{}
+	w.write(1<<1, 2)
+	w.write(13, 6)
+	for c := 0; c < 3; c++ {
+		for _, v := range []int{0, 0, 63, 63} {
+			w.write(v, 6)
+		}
+	}
+	w.write(0, 1)
+	w.write(1, 1)
+	w.write(0, 16*3-2)
+	decodeBC7(w.block(t), &got)
+	checkBlock(t, "mode 1", &got, func(i int) [4]byte {
+		if i >= 8 {
+			return [4]byte{255, 255, 255, 255}
+		}
+		return [4]byte{0, 0, 0, 255}
+	})
+
+	decodeBC7(make([]byte, 16), &got)
+	checkBlock(t, "reserved mode", &got, func(i int) [4]byte {
+		return [4]byte{}
+	})
+}
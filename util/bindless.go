@@ -0,0 +1,144 @@
+package util
+
+import (
+	"errors"
+	"unsafe"
+
+	as "github.com/vulkan-go/asche"
+	vk "github.com/vulkan-go/vulkan"
+)
+
+// bindlessTextureCount is the number of textures of the texture table.
+const bindlessTextureCount = 4096
+
+// bindlessTable is the texture table of devices with descriptor indexing:
+// a single descriptor set with an array of all the 2D textures, indexed by
+// the scene pipeline with the ID of the texture of each draw. The array is
+// partially bound and updated after bind, so textures come and go while
+// command buffers using the set are pending. The slots of released
+// textures are reused.
+type bindlessTable struct {
+	layout vk.DescriptorSetLayout
+	pool   vk.DescriptorPool
+	set    vk.DescriptorSet
+	free   []uint32
+	next   uint32
+}
+
+func newBindlessTable(dev vk.Device) *bindlessTable {
+	t := &bindlessTable{}
+	bindingFlags := vk.DescriptorSetLayoutBindingFlagsCreateInfo{
+		SType:        vk.StructureTypeDescriptorSetLayoutBindingFlagsCreateInfo,
+		BindingCount: 1,
+		PBindingFlags: []vk.DescriptorBindingFlags{
+			vk.DescriptorBindingFlags(vk.DescriptorBindingUpdateAfterBindBit |
+				vk.DescriptorBindingUpdateUnusedWhilePendingBit |
+				vk.DescriptorBindingPartiallyBoundBit),
+		},
+	}
+	flagsRef, _ := bindingFlags.PassRef()
+	ret := vk.CreateDescriptorSetLayout(dev, &vk.DescriptorSetLayoutCreateInfo{
+		SType:        vk.StructureTypeDescriptorSetLayoutCreateInfo,
+		PNext:        unsafe.Pointer(flagsRef),
+		Flags:        vk.DescriptorSetLayoutCreateFlags(vk.DescriptorSetLayoutCreateUpdateAfterBindPoolBit),
+		BindingCount: 1,
+		PBindings: []vk.DescriptorSetLayoutBinding{{
+			Binding:         0,
+			DescriptorType:  vk.DescriptorTypeCombinedImageSampler,
+			DescriptorCount: bindlessTextureCount,
+			StageFlags:      vk.ShaderStageFlags(vk.ShaderStageFragmentBit),
+		}},
+	}, nil, &t.layout)
+	orPanic(as.NewError(ret))
+
+	ret = vk.CreateDescriptorPool(dev, &vk.DescriptorPoolCreateInfo{
+		SType:         vk.StructureTypeDescriptorPoolCreateInfo,
+		Flags:         vk.DescriptorPoolCreateFlags(vk.DescriptorPoolCreateUpdateAfterBindBit),
+		MaxSets:       1,
+		PoolSizeCount: 1,
+		PPoolSizes: []vk.DescriptorPoolSize{{
+			Type:            vk.DescriptorTypeCombinedImageSampler,
+			DescriptorCount: bindlessTextureCount,
+		}},
+	}, nil, &t.pool)
+	orPanic(as.NewError(ret))
+
+	ret = vk.AllocateDescriptorSets(dev, &vk.DescriptorSetAllocateInfo{
+		SType:              vk.StructureTypeDescriptorSetAllocateInfo,
+		DescriptorPool:     t.pool,
+		DescriptorSetCount: 1,
+		PSetLayouts:        []vk.DescriptorSetLayout{t.layout},
+	}, &t.set)
+	orPanic(as.NewError(ret))
+	return t
+}
+
+// add writes tex into a free slot of the table, its ID.
+func (t *bindlessTable) add(dev vk.Device, tex *Texture) {
+	var index uint32
+	switch {
+	case len(t.free) > 0:
+		index = t.free[len(t.free)-1]
+		t.free = t.free[:len(t.free)-1]
+	case t.next < bindlessTextureCount:
+		index = t.next
+		t.next++
+	default:
+		orPanic(errors.New("texture: texture table full"))
+	}
+
+	vk.UpdateDescriptorSets(dev, 1, []vk.WriteDescriptorSet{{
+		SType:           vk.StructureTypeWriteDescriptorSet,
+		DstSet:          t.set,
+		DstArrayElement: index,
+		DescriptorCount: 1,
+		DescriptorType:  vk.DescriptorTypeCombinedImageSampler,
+		PImageInfo: []vk.DescriptorImageInfo{{
+			Sampler:     tex.sampler,
+			ImageView:   tex.view,
+			ImageLayout: tex.imageLayout,
+		}},
+	}}, 0, nil)
+	tex.bindless = true
+	tex.index = index
+}
+
+// remove frees the slot of tex. Its descriptor is left in place,
+// no draw indexes it anymore.
+func (t *bindlessTable) remove(tex *Texture) {
+	t.free = append(t.free, tex.index)
+	tex.bindless = false
+}
+
+func (t *bindlessTable) destroy(dev vk.Device) {
+	vk.DestroyDescriptorPool(dev, t.pool, nil)
+	vk.DestroyDescriptorSetLayout(dev, t.layout, nil)
+}
+
+// ID returns the index of t in the texture table, or -1 when the device
+// lacks descriptor indexing and for cube and array textures, which are
+// not in the table.
+func (t *Texture) ID() int {
+	if !t.bindless {
+		return -1
+	}
+	return int(t.index)
+}
+
+// sceneLayout returns the pipeline layout the meshes are drawn with,
+// with the texture table as set 1 when there is one.
+func (s *SpinningCube) sceneLayout() vk.PipelineLayout {
+	if s.textures.bindless != nil {
+		return s.bindlessLayout
+	}
+	return s.pipelineLayout
+}
+
+// bindTextureTable binds the texture table, if there is one, as set 1
+// of the scene layout for the meshes drawn next.
+func (s *SpinningCube) bindTextureTable(cmd vk.CommandBuffer) {
+	if t := s.textures.bindless; t != nil {
+		vk.CmdBindDescriptorSets(cmd, vk.PipelineBindPointGraphics, s.bindlessLayout,
+			1, 1, []vk.DescriptorSet{t.set}, 0, nil)
+	}
+}
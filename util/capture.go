@@ -0,0 +1,285 @@
+package util
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/png"
+	"os"
+
+	as "github.com/vulkan-go/asche"
+	vk "github.com/vulkan-go/vulkan"
+)
+
+// CaptureFrame reads back the frame currently drawn by s.
+//
+// When rendering headless the offscreen color target is read back as is.
+// With a Window the next frame is rendered and presented, and its swapchain
+// image is copied as presented. Swapchain images of the asche context are
+// not created with transfer-source usage, there the scene is redrawn with
+// the current uniforms into an offscreen image of the swapchain format and
+// read back from there.
+func (s *SpinningCube) CaptureFrame() (img *image.RGBA, err error) {
+	defer checkErr(&err)
+
+	rc := s.rc()
+	dev := rc.Device()
+	ret := vk.DeviceWaitIdle(dev)
+	orPanic(as.NewError(ret))
+
+	if w := s.window; w != nil && w.transferSrc {
+		return s.captureWindowFrame(w), nil
+	}
+
+	cmdPool := newCommandPool(dev, rc.GraphicsQueueFamilyIndex())
+	defer vk.DestroyCommandPool(dev, cmdPool, nil)
+
+	if s.headless != nil {
+		return s.readbackImage(cmdPool, s.headless.target.image), nil
+	}
+
+	renderPass := s.createRenderPass(vk.ImageLayoutTransferSrcOptimal)
+	defer vk.DestroyRenderPass(dev, renderPass, nil)
+	target := newOffscreenTarget(dev, s.memory, s.width, s.height, s.format)
+	defer target.Destroy(dev)
+	target.SetFramebuffer(s.createFramebuffer(renderPass, target.View()))
+	target.SetDescriptorSet(rc.ImageResources()[s.imageIdx].DescriptorSet())
+
+	cmd := allocCommandBuffer(dev, cmdPool)
+	ret = vk.BeginCommandBuffer(cmd, &vk.CommandBufferBeginInfo{
+		SType: vk.StructureTypeCommandBufferBeginInfo,
+		Flags: vk.CommandBufferUsageFlags(vk.CommandBufferUsageOneTimeSubmitBit),
+	})
+	orPanic(as.NewError(ret))
+	// the region of the live frame is left alone, frames replaying
+	// their command buffers still read its slots
+	s.drawRenderPass(cmd, renderPass, target, s.uniforms.scratch())
+	ret = vk.EndCommandBuffer(cmd)
+	orPanic(as.NewError(ret))
+	submitAndWait(dev, rc.GraphicsQueue(), cmd)
+
+	return s.readbackImage(cmdPool, target.image), nil
+}
+
+// captureWindowFrame renders and presents the next frame of w, copying its
+// swapchain image into a readback buffer before it is presented.
+func (s *SpinningCube) captureWindowFrame(w *windowContext) *image.RGBA {
+	dev := w.device
+	for {
+		rb := s.newReadback()
+		presented := w.frame(s, rb)
+		if presented {
+			ret := vk.DeviceWaitIdle(dev)
+			orPanic(as.NewError(ret))
+			img := rb.image()
+			rb.destroy(dev)
+			return img
+		}
+		// the swapchain was recreated, the frame was skipped
+		rb.destroy(dev)
+	}
+}
+
+// CapturePNG captures the current frame and writes it to path as PNG.
+func (s *SpinningCube) CapturePNG(path string) error {
+	img, err := s.CaptureFrame()
+	if err != nil {
+		return err
+	}
+	return SavePNG(path, img)
+}
+
+// SavePNG encodes img as PNG into the file at path.
+func SavePNG(path string, img image.Image) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := png.Encode(f, img); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// readbackImage copies src, a color image of the size and format of s in
+// vk.ImageLayoutTransferSrcOptimal, into a host-visible buffer and returns
+// it as an RGBA image.
+func (s *SpinningCube) readbackImage(cmdPool vk.CommandPool, src vk.Image) *image.RGBA {
+	rc := s.rc()
+	dev := rc.Device()
+	rb := s.newReadback()
+	defer rb.destroy(dev)
+
+	cmd := allocCommandBuffer(dev, cmdPool)
+	ret := vk.BeginCommandBuffer(cmd, &vk.CommandBufferBeginInfo{
+		SType: vk.StructureTypeCommandBufferBeginInfo,
+		Flags: vk.CommandBufferUsageFlags(vk.CommandBufferUsageOneTimeSubmitBit),
+	})
+	orPanic(as.NewError(ret))
+	rb.record(cmd, src, vk.ImageLayoutTransferSrcOptimal)
+	ret = vk.EndCommandBuffer(cmd)
+	orPanic(as.NewError(ret))
+	submitAndWait(dev, rc.GraphicsQueue(), cmd)
+	return rb.image()
+}
+
+// readback is a host-visible buffer a color image of the size and format
+// of s is copied into, to be read back once the copy has completed.
+type readback struct {
+	buf     vk.Buffer
+	mem     *memoryAllocation
+	width   uint32
+	height  uint32
+	swizzle bool
+}
+
+func (s *SpinningCube) newReadback() *readback {
+	rb := &readback{
+		width:  s.width,
+		height: s.height,
+	}
+	switch s.format {
+	case vk.FormatR8g8b8a8Unorm, vk.FormatR8g8b8a8Srgb:
+	case vk.FormatB8g8r8a8Unorm, vk.FormatB8g8r8a8Srgb:
+		rb.swizzle = true
+	default:
+		orPanic(fmt.Errorf("vulkan: cannot read back images of format %d", s.format))
+	}
+	rb.buf, rb.mem = s.createBuffer(int(rb.width*rb.height*4), vk.BufferUsageTransferDstBit,
+		vk.MemoryPropertyHostVisibleBit|vk.MemoryPropertyHostCoherentBit, allocFreeList)
+	if rb.mem.data() == nil {
+		rb.destroy(s.rc().Device())
+		orPanic(errors.New("vulkan: readback buffer memory is not mapped"))
+	}
+	return rb
+}
+
+// record records the copy of src, rendered in layout, into the buffer.
+// An image in another layout than vk.ImageLayoutTransferSrcOptimal is
+// transitioned for the copy and back to layout after it.
+func (rb *readback) record(cmd vk.CommandBuffer, src vk.Image, layout vk.ImageLayout) {
+	colorRange := vk.ImageSubresourceRange{
+		AspectMask: vk.ImageAspectFlags(vk.ImageAspectColorBit),
+		LevelCount: 1,
+		LayerCount: 1,
+	}
+	if layout != vk.ImageLayoutTransferSrcOptimal {
+		vk.CmdPipelineBarrier(cmd,
+			vk.PipelineStageFlags(vk.PipelineStageColorAttachmentOutputBit),
+			vk.PipelineStageFlags(vk.PipelineStageTransferBit),
+			0, 0, nil, 0, nil, 1, []vk.ImageMemoryBarrier{{
+				SType:               vk.StructureTypeImageMemoryBarrier,
+				SrcAccessMask:       vk.AccessFlags(vk.AccessColorAttachmentWriteBit),
+				DstAccessMask:       vk.AccessFlags(vk.AccessTransferReadBit),
+				OldLayout:           layout,
+				NewLayout:           vk.ImageLayoutTransferSrcOptimal,
+				SrcQueueFamilyIndex: vk.QueueFamilyIgnored,
+				DstQueueFamilyIndex: vk.QueueFamilyIgnored,
+				Image:               src,
+				SubresourceRange:    colorRange,
+			}})
+	}
+	size := vk.DeviceSize(rb.width * rb.height * 4)
+	vk.CmdCopyImageToBuffer(cmd, src, vk.ImageLayoutTransferSrcOptimal, rb.buf,
+		1, []vk.BufferImageCopy{{
+			ImageSubresource: vk.ImageSubresourceLayers{
+				AspectMask: vk.ImageAspectFlags(vk.ImageAspectColorBit),
+				LayerCount: 1,
+			},
+			ImageExtent: vk.Extent3D{
+				Width:  rb.width,
+				Height: rb.height,
+				Depth:  1,
+			},
+		}})
+	// make the transfer write visible to the host before mapping
+	vk.CmdPipelineBarrier(cmd,
+		vk.PipelineStageFlags(vk.PipelineStageTransferBit),
+		vk.PipelineStageFlags(vk.PipelineStageHostBit),
+		0, 0, nil, 1, []vk.BufferMemoryBarrier{{
+			SType:               vk.StructureTypeBufferMemoryBarrier,
+			SrcAccessMask:       vk.AccessFlags(vk.AccessTransferWriteBit),
+			DstAccessMask:       vk.AccessFlags(vk.AccessHostReadBit),
+			SrcQueueFamilyIndex: vk.QueueFamilyIgnored,
+			DstQueueFamilyIndex: vk.QueueFamilyIgnored,
+			Buffer:              rb.buf,
+			Size:                size,
+		}}, 0, nil)
+	if layout != vk.ImageLayoutTransferSrcOptimal {
+		vk.CmdPipelineBarrier(cmd,
+			vk.PipelineStageFlags(vk.PipelineStageTransferBit),
+			vk.PipelineStageFlags(vk.PipelineStageBottomOfPipeBit),
+			0, 0, nil, 0, nil, 1, []vk.ImageMemoryBarrier{{
+				SType:               vk.StructureTypeImageMemoryBarrier,
+				SrcAccessMask:       vk.AccessFlags(vk.AccessTransferReadBit),
+				OldLayout:           vk.ImageLayoutTransferSrcOptimal,
+				NewLayout:           layout,
+				SrcQueueFamilyIndex: vk.QueueFamilyIgnored,
+				DstQueueFamilyIndex: vk.QueueFamilyIgnored,
+				Image:               src,
+				SubresourceRange:    colorRange,
+			}})
+	}
+}
+
+// image returns the contents of the buffer as an RGBA image,
+// once the copy has completed.
+func (rb *readback) image() *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, int(rb.width), int(rb.height)))
+	copy(img.Pix, rb.mem.data())
+	if rb.swizzle {
+		for i := 0; i < len(img.Pix); i += 4 {
+			img.Pix[i], img.Pix[i+2] = img.Pix[i+2], img.Pix[i]
+		}
+	}
+	return img
+}
+
+func (rb *readback) destroy(dev vk.Device) {
+	vk.DestroyBuffer(dev, rb.buf, nil)
+	rb.mem.free()
+}
+
+func newCommandPool(dev vk.Device, queueFamilyIndex uint32) vk.CommandPool {
+	var cmdPool vk.CommandPool
+	ret := vk.CreateCommandPool(dev, &vk.CommandPoolCreateInfo{
+		SType:            vk.StructureTypeCommandPoolCreateInfo,
+		QueueFamilyIndex: queueFamilyIndex,
+		Flags:            vk.CommandPoolCreateFlags(vk.CommandPoolCreateResetCommandBufferBit),
+	}, nil, &cmdPool)
+	orPanic(as.NewError(ret))
+	return cmdPool
+}
+
+func allocCommandBuffer(dev vk.Device, cmdPool vk.CommandPool) vk.CommandBuffer {
+	cmd := make([]vk.CommandBuffer, 1)
+	ret := vk.AllocateCommandBuffers(dev, &vk.CommandBufferAllocateInfo{
+		SType:              vk.StructureTypeCommandBufferAllocateInfo,
+		CommandPool:        cmdPool,
+		Level:              vk.CommandBufferLevelPrimary,
+		CommandBufferCount: 1,
+	}, cmd)
+	orPanic(as.NewError(ret))
+	return cmd[0]
+}
+
+// submitAndWait executes cmd on queue and blocks until it has completed.
+func submitAndWait(dev vk.Device, queue vk.Queue, cmd vk.CommandBuffer) {
+	var fence vk.Fence
+	ret := vk.CreateFence(dev, &vk.FenceCreateInfo{
+		SType: vk.StructureTypeFenceCreateInfo,
+	}, nil, &fence)
+	orPanic(as.NewError(ret))
+	defer vk.DestroyFence(dev, fence, nil)
+
+	ret = vk.QueueSubmit(queue, 1, []vk.SubmitInfo{{
+		SType:              vk.StructureTypeSubmitInfo,
+		CommandBufferCount: 1,
+		PCommandBuffers:    []vk.CommandBuffer{cmd},
+	}}, fence)
+	orPanic(as.NewError(ret))
+
+	ret = vk.WaitForFences(dev, 1, []vk.Fence{fence}, vk.True, vk.MaxUint64)
+	orPanic(as.NewError(ret))
+}
@@ -0,0 +1,130 @@
+package util
+
+import (
+	as "github.com/vulkan-go/asche"
+	vk "github.com/vulkan-go/vulkan"
+)
+
+// renderContext is the subset of a Vulkan context the SpinningCube prepare steps
+// depend on. It is backed by the asche swapchain context, by a window with a
+// swapchain and frames in flight of its own, or by a headless device
+// rendering into an offscreen target.
+type renderContext interface {
+	Device() vk.Device
+	PhysicalDevice() vk.PhysicalDevice
+	MemoryProperties() vk.PhysicalDeviceMemoryProperties
+	GraphicsQueueFamilyIndex() uint32
+	PresentQueueFamilyIndex() uint32
+	GraphicsQueue() vk.Queue
+	// Features returns the optional device features enabled on Device.
+	Features() vk.PhysicalDeviceFeatures
+	// DescriptorIndexing reports whether the VK_EXT_descriptor_indexing
+	// features of the texture table are enabled on Device.
+	DescriptorIndexing() bool
+	// MemoryBudget returns the driver budget and usage of the heaps,
+	// reporting false unless VK_EXT_memory_budget is enabled on Device.
+	MemoryBudget() (memoryBudget, bool)
+
+	// CommandBuffer returns the setup command buffer, which is submitted
+	// once VulkanContextPrepare has returned.
+	CommandBuffer() vk.CommandBuffer
+	Dimensions() *as.SwapchainDimensions
+	ImageResources() []imageResources
+	// FramesInFlight is the number of frames the CPU may record ahead
+	// of the GPU, each writing a region of the uniform ring of its own.
+	FramesInFlight() int
+
+	// ColorFinalLayout is the layout the color attachment is left in
+	// at the end of the render pass.
+	ColorFinalLayout() vk.ImageLayout
+}
+
+// imageResources are the per-image resources a frame is rendered with,
+// as provided by *as.SwapchainImageResources. CommandBuffer is nil when
+// frames are recorded into command buffers of their own, see Window.
+type imageResources interface {
+	Image() vk.Image
+	View() vk.ImageView
+	Framebuffer() vk.Framebuffer
+	SetFramebuffer(fb vk.Framebuffer)
+	DescriptorSet() vk.DescriptorSet
+	SetDescriptorSet(set vk.DescriptorSet)
+	CommandBuffer() vk.CommandBuffer
+}
+
+type swapchainContext struct {
+	as.Context
+}
+
+func (c swapchainContext) PhysicalDevice() vk.PhysicalDevice {
+	return c.Platform().PhysicalDevice()
+}
+
+func (c swapchainContext) MemoryProperties() vk.PhysicalDeviceMemoryProperties {
+	return c.Platform().MemoryProperties()
+}
+
+func (c swapchainContext) GraphicsQueueFamilyIndex() uint32 {
+	return c.Platform().GraphicsQueueFamilyIndex()
+}
+
+func (c swapchainContext) PresentQueueFamilyIndex() uint32 {
+	return c.Platform().PresentQueueFamilyIndex()
+}
+
+func (c swapchainContext) GraphicsQueue() vk.Queue {
+	return c.Platform().GraphicsQueue()
+}
+
+// Features returns no features, asche creates the device without
+// enabling any of the optional ones. Anisotropic filtering is unavailable,
+// the sampler cache logs it when asked for, and compressed textures are
+// decompressed on the CPU.
+func (c swapchainContext) Features() vk.PhysicalDeviceFeatures {
+	return vk.PhysicalDeviceFeatures{}
+}
+
+// DescriptorIndexing reports false, asche can't chain the
+// descriptor indexing features to the device create info.
+func (c swapchainContext) DescriptorIndexing() bool {
+	return false
+}
+
+// MemoryBudget reports false, the asche instance is created for
+// Vulkan 1.0, without vkGetPhysicalDeviceMemoryProperties2.
+func (c swapchainContext) MemoryBudget() (memoryBudget, bool) {
+	return memoryBudget{}, false
+}
+
+func (c swapchainContext) Dimensions() *as.SwapchainDimensions {
+	return c.SwapchainDimensions()
+}
+
+func (c swapchainContext) ImageResources() []imageResources {
+	swapchainImageResources := c.SwapchainImageResources()
+	res := make([]imageResources, 0, len(swapchainImageResources))
+	for _, r := range swapchainImageResources {
+		res = append(res, r)
+	}
+	return res
+}
+
+// FramesInFlight returns the number of swapchain images, the command
+// buffers of which are replayed with the uniforms of their image.
+func (c swapchainContext) FramesInFlight() int {
+	return len(c.SwapchainImageResources())
+}
+
+func (c swapchainContext) ColorFinalLayout() vk.ImageLayout {
+	return vk.ImageLayoutPresentSrc
+}
+
+func (s *SpinningCube) rc() renderContext {
+	if s.headless != nil {
+		return s.headless
+	}
+	if s.window != nil {
+		return s.window
+	}
+	return swapchainContext{s.Context()}
+}
@@ -0,0 +1,138 @@
+package util
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+
+	vk "github.com/vulkan-go/vulkan"
+)
+
+// CubemapRef names the images of a cubemap texture, either six face images
+// or an equirectangular panorama, typically an HDR image.
+type CubemapRef struct {
+	// Faces are the square images of the +X, -X, +Y, -Y, +Z and -Z faces,
+	// all of the same size and format.
+	Faces [6]TextureRef
+	// Equirect is the panorama the faces are resampled from when set,
+	// into faces of FaceSize texels, a quarter of its width when zero.
+	Equirect TextureRef
+	FaceSize int
+	// Sampler describes how the cubemap is sampled,
+	// nil selects the default of the TextureManager.
+	Sampler *SamplerDesc
+}
+
+func (r CubemapRef) name() string {
+	if !r.Equirect.IsZero() {
+		return fmt.Sprintf("%s@%d", r.Equirect.Name, r.FaceSize)
+	}
+	names := make([]string, len(r.Faces))
+	for i, f := range r.Faces {
+		names[i] = f.Name
+	}
+	return strings.Join(names, "|")
+}
+
+// prepareCubemap loads the faces of ref into a cube texture.
+func (s *SpinningCube) prepareCubemap(ref CubemapRef) *Texture {
+	var faces []*TextureImage
+	if !ref.Equirect.IsZero() {
+		img := s.loadTextureImage(ref.Equirect)
+		if isCompressed(img.Format) {
+			// the panorama is resampled on the CPU
+			var err error
+			img, err = decompress(img)
+			orPanic(err)
+		}
+		faces = equirectFaces(img, ref.FaceSize)
+	} else {
+		for _, f := range ref.Faces {
+			if f.IsZero() {
+				orPanic(errors.New("texture: cubemap without a face image"))
+			}
+			faces = append(faces, s.loadTextureImage(f))
+		}
+	}
+	if faces[0].Width != faces[0].Height {
+		orPanic(fmt.Errorf("texture: cubemap faces of %dx%d texels are not square",
+			faces[0].Width, faces[0].Height))
+	}
+	return s.prepareLayeredTexture(faces, vk.ImageViewTypeCube)
+}
+
+// cubeDirection returns the direction of the texel at u, v in [-1, 1] of
+// the cube face, with v pointing down the face image.
+func cubeDirection(face int, u, v float64) (x, y, z float64) {
+	switch face {
+	case 0:
+		return 1, -v, -u
+	case 1:
+		return -1, -v, u
+	case 2:
+		return u, 1, v
+	case 3:
+		return u, -1, -v
+	case 4:
+		return u, -v, 1
+	}
+	return -u, -v, -1
+}
+
+// equirectFaces resamples the base level of the equirectangular img into
+// the six faces of a cubemap of size x size texels, with bilinear filtering.
+// The center of the panorama faces -Z, and its top +Y.
+func equirectFaces(img *TextureImage, size int) []*TextureImage {
+	if size <= 0 {
+		size = img.Width / 4
+	}
+	if size <= 0 {
+		orPanic(errors.New("texture: equirectangular image too small"))
+	}
+	src := decodeTexels(img.Format, img.Levels[0])
+	w, h := img.Width, img.Height
+	texel := func(x, y int) []float32 {
+		x = (x%w + w) % w
+		if y < 0 {
+			y = 0
+		} else if y >= h {
+			y = h - 1
+		}
+		return src[4*(y*w+x):]
+	}
+
+	faces := make([]*TextureImage, 6)
+	for face := range faces {
+		dst := make([]float32, 4*size*size)
+		for y := 0; y < size; y++ {
+			for x := 0; x < size; x++ {
+				u := 2*(float64(x)+0.5)/float64(size) - 1
+				v := 2*(float64(y)+0.5)/float64(size) - 1
+				dx, dy, dz := cubeDirection(face, u, v)
+				lon := math.Atan2(dx, -dz)
+				lat := math.Atan2(dy, math.Hypot(dx, dz))
+				sx := (0.5+lon/(2*math.Pi))*float64(w) - 0.5
+				sy := (0.5-lat/math.Pi)*float64(h) - 0.5
+
+				x0, y0 := int(math.Floor(sx)), int(math.Floor(sy))
+				fx, fy := float32(sx-float64(x0)), float32(sy-float64(y0))
+				p00, p10 := texel(x0, y0), texel(x0+1, y0)
+				p01, p11 := texel(x0, y0+1), texel(x0+1, y0+1)
+				p := dst[4*(y*size+x):]
+				for c := 0; c < 4; c++ {
+					top := p00[c] + (p10[c]-p00[c])*fx
+					bottom := p01[c] + (p11[c]-p01[c])*fx
+					p[c] = top + (bottom-top)*fy
+				}
+			}
+		}
+		faces[face] = &TextureImage{
+			Format: img.Format,
+			Width:  size,
+			Height: size,
+			Levels: [][]byte{encodeTexels(img.Format, dst)},
+		}
+	}
+	return faces
+}
@@ -7,9 +7,9 @@ import (
 )
 
 type vkTexCubeUniform struct {
-	mvp      lin.Mat4x4
-	position [12 * 3][4]float32
-	attr     [12 * 3][4]float32
+	mvp lin.Mat4x4
+	// skyInvViewProj maps clip space to the skybox directions
+	skyInvViewProj lin.Mat4x4
 }
 
 const vkTexCubeUniformSize = int(unsafe.Sizeof(vkTexCubeUniform{}))
@@ -50,4 +50,34 @@ var gUVBufferData = []float32{
 	0.0, 1.0,
 	1.0, 1.0,
 	1.0, 0.0,
+}
+
+// cubeMeshData builds the default scene geometry from gVertexBufferData and gUVBufferData,
+// with the normal of the face of each triangle.
+func cubeMeshData() *MeshData {
+	n := len(gVertexBufferData) / 3
+	data := &MeshData{
+		Vertices: make([]Vertex, n),
+		Indices:  make([]uint32, n),
+	}
+	for i := 0; i < n; i++ {
+		data.Vertices[i] = Vertex{
+			Position: [3]float32{
+				gVertexBufferData[i*3], gVertexBufferData[i*3+1], gVertexBufferData[i*3+2],
+			},
+			UV:    [2]float32{gUVBufferData[2*i], gUVBufferData[2*i+1]},
+			Color: [4]float32{1, 1, 1, 1},
+		}
+		data.Indices[i] = uint32(i)
+	}
+	// the triangles share no vertices, each vertex gets its face normal
+	for i := 0; i+2 < n; i += 3 {
+		v := data.Vertices[i : i+3]
+		normal := normalize3(cross3(sub3(v[1].Position, v[0].Position),
+			sub3(v[2].Position, v[0].Position)))
+		for j := range v {
+			v[j].Normal = normal
+		}
+	}
+	return data
 }
\ No newline at end of file
@@ -0,0 +1,179 @@
+package util
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	vk "github.com/vulkan-go/vulkan"
+)
+
+// DDS header flags
+const (
+	ddsMipMapCount = 0x20000
+	ddsFourCC      = 0x4
+	ddsRGB         = 0x40
+	ddsAlphaPixels = 0x1
+	ddsCubemap     = 0x200
+)
+
+// The legacy formats don't tell the color space, DXT textures are taken
+// to hold sRGB colors like the images of the other decoders.
+var ddsFourCCFormats = map[string]vk.Format{
+	"DXT1": vk.FormatBc1RgbaSrgbBlock,
+	"DXT2": vk.FormatBc2SrgbBlock,
+	"DXT3": vk.FormatBc2SrgbBlock,
+	"DXT4": vk.FormatBc3SrgbBlock,
+	"DXT5": vk.FormatBc3SrgbBlock,
+	"ATI1": vk.FormatBc4UnormBlock,
+	"BC4U": vk.FormatBc4UnormBlock,
+	"BC4S": vk.FormatBc4SnormBlock,
+	"ATI2": vk.FormatBc5UnormBlock,
+	"BC5U": vk.FormatBc5UnormBlock,
+	"BC5S": vk.FormatBc5SnormBlock,
+	// D3DFMT_A16B16G16R16F and D3DFMT_A32B32G32R32F
+	"q\x00\x00\x00": vk.FormatR16g16b16a16Sfloat,
+	"t\x00\x00\x00": vk.FormatR32g32b32a32Sfloat,
+}
+
+var dxgiFormats = map[uint32]vk.Format{
+	2:  vk.FormatR32g32b32a32Sfloat,
+	10: vk.FormatR16g16b16a16Sfloat,
+	28: vk.FormatR8g8b8a8Unorm,
+	29: vk.FormatR8g8b8a8Srgb,
+	31: vk.FormatR8g8b8a8Snorm,
+	71: vk.FormatBc1RgbaUnormBlock,
+	72: vk.FormatBc1RgbaSrgbBlock,
+	74: vk.FormatBc2UnormBlock,
+	75: vk.FormatBc2SrgbBlock,
+	77: vk.FormatBc3UnormBlock,
+	78: vk.FormatBc3SrgbBlock,
+	80: vk.FormatBc4UnormBlock,
+	81: vk.FormatBc4SnormBlock,
+	83: vk.FormatBc5UnormBlock,
+	84: vk.FormatBc5SnormBlock,
+	95: vk.FormatBc6hUfloatBlock,
+	96: vk.FormatBc6hSfloatBlock,
+	98: vk.FormatBc7UnormBlock,
+	99: vk.FormatBc7SrgbBlock,
+	// B8G8R8A8_UNORM and _SRGB, swizzled on load
+	87: vk.FormatB8g8r8a8Unorm,
+	91: vk.FormatB8g8r8a8Srgb,
+}
+
+// decodeDDS reads the mip levels of a 2D DirectDraw Surface, with the
+// formats of the legacy FourCC codes, the DX10 header DXGI formats listed
+// in dxgiFormats, or 32-bit RGB(A) masks.
+func decodeDDS(data []byte) (*TextureImage, error) {
+	if len(data) < 128 {
+		return nil, errors.New("truncated header")
+	}
+	le := binary.LittleEndian
+	flags := le.Uint32(data[8:])
+	height := int(le.Uint32(data[12:]))
+	width := int(le.Uint32(data[16:]))
+	levels := 1
+	if flags&ddsMipMapCount != 0 && le.Uint32(data[28:]) > 0 {
+		levels = int(le.Uint32(data[28:]))
+	}
+	pfFlags := le.Uint32(data[80:])
+	fourCC := string(data[84:88])
+	if le.Uint32(data[112:])&ddsCubemap != 0 {
+		return nil, errors.New("cubemaps are not supported")
+	}
+	if width == 0 || height == 0 {
+		return nil, errors.New("empty image")
+	}
+
+	pos := 128
+	var format vk.Format
+	var masks [4]uint32
+	switch {
+	case pfFlags&ddsFourCC != 0 && fourCC == "DX10":
+		if len(data) < 148 {
+			return nil, errors.New("truncated DX10 header")
+		}
+		dxgi := le.Uint32(data[128:])
+		if le.Uint32(data[140:]) > 1 {
+			return nil, errors.New("texture arrays are not supported")
+		}
+		var ok bool
+		if format, ok = dxgiFormats[dxgi]; !ok {
+			return nil, fmt.Errorf("unsupported DXGI format %d", dxgi)
+		}
+		pos = 148
+	case pfFlags&ddsFourCC != 0:
+		var ok bool
+		if format, ok = ddsFourCCFormats[fourCC]; !ok {
+			return nil, fmt.Errorf("unsupported FourCC %q", fourCC)
+		}
+	case pfFlags&ddsRGB != 0 && le.Uint32(data[88:]) == 32:
+		format = vk.FormatR8g8b8a8Srgb
+		for i := range masks {
+			masks[i] = le.Uint32(data[92+4*i:])
+		}
+		if pfFlags&ddsAlphaPixels == 0 {
+			masks[3] = 0
+		}
+	default:
+		return nil, errors.New("unsupported pixel format")
+	}
+
+	// BGRA texels are read as RGBA with swizzled masks
+	switch format {
+	case vk.FormatB8g8r8a8Unorm:
+		format = vk.FormatR8g8b8a8Unorm
+		masks = [4]uint32{0xff0000, 0xff00, 0xff, 0xff000000}
+	case vk.FormatB8g8r8a8Srgb:
+		format = vk.FormatR8g8b8a8Srgb
+		masks = [4]uint32{0xff0000, 0xff00, 0xff, 0xff000000}
+	}
+
+	img := &TextureImage{
+		Format: format,
+		Width:  width,
+		Height: height,
+	}
+	w, h := width, height
+	for i := 0; i < levels; i++ {
+		size, err := levelSize(format, w, h)
+		if err != nil {
+			return nil, err
+		}
+		if pos+size > len(data) {
+			return nil, fmt.Errorf("truncated level %d", i)
+		}
+		level := data[pos : pos+size]
+		if masks != [4]uint32{} {
+			level = swizzleMasks(level, masks)
+		}
+		img.Levels = append(img.Levels, level)
+		pos += size
+		w, h = mipSize(w), mipSize(h)
+	}
+	return img, nil
+}
+
+// swizzleMasks converts 32-bit texels with the channels at the given
+// R, G, B and A masks into RGBA bytes. A zero alpha mask is opaque.
+func swizzleMasks(src []byte, masks [4]uint32) []byte {
+	var shifts [4]uint
+	for c, m := range masks {
+		for m != 0 && m&1 == 0 {
+			m >>= 1
+			shifts[c]++
+		}
+	}
+	dst := make([]byte, len(src))
+	for i := 0; i+4 <= len(src); i += 4 {
+		v := binary.LittleEndian.Uint32(src[i:])
+		for c, m := range masks {
+			if m == 0 {
+				dst[i+c] = 255
+				continue
+			}
+			dst[i+c] = byte(v & m >> shifts[c])
+		}
+	}
+	return dst
+}
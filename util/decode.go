@@ -0,0 +1,259 @@
+package util
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	_ "image/jpeg"
+	"image/png"
+	"math"
+	"path/filepath"
+	"strings"
+
+	vk "github.com/vulkan-go/vulkan"
+)
+
+// TextureImage is a decoded texture image of Format. Levels holds the
+// tightly packed texels of its mip levels, starting with the Width x Height
+// base level. Missing levels are generated when the texture is created.
+type TextureImage struct {
+	Format vk.Format
+	Width  int
+	Height int
+	Levels [][]byte
+	// SRGB marks floating point texels whose color channels hold sRGB
+	// encoded values, the formats of 8-bit texels say so themselves.
+	SRGB bool
+}
+
+// A Decoder decodes an encoded image into a texture image.
+type Decoder func(data []byte) (*TextureImage, error)
+
+type decoderFormat struct {
+	name   string
+	magic  string
+	exts   []string
+	decode Decoder
+}
+
+var decoders []decoderFormat
+
+// RegisterDecoder registers the decoder of an image format. Data starting
+// with magic is decoded with decode, as is data without a known magic in
+// files named with one of exts. Formats without magic bytes are registered
+// with an empty magic. Later registrations take precedence.
+func RegisterDecoder(name, magic string, exts []string, decode Decoder) {
+	decoders = append([]decoderFormat{{
+		name:   name,
+		magic:  magic,
+		exts:   exts,
+		decode: decode,
+	}}, decoders...)
+}
+
+func init() {
+	RegisterDecoder("tga", "", []string{".tga"}, decodeTGA)
+	RegisterDecoder("hdr", "#?", []string{".hdr", ".pic"}, decodeHDR)
+	RegisterDecoder("jpeg", "\xff\xd8", []string{".jpg", ".jpeg"}, decodeImage)
+	RegisterDecoder("png", "\x89PNG\r\n\x1a\n", []string{".png"}, decodePNG)
+	RegisterDecoder("ktx2", ktx2Magic, []string{".ktx2"}, decodeKTX2)
+	RegisterDecoder("dds", "DDS ", []string{".dds"}, decodeDDS)
+}
+
+// DecodeTexture decodes data, the contents of the file name, with the decoder
+// registered for its magic bytes or otherwise for the extension of name.
+// Data of neither is decoded by the image package into 8-bit sRGB texels.
+func DecodeTexture(name string, data []byte) (*TextureImage, error) {
+	dec := findDecoder(name, data)
+	if dec == nil {
+		return decodeImage(data)
+	}
+	img, err := dec.decode(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s: %v", dec.name, name, err)
+	}
+	return img, nil
+}
+
+func findDecoder(name string, data []byte) *decoderFormat {
+	for i := range decoders {
+		d := &decoders[i]
+		if d.magic != "" && bytes.HasPrefix(data, []byte(d.magic)) {
+			return d
+		}
+	}
+	ext := strings.ToLower(filepath.Ext(name))
+	for i := range decoders {
+		d := &decoders[i]
+		for _, e := range d.exts {
+			if e == ext {
+				return d
+			}
+		}
+	}
+	return nil
+}
+
+// decodeImage decodes data with the image package.
+func decodeImage(data []byte) (*TextureImage, error) {
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	return rgbaTexture(img), nil
+}
+
+// decodePNG keeps the precision of 16-bit PNGs by decoding
+// them into half floats.
+func decodePNG(data []byte) (*TextureImage, error) {
+	img, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	switch img.(type) {
+	case *image.RGBA64, *image.NRGBA64, *image.Gray16:
+		return rgba16fTexture(img), nil
+	}
+	return rgbaTexture(img), nil
+}
+
+// rgbaTexture returns the pixels of img as R8G8B8A8_SRGB texels.
+func rgbaTexture(img image.Image) *TextureImage {
+	size := img.Bounds().Size()
+	rgba, ok := img.(*image.RGBA)
+	if !ok || rgba.Rect.Min != image.ZP || rgba.Stride != 4*size.X {
+		rgba = image.NewRGBA(image.Rect(0, 0, size.X, size.Y))
+		draw.Draw(rgba, rgba.Bounds(), img, img.Bounds().Min, draw.Src)
+	}
+	return &TextureImage{
+		Format: vk.FormatR8g8b8a8Srgb,
+		Width:  size.X,
+		Height: size.Y,
+		Levels: [][]byte{rgba.Pix},
+	}
+}
+
+// rgba16fTexture returns the pixels of img as R16G16B16A16_SFLOAT texels,
+// with the color channels left in the sRGB encoding of the image.
+func rgba16fTexture(img image.Image) *TextureImage {
+	b := img.Bounds()
+	texels := make([]byte, 0, 8*b.Dx()*b.Dy())
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			c := color.NRGBA64Model.Convert(img.At(x, y)).(color.NRGBA64)
+			for _, v := range [4]uint16{c.R, c.G, c.B, c.A} {
+				h := float16(float32(v) / 0xffff)
+				texels = append(texels, byte(h), byte(h>>8))
+			}
+		}
+	}
+	return &TextureImage{
+		Format: vk.FormatR16g16b16a16Sfloat,
+		Width:  b.Dx(),
+		Height: b.Dy(),
+		Levels: [][]byte{texels},
+		SRGB:   true,
+	}
+}
+
+// setColorSpace prepares img to be sampled as a color texture, with the
+// sRGB texels decoded to linear colors, or as a data texture sampling the
+// stored values when linear is set.
+func (img *TextureImage) setColorSpace(linear bool) {
+	if linear {
+		img.Format = linearFormat(img.Format)
+		img.SRGB = false
+		return
+	}
+	if !img.SRGB {
+		return
+	}
+	for i, level := range img.Levels {
+		f := decodeTexels(img.Format, level)
+		for j := range f {
+			if j%4 != 3 {
+				f[j] = srgbToLinear(f[j])
+			}
+		}
+		img.Levels[i] = encodeTexels(img.Format, f)
+	}
+	img.SRGB = false
+}
+
+// checkLevels verifies the texel data of every level of img.
+func (img *TextureImage) checkLevels() error {
+	if img.Width <= 0 || img.Height <= 0 {
+		return errors.New("texture: empty image")
+	}
+	if len(img.Levels) == 0 {
+		return errors.New("texture: no texel data")
+	}
+	w, h := img.Width, img.Height
+	for i, level := range img.Levels {
+		size, err := levelSize(img.Format, w, h)
+		if err != nil {
+			return err
+		}
+		if len(level) != size {
+			return fmt.Errorf("texture: level %d has %d bytes, expected %d", i, len(level), size)
+		}
+		w, h = mipSize(w), mipSize(h)
+	}
+	return nil
+}
+
+// float16 returns the IEEE 754 half precision encoding of f,
+// rounded to the nearest value.
+func float16(f float32) uint16 {
+	bits := math.Float32bits(f)
+	sign := uint16(bits>>16) & 0x8000
+	exp := int(bits>>23&0xff) - 127 + 15
+	mant := bits & 0x7fffff
+	switch {
+	case bits&0x7fffffff > 0x7f800000:
+		// NaN
+		return sign | 0x7e00
+	case exp >= 0x1f:
+		// overflow to infinity
+		return sign | 0x7c00
+	case exp <= 0:
+		// subnormal or zero
+		if exp < -10 {
+			return sign
+		}
+		mant |= 0x800000
+		shift := uint(14 - exp)
+		h := uint16(mant >> shift)
+		if mant>>(shift-1)&1 != 0 {
+			h++
+		}
+		return sign | h
+	}
+	h := sign | uint16(exp)<<10 | uint16(mant>>13)
+	if mant&0x1000 != 0 {
+		// round up, a carry into the exponent is still correct
+		h++
+	}
+	return h
+}
+
+// float32From16 decodes the half precision value h.
+func float32From16(h uint16) float32 {
+	sign := uint32(h&0x8000) << 16
+	exp := uint32(h>>10) & 0x1f
+	mant := uint32(h & 0x3ff)
+	switch {
+	case exp == 0x1f:
+		return math.Float32frombits(sign | 0x7f800000 | mant<<13)
+	case exp == 0:
+		f := float32(mant) / (1 << 24)
+		if sign != 0 {
+			f = -f
+		}
+		return f
+	}
+	return math.Float32frombits(sign | (exp+127-15)<<23 | mant<<13)
+}
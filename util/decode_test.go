@@ -0,0 +1,88 @@
+package util
+
+import (
+	"math"
+	"testing"
+)
+
+func TestFloat16RoundTrip(t *testing.T) {
+	for h := 0; h <= 0xffff; h++ {
+		f := float32From16(uint16(h))
+		got := float16(f)
+		if h&0x7c00 == 0x7c00 && h&0x3ff != 0 {
+			// NaNs keep their sign and come back quiet
+			if !math.IsNaN(float64(f)) || got != uint16(h)&0x8000|0x7e00 {
+				t.Errorf("NaN %#04x decodes to %v and encodes to %#04x", h, f, got)
+			}
+			continue
+		}
+		if got != uint16(h) {
+			t.Errorf("%#04x decodes to %v, which encodes to %#04x", h, f, got)
+		}
+	}
+}
+
+func TestFloat16(t *testing.T) {
+	for _, tc := range []struct {
+		f float32
+		h uint16
+	}{
+		{0, 0x0000},
+		{float32(math.Copysign(0, -1)), 0x8000},
+		{1, 0x3c00},
+		{-2, 0xc000},
+		{0.5, 0x3800},
+		{65504, 0x7bff},
+		// the largest half, rounded up to infinity
+		{65520, 0x7c00},
+		{1e10, 0x7c00},
+		{-1e10, 0xfc00},
+		{float32(math.Inf(1)), 0x7c00},
+		{float32(math.Inf(-1)), 0xfc00},
+		// smallest normal, largest and smallest denormal
+		{1.0 / (1 << 14), 0x0400},
+		{1023.0 / (1 << 24), 0x03ff},
+		{1.0 / (1 << 24), 0x0001},
+		{-1.0 / (1 << 24), 0x8001},
+		// below half the smallest denormal
+		{1e-8, 0x0000},
+		// rounded to nearest
+		{1 + 1.0/(1<<11) + 1.0/(1<<12), 0x3c01},
+		{1 + 1.0/(1<<12), 0x3c00},
+		{7.0 / (1 << 26), 0x0002},
+		{float32(math.NaN()), 0x7e00},
+	} {
+		if got := float16(tc.f); got != tc.h {
+			t.Errorf("float16(%v) = %#04x, want %#04x", tc.f, got, tc.h)
+		}
+	}
+}
+
+func TestFloat32From16(t *testing.T) {
+	for _, tc := range []struct {
+		h uint16
+		f float64
+	}{
+		{0x3c00, 1},
+		{0xbc00, -1},
+		{0x7bff, 65504},
+		{0x0400, 1.0 / (1 << 14)},
+		{0x0001, 1.0 / (1 << 24)},
+		{0x8001, -1.0 / (1 << 24)},
+		{0x03ff, 1023.0 / (1 << 24)},
+		{0x7c00, math.Inf(1)},
+		{0xfc00, math.Inf(-1)},
+	} {
+		if got := float32From16(tc.h); float64(got) != tc.f {
+			t.Errorf("float32From16(%#04x) = %v, want %v", tc.h, got, tc.f)
+		}
+	}
+	for _, h := range []uint16{0x7e00, 0x7c01, 0xfe00} {
+		if got := float32From16(h); !math.IsNaN(float64(got)) {
+			t.Errorf("float32From16(%#04x) = %v, want NaN", h, got)
+		}
+	}
+	if got := float32From16(0x8000); got != 0 || !math.Signbit(float64(got)) {
+		t.Errorf("float32From16(0x8000) = %v, want -0", got)
+	}
+}
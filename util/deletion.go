@@ -0,0 +1,59 @@
+package util
+
+// deletionFrameLag is the number of frames a deletion waits for before it
+// runs. The swapchain contexts wait for the fence of an earlier frame before
+// they start a new one, so they never have this many frames in flight.
+const deletionFrameLag = 4
+
+// deletionQueue defers destroying resources until the GPU has finished the
+// commands using them, such as staging images and buffers that copies
+// recorded into the setup command buffer read from. Deletions are keyed on
+// the frame index they may run at.
+type deletionQueue struct {
+	entries []deletion
+}
+
+type deletion struct {
+	frame int
+	fn    func()
+}
+
+func (q *deletionQueue) push(frame int, fn func()) {
+	q.entries = append(q.entries, deletion{
+		frame: frame,
+		fn:    fn,
+	})
+}
+
+// collect runs the deletions due at frames up to and including frame.
+func (q *deletionQueue) collect(frame int) {
+	n := 0
+	for _, d := range q.entries {
+		if d.frame <= frame {
+			d.fn()
+			continue
+		}
+		q.entries[n] = d
+		n++
+	}
+	for i := n; i < len(q.entries); i++ {
+		q.entries[i] = deletion{}
+	}
+	q.entries = q.entries[:n]
+}
+
+// flush runs all deletions. The device must be idle.
+func (q *deletionQueue) flush() {
+	for _, d := range q.entries {
+		d.fn()
+	}
+	q.entries = nil
+}
+
+// deferDestroy queues fn to run once the GPU is done with the current
+// frame and the setup command buffer, deletionFrameLag frames from now.
+// That holds for the deletions queued during an upload too, the frames
+// in flight may still use what they destroy.
+func (s *SpinningCube) deferDestroy(fn func()) {
+	s.deletions.push(s.frameIndex+deletionFrameLag, fn)
+}
@@ -0,0 +1,27 @@
+package util
+
+import "testing"
+
+func TestDeferDestroy(t *testing.T) {
+	s := &SpinningCube{}
+	ran := 0
+	s.deferDestroy(func() { ran++ })
+	for i := 1; i < deletionFrameLag; i++ {
+		s.beginFrame()
+		if ran != 0 {
+			t.Fatalf("deletion ran %d frames after it was queued", i)
+		}
+	}
+	s.beginFrame()
+	if ran != 1 {
+		t.Fatalf("deletion ran %d times %d frames after it was queued", ran, deletionFrameLag)
+	}
+
+	// flush runs the deletions still waiting
+	s.deferDestroy(func() { ran++ })
+	s.deletions.flush()
+	s.beginFrame()
+	if ran != 2 || len(s.deletions.entries) != 0 {
+		t.Errorf("deletion ran %d times, %d left after flush", ran-1, len(s.deletions.entries))
+	}
+}
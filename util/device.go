@@ -0,0 +1,309 @@
+package util
+
+import (
+	"errors"
+	"unsafe"
+
+	as "github.com/vulkan-go/asche"
+	vk "github.com/vulkan-go/vulkan"
+)
+
+// deviceContext is a Vulkan instance and device created by this package,
+// with the single queue family used for graphics and, given a surface,
+// presentation. It implements the device part of renderContext for the
+// headless and window contexts.
+type deviceContext struct {
+	instance         vk.Instance
+	gpu              vk.PhysicalDevice
+	device           vk.Device
+	queue            vk.Queue
+	queueFamilyIndex uint32
+	memProps         vk.PhysicalDeviceMemoryProperties
+	surface          vk.Surface
+	features         vk.PhysicalDeviceFeatures
+
+	descriptorIndexing bool
+	memoryBudget       bool
+
+	cmdPool vk.CommandPool
+	initCmd vk.CommandBuffer
+}
+
+func (c *deviceContext) Device() vk.Device {
+	return c.device
+}
+
+func (c *deviceContext) PhysicalDevice() vk.PhysicalDevice {
+	return c.gpu
+}
+
+func (c *deviceContext) MemoryProperties() vk.PhysicalDeviceMemoryProperties {
+	return c.memProps
+}
+
+func (c *deviceContext) GraphicsQueueFamilyIndex() uint32 {
+	return c.queueFamilyIndex
+}
+
+func (c *deviceContext) PresentQueueFamilyIndex() uint32 {
+	return c.queueFamilyIndex
+}
+
+func (c *deviceContext) GraphicsQueue() vk.Queue {
+	return c.queue
+}
+
+func (c *deviceContext) Features() vk.PhysicalDeviceFeatures {
+	return c.features
+}
+
+func (c *deviceContext) DescriptorIndexing() bool {
+	return c.descriptorIndexing
+}
+
+func (c *deviceContext) MemoryBudget() (memoryBudget, bool) {
+	if !c.memoryBudget {
+		return memoryBudget{}, false
+	}
+	return queryMemoryBudget(c.instance, c.gpu)
+}
+
+func (c *deviceContext) CommandBuffer() vk.CommandBuffer {
+	return c.initCmd
+}
+
+// prepareDevice creates the instance with instanceExtensions enabled and
+// the device. Given createSurface, the surface is created right after the
+// instance and the queue family must be able to present to it.
+func (c *deviceContext) prepareDevice(instanceExtensions []string,
+	createSurface func(instance vk.Instance) (vk.Surface, error)) {
+
+	var enabled []string
+	for _, name := range instanceExtensions {
+		enabled = append(enabled, name+"\x00")
+	}
+	var instance vk.Instance
+	ret := vk.CreateInstance(&vk.InstanceCreateInfo{
+		SType: vk.StructureTypeInstanceCreateInfo,
+		PApplicationInfo: &vk.ApplicationInfo{
+			SType:            vk.StructureTypeApplicationInfo,
+			ApiVersion:       vk.MakeVersion(1, 1, 0),
+			PApplicationName: "FieboLib\x00",
+			PEngineName:      "FieboLib\x00",
+		},
+		EnabledExtensionCount:   uint32(len(enabled)),
+		PpEnabledExtensionNames: enabled,
+	}, nil, &instance)
+	orPanic(as.NewError(ret))
+	c.instance = instance
+	orPanic(vk.InitInstance(instance))
+
+	if createSurface != nil {
+		surface, err := createSurface(instance)
+		orPanic(err)
+		c.surface = surface
+	}
+
+	var gpuCount uint32
+	ret = vk.EnumeratePhysicalDevices(instance, &gpuCount, nil)
+	orPanic(as.NewError(ret))
+	if gpuCount == 0 {
+		orPanic(errors.New("vulkan error: no physical devices found"))
+	}
+	gpus := make([]vk.PhysicalDevice, gpuCount)
+	ret = vk.EnumeratePhysicalDevices(instance, &gpuCount, gpus)
+	orPanic(as.NewError(ret))
+
+	found := false
+	for _, gpu := range gpus {
+		var queueCount uint32
+		vk.GetPhysicalDeviceQueueFamilyProperties(gpu, &queueCount, nil)
+		queueProps := make([]vk.QueueFamilyProperties, queueCount)
+		vk.GetPhysicalDeviceQueueFamilyProperties(gpu, &queueCount, queueProps)
+		for i := range queueProps {
+			queueProps[i].Deref()
+			if queueProps[i].QueueFlags&vk.QueueFlags(vk.QueueGraphicsBit) != 0 &&
+				c.canPresent(gpu, uint32(i)) {
+				c.gpu = gpu
+				c.queueFamilyIndex = uint32(i)
+				found = true
+				break
+			}
+		}
+		if found {
+			break
+		}
+	}
+	if !found && c.surface != vk.NullSurface {
+		orPanic(errors.New("vulkan error: no physical device with a graphics queue presenting to the surface"))
+	} else if !found {
+		orPanic(errors.New("vulkan error: no physical device with a graphics queue"))
+	}
+
+	// enable the optional features textures are sampled with when available
+	var supported vk.PhysicalDeviceFeatures
+	vk.GetPhysicalDeviceFeatures(c.gpu, &supported)
+	supported.Deref()
+	c.features.SamplerAnisotropy = supported.SamplerAnisotropy
+	c.features.TextureCompressionBC = supported.TextureCompressionBC
+	c.features.TextureCompressionETC2 = supported.TextureCompressionETC2
+	c.features.TextureCompressionASTC_LDR = supported.TextureCompressionASTC_LDR
+	// and those PipelineBuilder may build pipelines with
+	c.features.LogicOp = supported.LogicOp
+	c.features.DepthBiasClamp = supported.DepthBiasClamp
+
+	info := &vk.DeviceCreateInfo{
+		SType:                vk.StructureTypeDeviceCreateInfo,
+		QueueCreateInfoCount: 1,
+		PQueueCreateInfos: []vk.DeviceQueueCreateInfo{{
+			SType:            vk.StructureTypeDeviceQueueCreateInfo,
+			QueueFamilyIndex: c.queueFamilyIndex,
+			QueueCount:       1,
+			PQueuePriorities: []float32{1.0},
+		}},
+	}
+	var extensions []string
+	if c.surface != vk.NullSurface {
+		extensions = append(extensions, swapchainExtension+"\x00")
+	}
+	// the texture table sampled by index, see bindlessTable
+	if supported.ShaderSampledImageArrayDynamicIndexing == vk.True && c.supportsDescriptorIndexing() {
+		c.features.ShaderSampledImageArrayDynamicIndexing = vk.True
+		indexing := vk.PhysicalDeviceDescriptorIndexingFeatures{
+			SType: vk.StructureTypePhysicalDeviceDescriptorIndexingFeatures,
+			DescriptorBindingSampledImageUpdateAfterBind: vk.True,
+			DescriptorBindingUpdateUnusedWhilePending:    vk.True,
+			DescriptorBindingPartiallyBound:              vk.True,
+		}
+		ref, _ := indexing.PassRef()
+		info.PNext = unsafe.Pointer(ref)
+		extensions = append(extensions, descriptorIndexingExtension+"\x00")
+		c.descriptorIndexing = true
+	}
+	// the driver budget of the memory statistics, see MemoryStats
+	if c.apiVersion() >= vk.MakeVersion(1, 1, 0) && getMemoryProperties2(c.instance) != nil &&
+		hasDeviceExtension(c.gpu, memoryBudgetExtension) {
+		extensions = append(extensions, memoryBudgetExtension+"\x00")
+		c.memoryBudget = true
+	}
+	info.EnabledExtensionCount = uint32(len(extensions))
+	info.PpEnabledExtensionNames = extensions
+	info.PEnabledFeatures = []vk.PhysicalDeviceFeatures{c.features}
+
+	var device vk.Device
+	ret = vk.CreateDevice(c.gpu, info, nil, &device)
+	orPanic(as.NewError(ret))
+	c.device = device
+
+	var queue vk.Queue
+	vk.GetDeviceQueue(device, c.queueFamilyIndex, 0, &queue)
+	c.queue = queue
+
+	vk.GetPhysicalDeviceMemoryProperties(c.gpu, &c.memProps)
+	c.memProps.Deref()
+}
+
+// canPresent reports whether queue family queueFamilyIndex of gpu can
+// present to the surface, if there is one.
+func (c *deviceContext) canPresent(gpu vk.PhysicalDevice, queueFamilyIndex uint32) bool {
+	if c.surface == vk.NullSurface {
+		return true
+	}
+	var supported vk.Bool32
+	ret := vk.GetPhysicalDeviceSurfaceSupport(gpu, queueFamilyIndex, c.surface, &supported)
+	orPanic(as.NewError(ret))
+	return supported == vk.True
+}
+
+const (
+	swapchainExtension          = "VK_KHR_swapchain"
+	descriptorIndexingExtension = "VK_EXT_descriptor_indexing"
+	memoryBudgetExtension       = "VK_EXT_memory_budget"
+)
+
+// supportsDescriptorIndexing reports whether the device has the
+// VK_EXT_descriptor_indexing features of the texture table.
+func (c *deviceContext) supportsDescriptorIndexing() bool {
+	if c.apiVersion() < vk.MakeVersion(1, 1, 0) {
+		// the features are queried with vkGetPhysicalDeviceFeatures2
+		return false
+	}
+	if !hasDeviceExtension(c.gpu, descriptorIndexingExtension) {
+		return false
+	}
+	getFeatures2 := instanceProc(c.instance,
+		"vkGetPhysicalDeviceFeatures2", "vkGetPhysicalDeviceFeatures2KHR")
+	if getFeatures2 == nil {
+		return false
+	}
+
+	indexing := vk.PhysicalDeviceDescriptorIndexingFeatures{
+		SType: vk.StructureTypePhysicalDeviceDescriptorIndexingFeatures,
+	}
+	ref, _ := indexing.PassRef()
+	defer indexing.Free()
+	features := vk.PhysicalDeviceFeatures2{
+		SType: vk.StructureTypePhysicalDeviceFeatures2,
+		PNext: unsafe.Pointer(ref),
+	}
+	featuresRef, _ := features.PassRef()
+	defer features.Free()
+	callPhysicalDeviceQuery(getFeatures2, c.gpu, unsafe.Pointer(featuresRef))
+	indexing.Deref()
+	return indexing.DescriptorBindingSampledImageUpdateAfterBind == vk.True &&
+		indexing.DescriptorBindingUpdateUnusedWhilePending == vk.True &&
+		indexing.DescriptorBindingPartiallyBound == vk.True
+}
+
+// apiVersion returns the Vulkan version supported by the device.
+func (c *deviceContext) apiVersion() uint32 {
+	var props vk.PhysicalDeviceProperties
+	vk.GetPhysicalDeviceProperties(c.gpu, &props)
+	props.Deref()
+	return props.ApiVersion
+}
+
+// hasDeviceExtension reports whether gpu supports the device extension name.
+func hasDeviceExtension(gpu vk.PhysicalDevice, name string) bool {
+	var count uint32
+	ret := vk.EnumerateDeviceExtensionProperties(gpu, "", &count, nil)
+	orPanic(as.NewError(ret))
+	exts := make([]vk.ExtensionProperties, count)
+	ret = vk.EnumerateDeviceExtensionProperties(gpu, "", &count, exts)
+	orPanic(as.NewError(ret))
+	for i := range exts {
+		exts[i].Deref()
+		if vk.ToString(exts[i].ExtensionName[:]) == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *deviceContext) prepareCommandPool() {
+	c.cmdPool = newCommandPool(c.device, c.queueFamilyIndex)
+	c.initCmd = allocCommandBuffer(c.device, c.cmdPool)
+}
+
+func (c *deviceContext) beginInitCmd() {
+	ret := vk.BeginCommandBuffer(c.initCmd, &vk.CommandBufferBeginInfo{
+		SType: vk.StructureTypeCommandBufferBeginInfo,
+		Flags: vk.CommandBufferUsageFlags(vk.CommandBufferUsageOneTimeSubmitBit),
+	})
+	orPanic(as.NewError(ret))
+}
+
+func (c *deviceContext) flushInitCmd() {
+	ret := vk.EndCommandBuffer(c.initCmd)
+	orPanic(as.NewError(ret))
+	submitAndWait(c.device, c.queue, c.initCmd)
+}
+
+func (c *deviceContext) destroy() {
+	vk.DestroyCommandPool(c.device, c.cmdPool, nil)
+	vk.DestroyDevice(c.device, nil)
+	if c.surface != vk.NullSurface {
+		vk.DestroySurface(c.instance, c.surface, nil)
+	}
+	vk.DestroyInstance(c.instance, nil)
+}
@@ -0,0 +1,278 @@
+package util
+
+import "encoding/binary"
+
+// CPU decoders of the ETC2 block formats, used when the device can't sample
+// them. Blocks are big-endian, and their texel indices run down the columns.
+
+var etc1Modifiers = [8][4]int{
+	{2, 8, -2, -8},
+	{5, 17, -5, -17},
+	{9, 29, -9, -29},
+	{13, 42, -13, -42},
+	{18, 60, -18, -60},
+	{24, 80, -24, -80},
+	{33, 106, -33, -106},
+	{47, 183, -47, -183},
+}
+
+var etc2Distances = [8]int{3, 6, 11, 16, 23, 32, 41, 64}
+
+// decodeETC2 decodes an ETC2 RGB block, or with punchthrough an RGB8A1 block
+// whose texels are either opaque or transparent black.
+func decodeETC2(b []byte, t *[16][4]byte, punchthrough bool) {
+	v := binary.BigEndian.Uint64(b)
+	bits := func(hi, n uint) int {
+		return int(v >> (hi - n + 1) & (1<<n - 1))
+	}
+	// without punchthrough bit 33 selects differential mode,
+	// with it the block is always differential and bit 33 marks it opaque
+	diff := bits(33, 1) == 1
+	opaque := true
+	if punchthrough {
+		opaque = diff
+		diff = true
+	}
+
+	var texelIndex [16]int
+	for i := 0; i < 16; i++ {
+		// index i is texel x = i/4, y = i%4
+		idx := bits(uint(16+i), 1)<<1 | bits(uint(i), 1)
+		texelIndex[(i%4)*4+i/4] = idx
+	}
+
+	if !diff {
+		c0 := [3]int{extend4(bits(63, 4)), extend4(bits(55, 4)), extend4(bits(47, 4))}
+		c1 := [3]int{extend4(bits(59, 4)), extend4(bits(51, 4)), extend4(bits(43, 4))}
+		etc1Subblocks(t, texelIndex, c0, c1, bits(39, 3), bits(36, 3), bits(32, 1) == 1, true)
+		return
+	}
+
+	r, g, bl := bits(63, 5), bits(55, 5), bits(47, 5)
+	dr, dg, db := signed3(bits(58, 3)), signed3(bits(50, 3)), signed3(bits(42, 3))
+	switch {
+	case r+dr < 0 || r+dr > 31:
+		etc2T(t, texelIndex, bits, opaque)
+	case g+dg < 0 || g+dg > 31:
+		etc2H(t, texelIndex, bits, opaque)
+	case bl+db < 0 || bl+db > 31:
+		etc2Planar(t, bits)
+	default:
+		c0 := [3]int{extend5(r), extend5(g), extend5(bl)}
+		c1 := [3]int{extend5(r + dr), extend5(g + dg), extend5(bl + db)}
+		etc1Subblocks(t, texelIndex, c0, c1, bits(39, 3), bits(36, 3), bits(32, 1) == 1, opaque)
+	}
+}
+
+// etc1Subblocks decodes the two subblocks of the individual and differential
+// modes. Non-opaque punchthrough blocks have transparent texels for index 2,
+// and no modifier for index 0.
+func etc1Subblocks(t *[16][4]byte, texelIndex [16]int, c0, c1 [3]int,
+	table0, table1 int, flip, opaque bool) {
+
+	for i := range t {
+		x, y := i%4, i/4
+		c, table := c0, table0
+		if flip && y >= 2 || !flip && x >= 2 {
+			c, table = c1, table1
+		}
+		idx := texelIndex[i]
+		if !opaque && idx == 2 {
+			t[i] = [4]byte{}
+			continue
+		}
+		m := etc1Modifiers[table][idx]
+		if !opaque && idx == 0 {
+			m = 0
+		}
+		t[i] = [4]byte{clamp8(c[0] + m), clamp8(c[1] + m), clamp8(c[2] + m), 255}
+	}
+}
+
+func etc2T(t *[16][4]byte, texelIndex [16]int, bits func(hi, n uint) int, opaque bool) {
+	c0 := [3]int{
+		extend4(bits(60, 2)<<2 | bits(57, 2)),
+		extend4(bits(55, 4)),
+		extend4(bits(51, 4)),
+	}
+	c1 := [3]int{extend4(bits(47, 4)), extend4(bits(43, 4)), extend4(bits(39, 4))}
+	d := etc2Distances[bits(35, 2)<<1|bits(32, 1)]
+	paint := [4][3]int{c0, offset(c1, d), c1, offset(c1, -d)}
+	etc2Paint(t, texelIndex, paint, opaque)
+}
+
+func etc2H(t *[16][4]byte, texelIndex [16]int, bits func(hi, n uint) int, opaque bool) {
+	r0, g0, b0 := bits(62, 4), bits(58, 3)<<1|bits(52, 1), bits(51, 1)<<3|bits(49, 3)
+	r1, g1, b1 := bits(46, 4), bits(42, 4), bits(38, 4)
+	di := bits(34, 1)<<2 | bits(32, 1)<<1
+	if r0<<8|g0<<4|b0 >= r1<<8|g1<<4|b1 {
+		di |= 1
+	}
+	d := etc2Distances[di]
+	c0 := [3]int{extend4(r0), extend4(g0), extend4(b0)}
+	c1 := [3]int{extend4(r1), extend4(g1), extend4(b1)}
+	paint := [4][3]int{offset(c0, d), offset(c0, -d), offset(c1, d), offset(c1, -d)}
+	etc2Paint(t, texelIndex, paint, opaque)
+}
+
+func etc2Paint(t *[16][4]byte, texelIndex [16]int, paint [4][3]int, opaque bool) {
+	for i := range t {
+		idx := texelIndex[i]
+		if !opaque && idx == 2 {
+			t[i] = [4]byte{}
+			continue
+		}
+		c := paint[idx]
+		t[i] = [4]byte{clamp8(c[0]), clamp8(c[1]), clamp8(c[2]), 255}
+	}
+}
+
+func etc2Planar(t *[16][4]byte, bits func(hi, n uint) int) {
+	o := [3]int{
+		extend6(bits(62, 6)),
+		extend7(bits(56, 1)<<6 | bits(54, 6)),
+		extend6(bits(48, 1)<<5 | bits(44, 2)<<3 | bits(41, 3)),
+	}
+	h := [3]int{
+		extend6(bits(38, 5)<<1 | bits(32, 1)),
+		extend7(bits(31, 7)),
+		extend6(bits(24, 6)),
+	}
+	v := [3]int{extend6(bits(18, 6)), extend7(bits(12, 7)), extend6(bits(5, 6))}
+	for i := range t {
+		x, y := i%4, i/4
+		var px [4]byte
+		for c := 0; c < 3; c++ {
+			px[c] = clamp8((x*(h[c]-o[c]) + y*(v[c]-o[c]) + 4*o[c] + 2) >> 2)
+		}
+		px[3] = 255
+		t[i] = px
+	}
+}
+
+var eacModifiers = [16][8]int{
+	{-3, -6, -9, -15, 2, 5, 8, 14},
+	{-3, -7, -10, -13, 2, 6, 9, 12},
+	{-2, -5, -8, -13, 1, 4, 7, 12},
+	{-2, -4, -6, -13, 1, 3, 5, 12},
+	{-3, -6, -8, -12, 2, 5, 7, 11},
+	{-3, -7, -9, -11, 2, 6, 8, 10},
+	{-4, -7, -8, -11, 3, 6, 7, 10},
+	{-3, -5, -8, -11, 2, 4, 7, 10},
+	{-2, -6, -8, -10, 1, 5, 7, 9},
+	{-2, -5, -8, -10, 1, 4, 7, 9},
+	{-2, -4, -8, -10, 1, 3, 7, 9},
+	{-2, -5, -7, -10, 1, 4, 6, 9},
+	{-3, -4, -7, -10, 2, 3, 6, 9},
+	{-1, -2, -3, -10, 0, 1, 2, 9},
+	{-4, -6, -8, -9, 3, 5, 7, 8},
+	{-3, -5, -7, -9, 2, 4, 6, 8},
+}
+
+// decodeETC2EAC decodes an ETC2 RGBA8 block, EAC alpha followed by
+// an ETC2 RGB block.
+func decodeETC2EAC(b []byte, t *[16][4]byte) {
+	decodeETC2(b[8:], t, false)
+	v := binary.BigEndian.Uint64(b)
+	base := int(v >> 56)
+	mult := int(v >> 52 & 0xf)
+	table := eacModifiers[v>>48&0xf]
+	for i := 0; i < 16; i++ {
+		idx := v >> (45 - 3*uint(i)) & 7
+		t[(i%4)*4+i/4][3] = clamp8(base + table[idx]*mult)
+	}
+}
+
+// decodeEACR11 decodes an EAC R11 block into red, as 8 bits.
+func decodeEACR11(b []byte, t *[16][4]byte, signed bool) {
+	var r [16]byte
+	decodeEAC11(b, &r, signed)
+	for i := range t {
+		t[i] = [4]byte{r[i], 0, 0, 255}
+		if signed {
+			t[i][3] = 127
+		}
+	}
+}
+
+// decodeEACRG11 decodes two EAC R11 blocks into red and green.
+func decodeEACRG11(b []byte, t *[16][4]byte, signed bool) {
+	var r, g [16]byte
+	decodeEAC11(b, &r, signed)
+	decodeEAC11(b[8:], &g, signed)
+	for i := range t {
+		t[i] = [4]byte{r[i], g[i], 0, 255}
+		if signed {
+			t[i][3] = 127
+		}
+	}
+}
+
+// decodeEAC11 decodes the 11 bit channel of an EAC R11 block, in rows.
+// Signed values are stored as two's complement bytes like those of BC4.
+func decodeEAC11(b []byte, out *[16]byte, signed bool) {
+	v := binary.BigEndian.Uint64(b)
+	base := int(v >> 56)
+	mult := int(v >> 52 & 0xf)
+	table := eacModifiers[v>>48&0xf]
+	for i := 0; i < 16; i++ {
+		m := table[v>>(45-3*uint(i))&7]
+		if mult != 0 {
+			m *= 8 * mult
+		}
+		var c int
+		if signed {
+			base := int(int8(base))
+			if base == -128 {
+				base = -127
+			}
+			// rounds the magnitude from 10 to 7 bits
+			c = clampInt(base*8+m, -1023, 1023)
+			if c < 0 {
+				c = -((-c*127 + 511) / 1023)
+			} else {
+				c = (c*127 + 511) / 1023
+			}
+		} else {
+			c = clampInt(base*8+4+m, 0, 2047)
+			c = (c*255 + 1023) / 2047
+		}
+		out[(i%4)*4+i/4] = byte(c)
+	}
+}
+
+func offset(c [3]int, d int) [3]int {
+	return [3]int{c[0] + d, c[1] + d, c[2] + d}
+}
+
+func signed3(v int) int {
+	if v >= 4 {
+		return v - 8
+	}
+	return v
+}
+
+func extend4(v int) int { return v<<4 | v }
+func extend5(v int) int { return v<<3 | v>>2 }
+func extend6(v int) int { return v<<2 | v>>4 }
+func extend7(v int) int { return v<<1 | v>>6 }
+
+func clampInt(v, lo, hi int) int {
+	if v < lo {
+		return lo
+	}
+	if v > hi {
+		return hi
+	}
+	return v
+}
+
+func clamp8(v int) byte {
+	if v < 0 {
+		return 0
+	}
+	if v > 255 {
+		return 255
+	}
+	return byte(v)
+}
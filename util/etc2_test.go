@@ -0,0 +1,157 @@
+package util
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+// etc2Block returns the big-endian block of the 32 mode and color bits hi,
+// with the pixel index of texel (x,y) given by index.
+func etc2Block(hi uint32, index func(x, y int) int) []byte {
+	v := uint64(hi) << 32
+	for x := 0; x < 4; x++ {
+		for y := 0; y < 4; y++ {
+			i := uint(x*4 + y)
+			idx := uint64(index(x, y))
+			v |= idx>>1<<(16+i) | idx&1<<i
+		}
+	}
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, v)
+	return b
+}
+
+// etc2Differential returns the mode and color bits of a differential block
+// of the 5-bit base color rgb, without deltas.
+func etc2Differential(rgb [3]uint32, table0, table1 uint32, diff, flip bool) uint32 {
+	hi := rgb[0]<<27 | rgb[1]<<19 | rgb[2]<<11 | table0<<5 | table1<<2
+	if diff {
+		hi |= 1 << 1
+	}
+	if flip {
+		hi |= 1
+	}
+	return hi
+}
+
+func byColumn(x, y int) int { return x }
+
+func TestDecodeETC2Individual(t *testing.T) {
+	// red on the left, blue on the right, modified by tables 0 and 7
+	hi := uint32(0xf0)<<24 | uint32(0x0f)<<8 | 7<<2
+	var got [16][4]byte
+	decodeETC2(etc2Block(hi, byColumn), &got, false)
+	checkBlock(t, "individual", &got, rowColors(
+		[4]byte{255, 2, 2, 255}, [4]byte{255, 8, 8, 255},
+		[4]byte{0, 0, 208, 255}, [4]byte{0, 0, 72, 255},
+	))
+}
+
+func TestDecodeETC2Differential(t *testing.T) {
+	// a grey of 132, flipped: table 0 above, table 1 below
+	hi := etc2Differential([3]uint32{16, 16, 16}, 0, 1, true, true)
+	var got [16][4]byte
+	decodeETC2(etc2Block(hi, byColumn), &got, false)
+	top := []byte{134, 140, 130, 124}
+	bottom := []byte{137, 149, 127, 115}
+	checkBlock(t, "differential", &got, func(i int) [4]byte {
+		c := top[i%4]
+		if i >= 8 {
+			c = bottom[i%4]
+		}
+		return [4]byte{c, c, c, 255}
+	})
+}
+
+func TestDecodeETC2Punchthrough(t *testing.T) {
+	var got [16][4]byte
+	// with the opaque bit, in place of the differential bit, the block
+	// decodes as without punchthrough
+	opaque := etc2Differential([3]uint32{16, 16, 16}, 0, 1, true, true)
+	decodeETC2(etc2Block(opaque, byColumn), &got, true)
+	top := []byte{134, 140, 130, 124}
+	bottom := []byte{137, 149, 127, 115}
+	checkBlock(t, "opaque", &got, func(i int) [4]byte {
+		c := top[i%4]
+		if i >= 8 {
+			c = bottom[i%4]
+		}
+		return [4]byte{c, c, c, 255}
+	})
+
+	// without it index 2 is transparent black and index 0 unmodified
+	transparent := etc2Differential([3]uint32{16, 16, 16}, 0, 1, false, true)
+	decodeETC2(etc2Block(transparent, byColumn), &got, true)
+	top = []byte{132, 140, 0, 124}
+	bottom = []byte{132, 149, 0, 115}
+	checkBlock(t, "transparent", &got, func(i int) [4]byte {
+		if i%4 == 2 {
+			return [4]byte{}
+		}
+		c := top[i%4]
+		if i >= 8 {
+			c = bottom[i%4]
+		}
+		return [4]byte{c, c, c, 255}
+	})
+}
+
+// eacBlock returns an EAC block whose texel (x,y) indexes modifier
+// (x*4+y)%8 of table.
+func eacBlock(base byte, mult, table uint64) []byte {
+	v := uint64(base)<<56 | mult<<52 | table<<48
+	for i := uint(0); i < 16; i++ {
+		v |= uint64(i%8) << (45 - 3*i)
+	}
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, v)
+	return b
+}
+
+func eacValue(values [8]int, signed bool) func(i int) byte {
+	return func(i int) byte {
+		v := values[(i%4*4+i/4)%8]
+		if signed {
+			return byte(int8(v))
+		}
+		return byte(v)
+	}
+}
+
+func TestDecodeEACR11(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		base   byte
+		mult   uint64
+		signed bool
+		values [8]int
+	}{
+		{"unsigned", 128, 2, false, [8]int{122, 116, 110, 98, 132, 138, 144, 156}},
+		{"signed", 0, 1, true, [8]int{-3, -6, -9, -15, 2, 5, 8, 14}},
+		// a multiplier of 0 scales the modifiers by 1/8
+		{"signed without multiplier", 60, 0, true, [8]int{59, 59, 58, 58, 60, 60, 61, 61}},
+		// -128 is read as -127, and the values clamp to -1
+		{"signed clamped", 0x80, 15, true, [8]int{-127, -127, -127, -127, -96, -52, -7, 82}},
+	} {
+		var got [16][4]byte
+		decodeEACR11(eacBlock(tc.base, tc.mult, 0), &got, tc.signed)
+		red := eacValue(tc.values, tc.signed)
+		checkBlock(t, tc.name, &got, func(i int) [4]byte {
+			if tc.signed {
+				return [4]byte{red(i), 0, 0, 127}
+			}
+			return [4]byte{red(i), 0, 0, 255}
+		})
+	}
+}
+
+func TestDecodeEACRG11Signed(t *testing.T) {
+	b := append(eacBlock(0, 1, 0), eacBlock(0x80, 15, 0)...)
+	var got [16][4]byte
+	decodeEACRG11(b, &got, true)
+	red := eacValue([8]int{-3, -6, -9, -15, 2, 5, 8, 14}, true)
+	green := eacValue([8]int{-127, -127, -127, -127, -96, -52, -7, 82}, true)
+	checkBlock(t, "RG11", &got, func(i int) [4]byte {
+		return [4]byte{red(i), green(i), 0, 127}
+	})
+}
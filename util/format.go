@@ -0,0 +1,225 @@
+package util
+
+import (
+	"fmt"
+
+	vk "github.com/vulkan-go/vulkan"
+)
+
+// formatBlock returns the width and height in texels of the blocks of
+// format, and their size in bytes. Uncompressed formats have 1x1 blocks.
+func formatBlock(format vk.Format) (w, h, size int, err error) {
+	switch format {
+	case vk.FormatR8g8b8a8Unorm, vk.FormatR8g8b8a8Snorm, vk.FormatR8g8b8a8Srgb:
+		return 1, 1, 4, nil
+	case vk.FormatR16g16b16a16Sfloat:
+		return 1, 1, 8, nil
+	case vk.FormatR32g32b32a32Sfloat:
+		return 1, 1, 16, nil
+	case vk.FormatBc1RgbUnormBlock, vk.FormatBc1RgbSrgbBlock,
+		vk.FormatBc1RgbaUnormBlock, vk.FormatBc1RgbaSrgbBlock,
+		vk.FormatBc4UnormBlock, vk.FormatBc4SnormBlock,
+		vk.FormatEtc2R8g8b8UnormBlock, vk.FormatEtc2R8g8b8SrgbBlock,
+		vk.FormatEtc2R8g8b8a1UnormBlock, vk.FormatEtc2R8g8b8a1SrgbBlock,
+		vk.FormatEacR11UnormBlock, vk.FormatEacR11SnormBlock:
+		return 4, 4, 8, nil
+	case vk.FormatBc2UnormBlock, vk.FormatBc2SrgbBlock,
+		vk.FormatBc3UnormBlock, vk.FormatBc3SrgbBlock,
+		vk.FormatBc5UnormBlock, vk.FormatBc5SnormBlock,
+		vk.FormatBc6hUfloatBlock, vk.FormatBc6hSfloatBlock,
+		vk.FormatBc7UnormBlock, vk.FormatBc7SrgbBlock,
+		vk.FormatEtc2R8g8b8a8UnormBlock, vk.FormatEtc2R8g8b8a8SrgbBlock,
+		vk.FormatEacR11g11UnormBlock, vk.FormatEacR11g11SnormBlock:
+		return 4, 4, 16, nil
+	}
+	if format >= vk.FormatAstc4x4UnormBlock && format <= vk.FormatAstc12x12SrgbBlock {
+		// the ASTC formats come in unorm and sRGB pairs
+		b := astcBlocks[(format-vk.FormatAstc4x4UnormBlock)/2]
+		return b[0], b[1], 16, nil
+	}
+	return 0, 0, 0, fmt.Errorf("texture: unsupported format %d", format)
+}
+
+var astcBlocks = [...][2]int{
+	{4, 4}, {5, 4}, {5, 5}, {6, 5}, {6, 6}, {8, 5}, {8, 6},
+	{8, 8}, {10, 5}, {10, 6}, {10, 8}, {10, 10}, {12, 10}, {12, 12},
+}
+
+func (s *SpinningCube) formatProperties(format vk.Format) vk.FormatProperties {
+	var props vk.FormatProperties
+	vk.GetPhysicalDeviceFormatProperties(s.rc().PhysicalDevice(), format, &props)
+	props.Deref()
+	return props
+}
+
+// compressionEnabled reports whether the device feature the compressed format
+// belongs to is enabled, devices may only sample it then.
+func compressionEnabled(format vk.Format, features vk.PhysicalDeviceFeatures) bool {
+	switch {
+	case format >= vk.FormatBc1RgbUnormBlock && format <= vk.FormatBc7SrgbBlock:
+		return features.TextureCompressionBC == vk.True
+	case format >= vk.FormatEtc2R8g8b8UnormBlock && format <= vk.FormatEacR11g11SnormBlock:
+		return features.TextureCompressionETC2 == vk.True
+	case format >= vk.FormatAstc4x4UnormBlock && format <= vk.FormatAstc12x12SrgbBlock:
+		return features.TextureCompressionASTC_LDR == vk.True
+	}
+	return true
+}
+
+// isCompressed reports whether format is a block-compressed format.
+func isCompressed(format vk.Format) bool {
+	w, h, _, err := formatBlock(format)
+	return err == nil && (w > 1 || h > 1)
+}
+
+// levelSize returns the size in bytes of a width x height level of format.
+func levelSize(format vk.Format, width, height int) (int, error) {
+	bw, bh, size, err := formatBlock(format)
+	if err != nil {
+		return 0, err
+	}
+	return (width + bw - 1) / bw * ((height + bh - 1) / bh) * size, nil
+}
+
+// decompressedFormat returns the format the blocks of format are
+// decompressed into on the CPU.
+func decompressedFormat(format vk.Format) vk.Format {
+	switch format {
+	case vk.FormatBc1RgbSrgbBlock, vk.FormatBc1RgbaSrgbBlock, vk.FormatBc2SrgbBlock,
+		vk.FormatBc3SrgbBlock, vk.FormatBc7SrgbBlock, vk.FormatEtc2R8g8b8SrgbBlock,
+		vk.FormatEtc2R8g8b8a1SrgbBlock, vk.FormatEtc2R8g8b8a8SrgbBlock:
+		return vk.FormatR8g8b8a8Srgb
+	case vk.FormatBc4SnormBlock, vk.FormatBc5SnormBlock,
+		vk.FormatEacR11SnormBlock, vk.FormatEacR11g11SnormBlock:
+		return vk.FormatR8g8b8a8Snorm
+	case vk.FormatBc6hUfloatBlock, vk.FormatBc6hSfloatBlock:
+		return vk.FormatR16g16b16a16Sfloat
+	}
+	if format >= vk.FormatAstc4x4UnormBlock && format <= vk.FormatAstc12x12SrgbBlock &&
+		linearFormat(format) != format {
+		return vk.FormatR8g8b8a8Srgb
+	}
+	return vk.FormatR8g8b8a8Unorm
+}
+
+// linearFormat returns the UNORM format the texels of the sRGB format are
+// reinterpreted as, or format itself when it isn't sRGB encoded.
+func linearFormat(format vk.Format) vk.Format {
+	switch format {
+	case vk.FormatR8g8b8a8Srgb:
+		return vk.FormatR8g8b8a8Unorm
+	case vk.FormatB8g8r8a8Srgb:
+		return vk.FormatB8g8r8a8Unorm
+	case vk.FormatA8b8g8r8SrgbPack32:
+		return vk.FormatA8b8g8r8UnormPack32
+	case vk.FormatBc1RgbSrgbBlock, vk.FormatBc1RgbaSrgbBlock, vk.FormatBc2SrgbBlock,
+		vk.FormatBc3SrgbBlock, vk.FormatBc7SrgbBlock, vk.FormatEtc2R8g8b8SrgbBlock,
+		vk.FormatEtc2R8g8b8a1SrgbBlock, vk.FormatEtc2R8g8b8a8SrgbBlock:
+		// each sRGB block format follows its UNORM one
+		return format - 1
+	}
+	if format >= vk.FormatAstc4x4UnormBlock && format <= vk.FormatAstc12x12SrgbBlock &&
+		(format-vk.FormatAstc4x4UnormBlock)%2 == 1 {
+		return format - 1
+	}
+	return format
+}
+
+// blockDecoder decodes a compressed block into its texels, in rows, of the
+// size of a texel of the decompressed format.
+type blockDecoder func(block []byte, texels []byte)
+
+// rgba8 adapts a decoder of RGBA texels of 8 bit channels.
+func rgba8(decode func(block []byte, t *[16][4]byte)) blockDecoder {
+	var t [16][4]byte
+	return func(b []byte, texels []byte) {
+		decode(b, &t)
+		for i := range t {
+			copy(texels[4*i:], t[i][:])
+		}
+	}
+}
+
+func blockDecoderOf(format vk.Format) blockDecoder {
+	switch format {
+	case vk.FormatBc1RgbUnormBlock, vk.FormatBc1RgbSrgbBlock:
+		return rgba8(func(b []byte, t *[16][4]byte) { decodeBC1(b, t, false, false) })
+	case vk.FormatBc1RgbaUnormBlock, vk.FormatBc1RgbaSrgbBlock:
+		return rgba8(func(b []byte, t *[16][4]byte) { decodeBC1(b, t, true, false) })
+	case vk.FormatBc2UnormBlock, vk.FormatBc2SrgbBlock:
+		return rgba8(decodeBC2)
+	case vk.FormatBc3UnormBlock, vk.FormatBc3SrgbBlock:
+		return rgba8(decodeBC3)
+	case vk.FormatBc4UnormBlock:
+		return rgba8(func(b []byte, t *[16][4]byte) { decodeBC4(b, t, false) })
+	case vk.FormatBc4SnormBlock:
+		return rgba8(func(b []byte, t *[16][4]byte) { decodeBC4(b, t, true) })
+	case vk.FormatBc5UnormBlock:
+		return rgba8(func(b []byte, t *[16][4]byte) { decodeBC5(b, t, false) })
+	case vk.FormatBc5SnormBlock:
+		return rgba8(func(b []byte, t *[16][4]byte) { decodeBC5(b, t, true) })
+	case vk.FormatBc6hUfloatBlock:
+		return func(b []byte, texels []byte) { decodeBC6H(b, texels, false) }
+	case vk.FormatBc6hSfloatBlock:
+		return func(b []byte, texels []byte) { decodeBC6H(b, texels, true) }
+	case vk.FormatBc7UnormBlock, vk.FormatBc7SrgbBlock:
+		return rgba8(decodeBC7)
+	case vk.FormatEtc2R8g8b8UnormBlock, vk.FormatEtc2R8g8b8SrgbBlock:
+		return rgba8(func(b []byte, t *[16][4]byte) { decodeETC2(b, t, false) })
+	case vk.FormatEtc2R8g8b8a1UnormBlock, vk.FormatEtc2R8g8b8a1SrgbBlock:
+		return rgba8(func(b []byte, t *[16][4]byte) { decodeETC2(b, t, true) })
+	case vk.FormatEtc2R8g8b8a8UnormBlock, vk.FormatEtc2R8g8b8a8SrgbBlock:
+		return rgba8(decodeETC2EAC)
+	case vk.FormatEacR11UnormBlock:
+		return rgba8(func(b []byte, t *[16][4]byte) { decodeEACR11(b, t, false) })
+	case vk.FormatEacR11SnormBlock:
+		return rgba8(func(b []byte, t *[16][4]byte) { decodeEACR11(b, t, true) })
+	case vk.FormatEacR11g11UnormBlock:
+		return rgba8(func(b []byte, t *[16][4]byte) { decodeEACRG11(b, t, false) })
+	case vk.FormatEacR11g11SnormBlock:
+		return rgba8(func(b []byte, t *[16][4]byte) { decodeEACRG11(b, t, true) })
+	}
+	if format >= vk.FormatAstc4x4UnormBlock && format <= vk.FormatAstc12x12SrgbBlock {
+		w, h, _, _ := formatBlock(format)
+		srgb := linearFormat(format) != format
+		return func(b []byte, texels []byte) { decodeASTC(b, w, h, srgb, texels) }
+	}
+	return nil
+}
+
+// decompress decodes the levels of the block-compressed img into
+// uncompressed texels, for devices that can't sample its format.
+func decompress(img *TextureImage) (*TextureImage, error) {
+	decode := blockDecoderOf(img.Format)
+	if decode == nil {
+		return nil, fmt.Errorf("texture: no CPU decoder for format %d", img.Format)
+	}
+	out := &TextureImage{
+		Format: decompressedFormat(img.Format),
+		Width:  img.Width,
+		Height: img.Height,
+		Levels: make([][]byte, len(img.Levels)),
+	}
+	bw, bh, blockSize, _ := formatBlock(img.Format)
+	_, _, texelSize, _ := formatBlock(out.Format)
+	texels := make([]byte, bw*bh*texelSize)
+	w, h := img.Width, img.Height
+	for i, level := range img.Levels {
+		dst := make([]byte, texelSize*w*h)
+		blocks := (w + bw - 1) / bw
+		for by := 0; by < (h+bh-1)/bh; by++ {
+			for bx := 0; bx < blocks; bx++ {
+				decode(level[blockSize*(by*blocks+bx):], texels)
+				for ty := 0; ty < bh && bh*by+ty < h; ty++ {
+					for tx := 0; tx < bw && bw*bx+tx < w; tx++ {
+						copy(dst[texelSize*((bh*by+ty)*w+bw*bx+tx):],
+							texels[texelSize*(bw*ty+tx):texelSize*(bw*ty+tx+1)])
+					}
+				}
+			}
+		}
+		out.Levels[i] = dst
+		w, h = mipSize(w), mipSize(h)
+	}
+	return out, nil
+}
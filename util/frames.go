@@ -0,0 +1,103 @@
+package util
+
+import (
+	"fmt"
+
+	as "github.com/vulkan-go/asche"
+	vk "github.com/vulkan-go/vulkan"
+)
+
+// maxFramesInFlight is the most frames a frame ring can have in flight,
+// fewer than deletionFrameLag for deferred deletions to outlast them.
+const maxFramesInFlight = deletionFrameLag - 1
+
+// frame is a frame in flight: the command buffer it is recorded into, with
+// a pool of its own to reset at once, the fence signalled once the GPU has
+// executed it, and the semaphore ordering it after acquiring its swapchain
+// image. Presenting waits on the semaphore of the swapchain image instead,
+// which the presentation engine may still hold once the frame's fence has
+// been signalled.
+type frame struct {
+	cmdPool        vk.CommandPool
+	cmd            vk.CommandBuffer
+	fence          vk.Fence
+	imageAvailable vk.Semaphore
+}
+
+// frameRing cycles through the frames in flight. While the GPU executes the
+// frames submitted last, the CPU records the next one into the frame the GPU
+// has finished with longest ago.
+type frameRing struct {
+	frames  []frame
+	current int
+}
+
+func newFrameRing(dev vk.Device, queueFamilyIndex uint32, n int) *frameRing {
+	if n < 1 || n > maxFramesInFlight {
+		orPanic(fmt.Errorf("vulkan: %d frames in flight, 1 to %d supported", n, maxFramesInFlight))
+	}
+	r := &frameRing{
+		frames: make([]frame, n),
+	}
+	for i := range r.frames {
+		f := &r.frames[i]
+		var cmdPool vk.CommandPool
+		ret := vk.CreateCommandPool(dev, &vk.CommandPoolCreateInfo{
+			SType:            vk.StructureTypeCommandPoolCreateInfo,
+			Flags:            vk.CommandPoolCreateFlags(vk.CommandPoolCreateTransientBit),
+			QueueFamilyIndex: queueFamilyIndex,
+		}, nil, &cmdPool)
+		orPanic(as.NewError(ret))
+		f.cmdPool = cmdPool
+		f.cmd = allocCommandBuffer(dev, cmdPool)
+
+		// signalled, the first wait for each frame returns right away
+		var fence vk.Fence
+		ret = vk.CreateFence(dev, &vk.FenceCreateInfo{
+			SType: vk.StructureTypeFenceCreateInfo,
+			Flags: vk.FenceCreateFlags(vk.FenceCreateSignaledBit),
+		}, nil, &fence)
+		orPanic(as.NewError(ret))
+		f.fence = fence
+		f.imageAvailable = newSemaphore(dev)
+	}
+	return r
+}
+
+func newSemaphore(dev vk.Device) vk.Semaphore {
+	var sem vk.Semaphore
+	ret := vk.CreateSemaphore(dev, &vk.SemaphoreCreateInfo{
+		SType: vk.StructureTypeSemaphoreCreateInfo,
+	}, nil, &sem)
+	orPanic(as.NewError(ret))
+	return sem
+}
+
+// next advances to the next frame and waits until the GPU has executed it,
+// for its command buffer and semaphores to be reused. Its fence is left
+// signalled until the frame is submitted again, see begin.
+func (r *frameRing) next(dev vk.Device) (*frame, int) {
+	r.current = (r.current + 1) % len(r.frames)
+	f := &r.frames[r.current]
+	ret := vk.WaitForFences(dev, 1, []vk.Fence{f.fence}, vk.True, vk.MaxUint64)
+	orPanic(as.NewError(ret))
+	return f, r.current
+}
+
+// begin resets f for recording, once it is certain to be submitted.
+func (f *frame) begin(dev vk.Device) {
+	ret := vk.ResetFences(dev, 1, []vk.Fence{f.fence})
+	orPanic(as.NewError(ret))
+	ret = vk.ResetCommandPool(dev, f.cmdPool, 0)
+	orPanic(as.NewError(ret))
+}
+
+// destroy releases the frames. The device must be idle.
+func (r *frameRing) destroy(dev vk.Device) {
+	for _, f := range r.frames {
+		vk.DestroySemaphore(dev, f.imageAvailable, nil)
+		vk.DestroyFence(dev, f.fence, nil)
+		vk.DestroyCommandPool(dev, f.cmdPool, nil)
+	}
+	r.frames = nil
+}
@@ -0,0 +1,822 @@
+package util
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/url"
+	"path/filepath"
+	"strings"
+)
+
+// LoadGLTF reads a glTF 2.0 model, either a .gltf JSON file with external or
+// data URI buffers and images, or a binary .glb container.
+//
+// The node hierarchy of the default scene is flattened into a single MeshData:
+// every primitive of every mesh instance becomes a MeshGroup, with vertices
+// transformed by the world matrix of its node. The base color factor is
+// applied to the vertex colors, the base color texture becomes the diffuse map.
+func LoadGLTF(path string) (*MeshData, error) {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	doc := &gltfDocument{path: path, dir: filepath.Dir(path)}
+	if bytes.HasPrefix(raw, []byte("glTF")) {
+		err = doc.parseGLB(raw)
+	} else {
+		err = json.Unmarshal(raw, &doc.gltf)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("gltf: %s: %v", path, err)
+	}
+	data, err := doc.meshData()
+	if err != nil {
+		return nil, fmt.Errorf("gltf: %s: %v", path, err)
+	}
+	return data, nil
+}
+
+// LoadGLTF loads the glTF model at path and adds it to the scene.
+func (s *SpinningCube) LoadGLTF(path string) error {
+	data, err := LoadGLTF(path)
+	if err != nil {
+		return err
+	}
+	return s.AddMesh(data)
+}
+
+type gltf struct {
+	Scene       *int             `json:"scene"`
+	Scenes      []gltfScene      `json:"scenes"`
+	Nodes       []gltfNode       `json:"nodes"`
+	Meshes      []gltfMesh       `json:"meshes"`
+	Accessors   []gltfAccessor   `json:"accessors"`
+	BufferViews []gltfBufferView `json:"bufferViews"`
+	Buffers     []gltfBuffer     `json:"buffers"`
+	Materials   []gltfMaterial   `json:"materials"`
+	Textures    []gltfTexture    `json:"textures"`
+	Images      []gltfImage      `json:"images"`
+}
+
+type gltfScene struct {
+	Nodes []int `json:"nodes"`
+}
+
+type gltfNode struct {
+	Name        string       `json:"name"`
+	Children    []int        `json:"children"`
+	Mesh        *int         `json:"mesh"`
+	Matrix      *[16]float32 `json:"matrix"`
+	Translation *[3]float32  `json:"translation"`
+	Rotation    *[4]float32  `json:"rotation"`
+	Scale       *[3]float32  `json:"scale"`
+}
+
+type gltfMesh struct {
+	Name       string          `json:"name"`
+	Primitives []gltfPrimitive `json:"primitives"`
+}
+
+type gltfPrimitive struct {
+	Attributes map[string]int `json:"attributes"`
+	Indices    *int           `json:"indices"`
+	Material   *int           `json:"material"`
+	Mode       *int           `json:"mode"`
+}
+
+type gltfAccessor struct {
+	BufferView    *int        `json:"bufferView"`
+	ByteOffset    int         `json:"byteOffset"`
+	ComponentType int         `json:"componentType"`
+	Normalized    bool        `json:"normalized"`
+	Count         int         `json:"count"`
+	Type          string      `json:"type"`
+	Sparse        interface{} `json:"sparse"`
+}
+
+type gltfBufferView struct {
+	Buffer     int `json:"buffer"`
+	ByteOffset int `json:"byteOffset"`
+	ByteLength int `json:"byteLength"`
+	ByteStride int `json:"byteStride"`
+}
+
+type gltfBuffer struct {
+	URI        string `json:"uri"`
+	ByteLength int    `json:"byteLength"`
+}
+
+type gltfTextureInfo struct {
+	Index    int `json:"index"`
+	TexCoord int `json:"texCoord"`
+}
+
+type gltfMaterial struct {
+	Name                 string `json:"name"`
+	PBRMetallicRoughness *struct {
+		BaseColorFactor          *[4]float32      `json:"baseColorFactor"`
+		BaseColorTexture         *gltfTextureInfo `json:"baseColorTexture"`
+		MetallicFactor           *float32         `json:"metallicFactor"`
+		RoughnessFactor          *float32         `json:"roughnessFactor"`
+		MetallicRoughnessTexture *gltfTextureInfo `json:"metallicRoughnessTexture"`
+	} `json:"pbrMetallicRoughness"`
+	NormalTexture    *gltfTextureInfo `json:"normalTexture"`
+	OcclusionTexture *gltfTextureInfo `json:"occlusionTexture"`
+	EmissiveTexture  *gltfTextureInfo `json:"emissiveTexture"`
+	EmissiveFactor   [3]float32       `json:"emissiveFactor"`
+	AlphaMode        string           `json:"alphaMode"`
+	AlphaCutoff      *float32         `json:"alphaCutoff"`
+	DoubleSided      bool             `json:"doubleSided"`
+}
+
+type gltfTexture struct {
+	Source *int `json:"source"`
+}
+
+type gltfImage struct {
+	URI        string `json:"uri"`
+	BufferView *int   `json:"bufferView"`
+	MimeType   string `json:"mimeType"`
+}
+
+const (
+	gltfByte          = 5120
+	gltfUnsignedByte  = 5121
+	gltfShort         = 5122
+	gltfUnsignedShort = 5123
+	gltfUnsignedInt   = 5125
+	gltfFloat         = 5126
+)
+
+const (
+	gltfTriangles     = 4
+	gltfTriangleStrip = 5
+	gltfTriangleFan   = 6
+)
+
+var gltfComponents = map[string]int{
+	"SCALAR": 1, "VEC2": 2, "VEC3": 3, "VEC4": 4, "MAT2": 4, "MAT3": 9, "MAT4": 16,
+}
+
+type gltfDocument struct {
+	gltf
+
+	path string
+	dir  string
+	// bin is the BIN chunk of a .glb file
+	bin     []byte
+	buffers [][]byte
+
+	materials []*Material
+	data      *MeshData
+}
+
+func (d *gltfDocument) parseGLB(raw []byte) error {
+	if len(raw) < 12 {
+		return errors.New("truncated GLB header")
+	}
+	if version := binary.LittleEndian.Uint32(raw[4:]); version != 2 {
+		return fmt.Errorf("unsupported GLB version %d", version)
+	}
+	length := int(binary.LittleEndian.Uint32(raw[8:]))
+	if length > len(raw) {
+		return errors.New("truncated GLB file")
+	}
+	var haveJSON bool
+	for off := 12; off+8 <= length; {
+		chunkLen := int(binary.LittleEndian.Uint32(raw[off:]))
+		chunkType := binary.LittleEndian.Uint32(raw[off+4:])
+		off += 8
+		if off+chunkLen > length {
+			return errors.New("truncated GLB chunk")
+		}
+		chunk := raw[off : off+chunkLen]
+		switch chunkType {
+		case 0x4E4F534A: // JSON
+			if err := json.Unmarshal(chunk, &d.gltf); err != nil {
+				return err
+			}
+			haveJSON = true
+		case 0x004E4942: // BIN
+			if d.bin == nil {
+				d.bin = chunk
+			}
+		}
+		// chunks are padded to 4 bytes
+		off += (chunkLen + 3) &^ 3
+	}
+	if !haveJSON {
+		return errors.New("GLB file without a JSON chunk")
+	}
+	return nil
+}
+
+// loadURI resolves a data URI or a path relative to the model file.
+func (d *gltfDocument) loadURI(uri string) ([]byte, error) {
+	if strings.HasPrefix(uri, "data:") {
+		i := strings.Index(uri, ";base64,")
+		if i < 0 {
+			return nil, errors.New("only base64 data URIs are supported")
+		}
+		return base64.StdEncoding.DecodeString(uri[i+len(";base64,"):])
+	}
+	return ioutil.ReadFile(uriPath(d.dir, uri))
+}
+
+func (d *gltfDocument) loadBuffers() error {
+	d.buffers = make([][]byte, len(d.Buffers))
+	for i, b := range d.Buffers {
+		var data []byte
+		if b.URI == "" {
+			// the first buffer of a .glb file refers to the BIN chunk
+			if i != 0 || d.bin == nil {
+				return fmt.Errorf("buffer %d has no data", i)
+			}
+			data = d.bin
+		} else {
+			var err error
+			if data, err = d.loadURI(b.URI); err != nil {
+				return fmt.Errorf("buffer %d: %v", i, err)
+			}
+		}
+		if len(data) < b.ByteLength {
+			return fmt.Errorf("buffer %d: %d bytes, want %d", i, len(data), b.ByteLength)
+		}
+		d.buffers[i] = data
+	}
+	return nil
+}
+
+func (d *gltfDocument) bufferView(idx int) ([]byte, int, error) {
+	if idx < 0 || idx >= len(d.BufferViews) {
+		return nil, 0, fmt.Errorf("bufferView %d out of range", idx)
+	}
+	v := d.BufferViews[idx]
+	if v.Buffer < 0 || v.Buffer >= len(d.buffers) {
+		return nil, 0, fmt.Errorf("bufferView %d: buffer %d out of range", idx, v.Buffer)
+	}
+	buf := d.buffers[v.Buffer]
+	if v.ByteOffset < 0 || v.ByteLength < 0 || v.ByteStride < 0 ||
+		v.ByteOffset+v.ByteLength > len(buf) {
+		return nil, 0, fmt.Errorf("bufferView %d out of buffer bounds", idx)
+	}
+	return buf[v.ByteOffset : v.ByteOffset+v.ByteLength], v.ByteStride, nil
+}
+
+// readAccessor returns the elements of accessor idx converted to float32,
+// with n components each. Normalized integer components are mapped to [0,1]
+// or [-1,1], other integers are converted as is.
+func (d *gltfDocument) readAccessor(idx int) (values []float32, n int, err error) {
+	if idx < 0 || idx >= len(d.Accessors) {
+		return nil, 0, fmt.Errorf("accessor %d out of range", idx)
+	}
+	a := d.Accessors[idx]
+	n, ok := gltfComponents[a.Type]
+	if !ok {
+		return nil, 0, fmt.Errorf("accessor %d: unknown type %q", idx, a.Type)
+	}
+	if a.Sparse != nil {
+		return nil, 0, fmt.Errorf("accessor %d: sparse accessors are not supported", idx)
+	}
+	if a.Count < 0 || a.ByteOffset < 0 {
+		return nil, 0, fmt.Errorf("accessor %d: negative count or offset", idx)
+	}
+	values = make([]float32, a.Count*n)
+	if a.BufferView == nil {
+		// no buffer view means all zeros
+		return values, n, nil
+	}
+	view, stride, err := d.bufferView(*a.BufferView)
+	if err != nil {
+		return nil, 0, err
+	}
+	var size int
+	switch a.ComponentType {
+	case gltfByte, gltfUnsignedByte:
+		size = 1
+	case gltfShort, gltfUnsignedShort:
+		size = 2
+	case gltfUnsignedInt, gltfFloat:
+		size = 4
+	default:
+		return nil, 0, fmt.Errorf("accessor %d: unknown component type %d", idx, a.ComponentType)
+	}
+	if stride == 0 {
+		stride = n * size
+	}
+	if a.Count > 0 && a.ByteOffset+(a.Count-1)*stride+n*size > len(view) {
+		return nil, 0, fmt.Errorf("accessor %d out of bufferView bounds", idx)
+	}
+	for i := 0; i < a.Count; i++ {
+		elem := view[a.ByteOffset+i*stride:]
+		for c := 0; c < n; c++ {
+			b := elem[c*size:]
+			var v float32
+			switch a.ComponentType {
+			case gltfByte:
+				v = float32(int8(b[0]))
+				if a.Normalized {
+					v = float32(math.Max(float64(v)/127, -1))
+				}
+			case gltfUnsignedByte:
+				v = float32(b[0])
+				if a.Normalized {
+					v /= 255
+				}
+			case gltfShort:
+				v = float32(int16(binary.LittleEndian.Uint16(b)))
+				if a.Normalized {
+					v = float32(math.Max(float64(v)/32767, -1))
+				}
+			case gltfUnsignedShort:
+				v = float32(binary.LittleEndian.Uint16(b))
+				if a.Normalized {
+					v /= 65535
+				}
+			case gltfUnsignedInt:
+				v = float32(binary.LittleEndian.Uint32(b))
+			case gltfFloat:
+				v = math.Float32frombits(binary.LittleEndian.Uint32(b))
+			}
+			values[i*n+c] = v
+		}
+	}
+	return values, n, nil
+}
+
+// readIndices returns the scalar unsigned integer accessor idx.
+func (d *gltfDocument) readIndices(idx int) ([]uint32, error) {
+	if idx < 0 || idx >= len(d.Accessors) {
+		return nil, fmt.Errorf("accessor %d out of range", idx)
+	}
+	a := d.Accessors[idx]
+	if a.Type != "SCALAR" || a.BufferView == nil || a.Sparse != nil ||
+		a.Count < 0 || a.ByteOffset < 0 {
+		return nil, fmt.Errorf("accessor %d: not usable as indices", idx)
+	}
+	view, _, err := d.bufferView(*a.BufferView)
+	if err != nil {
+		return nil, err
+	}
+	var size int
+	switch a.ComponentType {
+	case gltfUnsignedByte:
+		size = 1
+	case gltfUnsignedShort:
+		size = 2
+	case gltfUnsignedInt:
+		size = 4
+	default:
+		return nil, fmt.Errorf("accessor %d: bad index component type %d", idx, a.ComponentType)
+	}
+	if a.ByteOffset+a.Count*size > len(view) {
+		return nil, fmt.Errorf("accessor %d out of bufferView bounds", idx)
+	}
+	indices := make([]uint32, a.Count)
+	b := view[a.ByteOffset:]
+	for i := range indices {
+		switch size {
+		case 1:
+			indices[i] = uint32(b[i])
+		case 2:
+			indices[i] = uint32(binary.LittleEndian.Uint16(b[i*2:]))
+		case 4:
+			indices[i] = binary.LittleEndian.Uint32(b[i*4:])
+		}
+	}
+	return indices, nil
+}
+
+func (d *gltfDocument) textureRef(info *gltfTextureInfo) (TextureRef, error) {
+	if info == nil {
+		return TextureRef{}, nil
+	}
+	if info.Index < 0 || info.Index >= len(d.Textures) {
+		return TextureRef{}, fmt.Errorf("texture %d out of range", info.Index)
+	}
+	src := d.Textures[info.Index].Source
+	if src == nil {
+		// the image comes from an extension, e.g. KHR_texture_basisu
+		return TextureRef{}, nil
+	}
+	if *src < 0 || *src >= len(d.Images) {
+		return TextureRef{}, fmt.Errorf("image %d out of range", *src)
+	}
+	img := d.Images[*src]
+	name := fmt.Sprintf("%s#image%d", d.path, *src)
+	switch {
+	case img.BufferView != nil:
+		view, _, err := d.bufferView(*img.BufferView)
+		if err != nil {
+			return TextureRef{}, err
+		}
+		return TextureRef{Name: name, Data: view}, nil
+	case strings.HasPrefix(img.URI, "data:"):
+		data, err := d.loadURI(img.URI)
+		if err != nil {
+			return TextureRef{}, fmt.Errorf("image %d: %v", *src, err)
+		}
+		return TextureRef{Name: name, Data: data}, nil
+	default:
+		return TextureRef{Name: uriPath(d.dir, img.URI)}, nil
+	}
+}
+
+func (d *gltfDocument) loadMaterials() error {
+	d.materials = make([]*Material, len(d.Materials))
+	for i, gm := range d.Materials {
+		m := &Material{
+			Name:        gm.Name,
+			Diffuse:     [4]float32{1, 1, 1, 1},
+			Metallic:    1,
+			Roughness:   1,
+			Emissive:    gm.EmissiveFactor,
+			AlphaMode:   gm.AlphaMode,
+			AlphaCutoff: 0.5,
+			DoubleSided: gm.DoubleSided,
+		}
+		if m.AlphaMode == "" {
+			m.AlphaMode = "OPAQUE"
+		}
+		if gm.AlphaCutoff != nil {
+			m.AlphaCutoff = *gm.AlphaCutoff
+		}
+		var err error
+		if pbr := gm.PBRMetallicRoughness; pbr != nil {
+			if pbr.BaseColorFactor != nil {
+				m.Diffuse = *pbr.BaseColorFactor
+			}
+			if pbr.MetallicFactor != nil {
+				m.Metallic = *pbr.MetallicFactor
+			}
+			if pbr.RoughnessFactor != nil {
+				m.Roughness = *pbr.RoughnessFactor
+			}
+			if m.DiffuseMap, err = d.textureRef(pbr.BaseColorTexture); err != nil {
+				return fmt.Errorf("material %d: %v", i, err)
+			}
+			if m.MetallicRoughnessMap, err = d.textureRef(pbr.MetallicRoughnessTexture); err != nil {
+				return fmt.Errorf("material %d: %v", i, err)
+			}
+		}
+		if m.NormalMap, err = d.textureRef(gm.NormalTexture); err != nil {
+			return fmt.Errorf("material %d: %v", i, err)
+		}
+		if m.OcclusionMap, err = d.textureRef(gm.OcclusionTexture); err != nil {
+			return fmt.Errorf("material %d: %v", i, err)
+		}
+		if m.EmissiveMap, err = d.textureRef(gm.EmissiveTexture); err != nil {
+			return fmt.Errorf("material %d: %v", i, err)
+		}
+		// only the base color and emissive maps hold sRGB colors
+		m.MetallicRoughnessMap.Linear = true
+		m.NormalMap.Linear = true
+		m.OcclusionMap.Linear = true
+		d.materials[i] = m
+	}
+	return nil
+}
+
+func (d *gltfDocument) meshData() (*MeshData, error) {
+	if err := d.loadBuffers(); err != nil {
+		return nil, err
+	}
+	if err := d.loadMaterials(); err != nil {
+		return nil, err
+	}
+	d.data = &MeshData{}
+
+	var roots []int
+	switch {
+	case d.Scene != nil && *d.Scene < len(d.Scenes):
+		roots = d.Scenes[*d.Scene].Nodes
+	case len(d.Scenes) > 0:
+		roots = d.Scenes[0].Nodes
+	default:
+		// no scenes, draw every node that is nobody's child
+		isChild := make([]bool, len(d.Nodes))
+		for _, n := range d.Nodes {
+			for _, c := range n.Children {
+				if c >= 0 && c < len(isChild) {
+					isChild[c] = true
+				}
+			}
+		}
+		for i := range d.Nodes {
+			if !isChild[i] {
+				roots = append(roots, i)
+			}
+		}
+	}
+	for _, n := range roots {
+		if err := d.addNode(n, mat4Identity(), 0); err != nil {
+			return nil, err
+		}
+	}
+	return d.data, nil
+}
+
+func (d *gltfDocument) addNode(idx int, parent mat4, depth int) error {
+	if idx < 0 || idx >= len(d.Nodes) {
+		return fmt.Errorf("node %d out of range", idx)
+	}
+	if depth > len(d.Nodes) {
+		return fmt.Errorf("node %d: cycle in the node hierarchy", idx)
+	}
+	n := d.Nodes[idx]
+	world := parent.mul(n.local())
+	if n.Mesh != nil {
+		if *n.Mesh < 0 || *n.Mesh >= len(d.Meshes) {
+			return fmt.Errorf("node %d: mesh %d out of range", idx, *n.Mesh)
+		}
+		for i, p := range d.Meshes[*n.Mesh].Primitives {
+			if err := d.addPrimitive(p, world); err != nil {
+				return fmt.Errorf("mesh %d primitive %d: %v", *n.Mesh, i, err)
+			}
+		}
+	}
+	for _, c := range n.Children {
+		if err := d.addNode(c, world, depth+1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *gltfDocument) addPrimitive(p gltfPrimitive, world mat4) error {
+	mode := gltfTriangles
+	if p.Mode != nil {
+		mode = *p.Mode
+	}
+	switch mode {
+	case gltfTriangles, gltfTriangleStrip, gltfTriangleFan:
+	default:
+		// points and lines are not drawn by the triangle list pipeline
+		return nil
+	}
+	posIdx, ok := p.Attributes["POSITION"]
+	if !ok {
+		return nil
+	}
+	pos, n, err := d.readAccessor(posIdx)
+	if err != nil {
+		return err
+	}
+	if n != 3 {
+		return errors.New("POSITION is not VEC3")
+	}
+	count := len(pos) / 3
+
+	attr := func(name string, want int) ([]float32, int, error) {
+		idx, ok := p.Attributes[name]
+		if !ok {
+			return nil, 0, nil
+		}
+		v, n, err := d.readAccessor(idx)
+		if err != nil {
+			return nil, 0, err
+		}
+		if len(v)/n != count || (want > 0 && n != want) {
+			return nil, 0, fmt.Errorf("%s does not match POSITION", name)
+		}
+		return v, n, nil
+	}
+	normals, _, err := attr("NORMAL", 3)
+	if err != nil {
+		return err
+	}
+	uvs, _, err := attr("TEXCOORD_0", 2)
+	if err != nil {
+		return err
+	}
+	colors, colorN, err := attr("COLOR_0", 0)
+	if err != nil {
+		return err
+	}
+
+	var material *Material
+	if p.Material != nil {
+		if *p.Material < 0 || *p.Material >= len(d.materials) {
+			return fmt.Errorf("material %d out of range", *p.Material)
+		}
+		material = d.materials[*p.Material]
+	}
+	base := [4]float32{1, 1, 1, 1}
+	if material != nil {
+		base = material.Diffuse
+	}
+
+	normalMat := world.normalMatrix()
+	first := uint32(len(d.data.Vertices))
+	for i := 0; i < count; i++ {
+		v := Vertex{
+			Position: world.transformPoint([3]float32{pos[i*3], pos[i*3+1], pos[i*3+2]}),
+			Color:    base,
+		}
+		if normals != nil {
+			v.Normal = normalize3(normalMat.transformVector(
+				[3]float32{normals[i*3], normals[i*3+1], normals[i*3+2]}))
+		}
+		if uvs != nil {
+			v.UV = [2]float32{uvs[i*2], uvs[i*2+1]}
+		}
+		if colors != nil {
+			v.Color[0] *= colors[i*colorN]
+			v.Color[1] *= colors[i*colorN+1]
+			v.Color[2] *= colors[i*colorN+2]
+			if colorN == 4 {
+				v.Color[3] *= colors[i*colorN+3]
+			}
+		}
+		d.data.Vertices = append(d.data.Vertices, v)
+	}
+
+	var indices []uint32
+	if p.Indices != nil {
+		if indices, err = d.readIndices(*p.Indices); err != nil {
+			return err
+		}
+	} else {
+		indices = make([]uint32, count)
+		for i := range indices {
+			indices[i] = uint32(i)
+		}
+	}
+	for _, idx := range indices {
+		if int(idx) >= count {
+			return fmt.Errorf("index %d out of range", idx)
+		}
+	}
+	tris := triangulate(indices, mode)
+	// a mirroring transform turns counter-clockwise faces clockwise
+	flip := world.det3() < 0
+
+	firstIndex := uint32(len(d.data.Indices))
+	for i := 0; i+2 < len(tris); i += 3 {
+		a, b, c := tris[i], tris[i+1], tris[i+2]
+		if flip {
+			b, c = c, b
+		}
+		d.data.Indices = append(d.data.Indices, first+a, first+b, first+c)
+	}
+	if normals == nil {
+		computeNormals(d.data, first, firstIndex)
+	}
+	d.data.Groups = append(d.data.Groups, MeshGroup{
+		Material:   material,
+		FirstIndex: firstIndex,
+		IndexCount: uint32(len(d.data.Indices)) - firstIndex,
+	})
+	return nil
+}
+
+// triangulate converts strip and fan indices to a triangle list.
+func triangulate(indices []uint32, mode int) []uint32 {
+	switch mode {
+	case gltfTriangleStrip:
+		var tris []uint32
+		for i := 0; i+2 < len(indices); i++ {
+			if i%2 == 0 {
+				tris = append(tris, indices[i], indices[i+1], indices[i+2])
+			} else {
+				tris = append(tris, indices[i+1], indices[i], indices[i+2])
+			}
+		}
+		return tris
+	case gltfTriangleFan:
+		var tris []uint32
+		for i := 1; i+1 < len(indices); i++ {
+			tris = append(tris, indices[0], indices[i], indices[i+1])
+		}
+		return tris
+	}
+	return indices
+}
+
+// computeNormals sets the normals of the vertices from firstVertex on to the
+// average normal of the faces from firstIndex on sharing them.
+func computeNormals(data *MeshData, firstVertex, firstIndex uint32) {
+	sums := make([][3]float32, uint32(len(data.Vertices))-firstVertex)
+	idx := data.Indices[firstIndex:]
+	for i := 0; i+2 < len(idx); i += 3 {
+		a := data.Vertices[idx[i]].Position
+		b := data.Vertices[idx[i+1]].Position
+		c := data.Vertices[idx[i+2]].Position
+		n := cross3(sub3(b, a), sub3(c, a))
+		for _, j := range idx[i : i+3] {
+			sums[j-firstVertex] = add3(sums[j-firstVertex], n)
+		}
+	}
+	for i, sum := range sums {
+		data.Vertices[firstVertex+uint32(i)].Normal = normalize3(sum)
+	}
+}
+
+// uriPath converts a relative URI to a path relative to dir.
+func uriPath(dir, uri string) string {
+	if p, err := url.PathUnescape(uri); err == nil {
+		uri = p
+	}
+	return filepath.Join(dir, filepath.FromSlash(uri))
+}
+
+// local returns the local transform of the node, from either its matrix
+// or its translation, rotation and scale.
+func (n *gltfNode) local() mat4 {
+	if n.Matrix != nil {
+		return mat4(*n.Matrix)
+	}
+	m := mat4Identity()
+	if n.Scale != nil {
+		s := *n.Scale
+		m = mat4{s[0], 0, 0, 0, 0, s[1], 0, 0, 0, 0, s[2], 0, 0, 0, 0, 1}
+	}
+	if n.Rotation != nil {
+		q := *n.Rotation
+		x, y, z, w := q[0], q[1], q[2], q[3]
+		r := mat4{
+			1 - 2*(y*y+z*z), 2 * (x*y + z*w), 2 * (x*z - y*w), 0,
+			2 * (x*y - z*w), 1 - 2*(x*x+z*z), 2 * (y*z + x*w), 0,
+			2 * (x*z + y*w), 2 * (y*z - x*w), 1 - 2*(x*x+y*y), 0,
+			0, 0, 0, 1,
+		}
+		m = r.mul(m)
+	}
+	if n.Translation != nil {
+		t := *n.Translation
+		m[12], m[13], m[14] = t[0], t[1], t[2]
+	}
+	return m
+}
+
+// mat4 is a column-major 4x4 matrix, as stored by glTF.
+type mat4 [16]float32
+
+func mat4Identity() mat4 {
+	return mat4{1, 0, 0, 0, 0, 1, 0, 0, 0, 0, 1, 0, 0, 0, 0, 1}
+}
+
+func (a mat4) mul(b mat4) mat4 {
+	var m mat4
+	for c := 0; c < 4; c++ {
+		for r := 0; r < 4; r++ {
+			var v float32
+			for k := 0; k < 4; k++ {
+				v += a[k*4+r] * b[c*4+k]
+			}
+			m[c*4+r] = v
+		}
+	}
+	return m
+}
+
+func (a mat4) transformPoint(p [3]float32) [3]float32 {
+	return [3]float32{
+		a[0]*p[0] + a[4]*p[1] + a[8]*p[2] + a[12],
+		a[1]*p[0] + a[5]*p[1] + a[9]*p[2] + a[13],
+		a[2]*p[0] + a[6]*p[1] + a[10]*p[2] + a[14],
+	}
+}
+
+func (a mat4) transformVector(v [3]float32) [3]float32 {
+	return [3]float32{
+		a[0]*v[0] + a[4]*v[1] + a[8]*v[2],
+		a[1]*v[0] + a[5]*v[1] + a[9]*v[2],
+		a[2]*v[0] + a[6]*v[1] + a[10]*v[2],
+	}
+}
+
+// det3 is the determinant of the upper 3x3 part.
+func (a mat4) det3() float32 {
+	return a[0]*(a[5]*a[10]-a[9]*a[6]) -
+		a[4]*(a[1]*a[10]-a[9]*a[2]) +
+		a[8]*(a[1]*a[6]-a[5]*a[2])
+}
+
+// normalMatrix is the inverse transpose of the upper 3x3 part, up to a
+// scale factor, which transforms normals for non-uniform scaling.
+func (a mat4) normalMatrix() mat4 {
+	// the cofactor matrix is the inverse transpose times the determinant
+	m := mat4Identity()
+	m[0] = a[5]*a[10] - a[6]*a[9]
+	m[1] = a[6]*a[8] - a[4]*a[10]
+	m[2] = a[4]*a[9] - a[5]*a[8]
+	m[4] = a[2]*a[9] - a[1]*a[10]
+	m[5] = a[0]*a[10] - a[2]*a[8]
+	m[6] = a[1]*a[8] - a[0]*a[9]
+	m[8] = a[1]*a[6] - a[2]*a[5]
+	m[9] = a[2]*a[4] - a[0]*a[6]
+	m[10] = a[0]*a[5] - a[1]*a[4]
+	if a.det3() < 0 {
+		for i := range m[:11] {
+			m[i] = -m[i]
+		}
+	}
+	return m
+}
@@ -0,0 +1,209 @@
+package util
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"testing"
+)
+
+// parseTestGLTF loads a glTF document whose only buffer is the data URI
+// of buf. The document refers to it as %s.
+func parseTestGLTF(src string, buf []byte) (*MeshData, error) {
+	uri := "data:application/octet-stream;base64," + base64.StdEncoding.EncodeToString(buf)
+	doc := &gltfDocument{path: "test.gltf"}
+	if err := json.Unmarshal([]byte(fmt.Sprintf(src, uri)), &doc.gltf); err != nil {
+		return nil, err
+	}
+	return doc.meshData()
+}
+
+func putFloats(b *bytes.Buffer, v ...float32) {
+	for _, f := range v {
+		binary.Write(b, binary.LittleEndian, math.Float32bits(f))
+	}
+}
+
+// interleavedTriangle is a triangle whose positions and uvs share a
+// bufferView with a 20 byte stride, starting 8 bytes into the buffer,
+// followed by 16-bit indices 4 bytes into their own bufferView.
+const interleavedTriangle = `{
+	"buffers": [{"uri": "%s", "byteLength": 80}],
+	"bufferViews": [
+		{"buffer": 0, "byteOffset": 8, "byteLength": 60, "byteStride": 20},
+		{"buffer": 0, "byteOffset": 68, "byteLength": 12}
+	],
+	"accessors": [
+		{"bufferView": 0, "componentType": 5126, "count": 3, "type": "VEC3"},
+		{"bufferView": 0, "byteOffset": 12, "componentType": 5126, "count": 3, "type": "VEC2"},
+		{"bufferView": 1, "byteOffset": 4, "componentType": 5123, "count": 3, "type": "SCALAR"}
+	],
+	"meshes": [{"primitives": [{"attributes": {"POSITION": 0, "TEXCOORD_0": 1}, "indices": 2}]}],
+	"nodes": [{"mesh": 0}],
+	"scenes": [{"nodes": [0]}]
+}`
+
+func interleavedTriangleBuffer() []byte {
+	var b bytes.Buffer
+	b.Write(make([]byte, 8))
+	putFloats(&b, 0, 0, 0, 0, 0)
+	putFloats(&b, 1, 0, 0, 1, 0)
+	putFloats(&b, 0, 1, 0, 0, 1)
+	binary.Write(&b, binary.LittleEndian, []uint16{0xffff, 0xffff, 2, 1, 0, 0})
+	return b.Bytes()
+}
+
+func TestGLTFAccessorStrideOffset(t *testing.T) {
+	data, err := parseTestGLTF(interleavedTriangle, interleavedTriangleBuffer())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Vertex{
+		{Position: [3]float32{0, 0, 0}, UV: [2]float32{0, 0}},
+		{Position: [3]float32{1, 0, 0}, UV: [2]float32{1, 0}},
+		{Position: [3]float32{0, 1, 0}, UV: [2]float32{0, 1}},
+	}
+	if len(data.Vertices) != len(want) {
+		t.Fatalf("%d vertices, want %d", len(data.Vertices), len(want))
+	}
+	for i, w := range want {
+		v := data.Vertices[i]
+		if v.Position != w.Position || v.UV != w.UV {
+			t.Errorf("vertex %d at %v with uv %v, want %v with %v",
+				i, v.Position, v.UV, w.Position, w.UV)
+		}
+		// the indices wind clockwise in the xy plane
+		if v.Normal != [3]float32{0, 0, -1} {
+			t.Errorf("vertex %d has normal %v, want (0,0,-1)", i, v.Normal)
+		}
+	}
+	checkIndices(t, data.Indices, []uint32{2, 1, 0})
+	if len(data.Groups) != 1 || data.Groups[0].IndexCount != 3 {
+		t.Errorf("groups %+v, want one of 3 indices", data.Groups)
+	}
+}
+
+func TestGLTFNormalizedComponents(t *testing.T) {
+	const src = `{
+		"buffers": [{"uri": "%s", "byteLength": 16}],
+		"bufferViews": [{"buffer": 0, "byteLength": 16}],
+		"accessors": [
+			{"bufferView": 0, "componentType": 5122, "normalized": true, "count": 2, "type": "VEC2"},
+			{"bufferView": 0, "byteOffset": 8, "componentType": 5121, "normalized": true, "count": 2, "type": "VEC4"}
+		]
+	}`
+	var b bytes.Buffer
+	binary.Write(&b, binary.LittleEndian, []int16{32767, -32768, 0, -32767})
+	b.Write([]byte{0, 255, 51, 255, 255, 0, 0, 255})
+	uri := "data:application/octet-stream;base64," + base64.StdEncoding.EncodeToString(b.Bytes())
+	doc := &gltfDocument{path: "test.gltf"}
+	if err := json.Unmarshal([]byte(fmt.Sprintf(src, uri)), &doc.gltf); err != nil {
+		t.Fatal(err)
+	}
+	if err := doc.loadBuffers(); err != nil {
+		t.Fatal(err)
+	}
+	for idx, want := range [][]float32{
+		{1, -1, 0, -1},
+		{0, 1, 0.2, 1, 1, 0, 0, 1},
+	} {
+		got, _, err := doc.readAccessor(idx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := range want {
+			if math.Abs(float64(got[i]-want[i])) > 1e-6 {
+				t.Errorf("accessor %d is %v, want %v", idx, got, want)
+				break
+			}
+		}
+	}
+}
+
+func TestGLTFTruncated(t *testing.T) {
+	buf := interleavedTriangleBuffer()
+	if _, err := parseTestGLTF(interleavedTriangle, buf[:70]); err == nil {
+		t.Error("buffer shorter than its byteLength loaded")
+	}
+	for name, src := range map[string]string{
+		"bufferView past the buffer": `{
+			"buffers": [{"uri": "%s", "byteLength": 80}],
+			"bufferViews": [{"buffer": 0, "byteOffset": 40, "byteLength": 60}],
+			"accessors": [{"bufferView": 0, "componentType": 5126, "count": 3, "type": "VEC3"}],
+			"meshes": [{"primitives": [{"attributes": {"POSITION": 0}}]}],
+			"nodes": [{"mesh": 0}]
+		}`,
+		"accessor past the bufferView": `{
+			"buffers": [{"uri": "%s", "byteLength": 80}],
+			"bufferViews": [{"buffer": 0, "byteOffset": 8, "byteLength": 60, "byteStride": 20}],
+			"accessors": [{"bufferView": 0, "byteOffset": 12, "componentType": 5126, "count": 3, "type": "VEC3"}],
+			"meshes": [{"primitives": [{"attributes": {"POSITION": 0}}]}],
+			"nodes": [{"mesh": 0}]
+		}`,
+		"negative accessor count": `{
+			"buffers": [{"uri": "%s", "byteLength": 80}],
+			"bufferViews": [{"buffer": 0, "byteLength": 60}],
+			"accessors": [{"bufferView": 0, "componentType": 5126, "count": -1, "type": "VEC3"}],
+			"meshes": [{"primitives": [{"attributes": {"POSITION": 0}}]}],
+			"nodes": [{"mesh": 0}]
+		}`,
+		"indices past the bufferView": `{
+			"buffers": [{"uri": "%s", "byteLength": 80}],
+			"bufferViews": [
+				{"buffer": 0, "byteOffset": 8, "byteLength": 60, "byteStride": 20},
+				{"buffer": 0, "byteOffset": 68, "byteLength": 12}
+			],
+			"accessors": [
+				{"bufferView": 0, "componentType": 5126, "count": 3, "type": "VEC3"},
+				{"bufferView": 1, "byteOffset": 4, "componentType": 5123, "count": 6, "type": "SCALAR"}
+			],
+			"meshes": [{"primitives": [{"attributes": {"POSITION": 0}, "indices": 1}]}],
+			"nodes": [{"mesh": 0}]
+		}`,
+		"index out of range": `{
+			"buffers": [{"uri": "%s", "byteLength": 80}],
+			"bufferViews": [
+				{"buffer": 0, "byteOffset": 8, "byteLength": 60, "byteStride": 20},
+				{"buffer": 0, "byteOffset": 68, "byteLength": 12}
+			],
+			"accessors": [
+				{"bufferView": 0, "componentType": 5126, "count": 3, "type": "VEC3"},
+				{"bufferView": 1, "componentType": 5123, "count": 3, "type": "SCALAR"}
+			],
+			"meshes": [{"primitives": [{"attributes": {"POSITION": 0}, "indices": 1}]}],
+			"nodes": [{"mesh": 0}]
+		}`,
+	} {
+		if _, err := parseTestGLTF(src, buf); err == nil {
+			t.Errorf("%s: loaded without an error", name)
+		}
+	}
+}
+
+func TestParseGLBTruncated(t *testing.T) {
+	js := []byte(`{"asset":{"version":"2.0"}}`)
+	js = append(js, bytes.Repeat([]byte(" "), (4-len(js)%4)%4)...)
+	var glb bytes.Buffer
+	glb.WriteString("glTF")
+	binary.Write(&glb, binary.LittleEndian, []uint32{2, uint32(12 + 8 + len(js)), uint32(len(js)), 0x4E4F534A})
+	glb.Write(js)
+	raw := glb.Bytes()
+
+	if err := (&gltfDocument{}).parseGLB(raw); err != nil {
+		t.Fatalf("complete GLB: %v", err)
+	}
+	for _, n := range []int{4, 11, 24, len(raw) - 1} {
+		if err := (&gltfDocument{}).parseGLB(raw[:n]); err == nil {
+			t.Errorf("GLB truncated to %d of %d bytes parsed", n, len(raw))
+		}
+	}
+	// a chunk longer than the file
+	bad := append([]byte(nil), raw...)
+	binary.LittleEndian.PutUint32(bad[12:], uint32(len(js)+8))
+	if err := (&gltfDocument{}).parseGLB(bad); err == nil {
+		t.Error("GLB with an overlong chunk parsed")
+	}
+}
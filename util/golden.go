@@ -0,0 +1,170 @@
+package util
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// GoldenCase is a scene rendered headlessly and compared against
+// the golden image <Name>.png.
+type GoldenCase struct {
+	Name      string
+	SpinAngle float32
+}
+
+// DefaultGoldenCases render SpinningCube at fixed spin angles.
+var DefaultGoldenCases = []GoldenCase{
+	{Name: "spinningcube_000", SpinAngle: 0},
+	{Name: "spinningcube_030", SpinAngle: 30},
+	{Name: "spinningcube_090", SpinAngle: 90},
+	{Name: "spinningcube_135", SpinAngle: 135},
+}
+
+// GoldenOptions configures RunGoldenCases.
+type GoldenOptions struct {
+	// Dir holds the golden PNGs, and receives the rendered and diff
+	// images of failing cases.
+	Dir    string
+	Width  uint32
+	Height uint32
+
+	// Tolerance is the largest per-channel difference for which
+	// a pixel still matches.
+	Tolerance uint8
+	// MaxBadPixels is the number of pixels allowed outside Tolerance.
+	MaxBadPixels int
+
+	// Update overwrites the golden images with the rendered frames.
+	Update bool
+}
+
+// RunGoldenCases renders every case headlessly and compares it against its
+// golden image. vk.Init must have been called. All cases are run, the error
+// lists every failing one.
+func RunGoldenCases(cases []GoldenCase, opts GoldenOptions) error {
+	if opts.Update {
+		if err := os.MkdirAll(opts.Dir, 0755); err != nil {
+			return err
+		}
+	}
+	var failed []string
+	for _, c := range cases {
+		if err := runGoldenCase(c, opts); err != nil {
+			log.Printf("golden %s: FAIL: %v", c.Name, err)
+			failed = append(failed, c.Name)
+			continue
+		}
+		log.Printf("golden %s: ok", c.Name)
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("golden: %d of %d cases failed: %s",
+			len(failed), len(cases), strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+func runGoldenCase(c GoldenCase, opts GoldenOptions) error {
+	cube := NewSpinningCube(c.SpinAngle)
+	// every case compiles its pipelines anew, without side effects
+	cube.SetPipelineCachePath("")
+	h, err := NewHeadless(cube, opts.Width, opts.Height)
+	if err != nil {
+		return err
+	}
+	defer h.Destroy()
+
+	cube.NextFrame()
+	img, err := h.Render()
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(opts.Dir, c.Name+".png")
+	if opts.Update {
+		return SavePNG(path, img)
+	}
+	return CheckGolden(path, img, opts.Tolerance, opts.MaxBadPixels)
+}
+
+// CheckGolden compares img against the golden PNG at path. On mismatch the
+// rendered frame and a diff image, with failing pixels in red, are written
+// next to the golden as <name>.got.png and <name>.diff.png.
+func CheckGolden(path string, img *image.RGBA, tolerance uint8, maxBadPixels int) error {
+	want, err := loadPNG(path)
+	if err != nil {
+		return err
+	}
+	bad, diff, err := CompareImages(img, want, tolerance)
+	if err != nil {
+		return err
+	}
+	if bad <= maxBadPixels {
+		return nil
+	}
+	base := strings.TrimSuffix(path, filepath.Ext(path))
+	if err := SavePNG(base+".got.png", img); err != nil {
+		log.Println(err)
+	}
+	if err := SavePNG(base+".diff.png", diff); err != nil {
+		log.Println(err)
+	}
+	return fmt.Errorf("%d pixels differ by more than %d (allowed %d), see %s.diff.png",
+		bad, tolerance, maxBadPixels, base)
+}
+
+// CompareImages counts the pixels of got and want that differ by more than
+// tolerance in any channel. The diff image shows those pixels in red over
+// a dimmed copy of got.
+func CompareImages(got, want *image.RGBA, tolerance uint8) (bad int, diff *image.RGBA, err error) {
+	if got.Bounds().Size() != want.Bounds().Size() {
+		err = fmt.Errorf("image size %v, want %v", got.Bounds().Size(), want.Bounds().Size())
+		return 0, nil, err
+	}
+	size := got.Bounds().Size()
+	diff = image.NewRGBA(image.Rect(0, 0, size.X, size.Y))
+	for y := 0; y < size.Y; y++ {
+		for x := 0; x < size.X; x++ {
+			g := got.RGBAAt(got.Bounds().Min.X+x, got.Bounds().Min.Y+y)
+			w := want.RGBAAt(want.Bounds().Min.X+x, want.Bounds().Min.Y+y)
+			if channelDiff(g.R, w.R) > tolerance || channelDiff(g.G, w.G) > tolerance ||
+				channelDiff(g.B, w.B) > tolerance || channelDiff(g.A, w.A) > tolerance {
+				bad++
+				diff.SetRGBA(x, y, color.RGBA{R: 0xff, A: 0xff})
+				continue
+			}
+			diff.SetRGBA(x, y, color.RGBA{R: g.R / 4, G: g.G / 4, B: g.B / 4, A: 0xff})
+		}
+	}
+	return bad, diff, nil
+}
+
+func channelDiff(a, b uint8) uint8 {
+	if a > b {
+		return a - b
+	}
+	return b - a
+}
+
+func loadPNG(path string) (*image.RGBA, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, errors.New("no golden image at " + path + ", record it with Update")
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	img, err := png.Decode(f)
+	if err != nil {
+		return nil, err
+	}
+	rgba := image.NewRGBA(img.Bounds())
+	draw.Draw(rgba, rgba.Bounds(), img, img.Bounds().Min, draw.Src)
+	return rgba, nil
+}
@@ -0,0 +1,169 @@
+package util
+
+import (
+	"flag"
+	"image"
+	"image/color"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	vk "github.com/vulkan-go/vulkan"
+)
+
+var updateGolden = flag.Bool("update", false, "record the golden PNGs of testdata/golden")
+
+// TestGolden renders DefaultGoldenCases headlessly and compares them
+// against testdata/golden, recorded with -update on lavapipe as described
+// in testdata/golden/README.md. It is skipped without a Vulkan device,
+// with one a case without a golden image fails.
+func TestGolden(t *testing.T) {
+	if !vulkanAvailable() {
+		t.Skip("no Vulkan implementation with a physical device")
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the shaders and textures are loaded relative to the repository root
+	if err := os.Chdir(".."); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	err = RunGoldenCases(DefaultGoldenCases, GoldenOptions{
+		Dir:       filepath.Join(wd, "testdata", "golden"),
+		Width:     256,
+		Height:    256,
+		Tolerance: 2,
+		Update:    *updateGolden,
+	})
+	if err != nil {
+		t.Fatalf("%v (record missing golden images with go test -run TestGolden -update)", err)
+	}
+}
+
+// vulkanAvailable reports whether the Vulkan loader can be initialized
+// and finds a physical device.
+func vulkanAvailable() bool {
+	if vk.SetDefaultGetInstanceProcAddr() != nil || vk.Init() != nil {
+		return false
+	}
+	var instance vk.Instance
+	ret := vk.CreateInstance(&vk.InstanceCreateInfo{
+		SType: vk.StructureTypeInstanceCreateInfo,
+	}, nil, &instance)
+	if ret != vk.Success {
+		return false
+	}
+	defer vk.DestroyInstance(instance, nil)
+	var gpuCount uint32
+	ret = vk.EnumeratePhysicalDevices(instance, &gpuCount, nil)
+	return ret == vk.Success && gpuCount > 0
+}
+
+func solidImage(w, h int, c color.RGBA) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.SetRGBA(x, y, c)
+		}
+	}
+	return img
+}
+
+func TestCompareImagesTolerance(t *testing.T) {
+	want := solidImage(4, 4, color.RGBA{100, 100, 100, 255})
+	for _, tc := range []struct {
+		got       color.RGBA
+		tolerance uint8
+		bad       int
+	}{
+		{color.RGBA{100, 100, 100, 255}, 0, 0},
+		{color.RGBA{102, 98, 100, 255}, 2, 0},
+		{color.RGBA{103, 100, 100, 255}, 2, 16},
+		{color.RGBA{100, 100, 97, 255}, 2, 16},
+		{color.RGBA{100, 100, 100, 250}, 4, 16},
+		{color.RGBA{100, 100, 100, 250}, 5, 0},
+	} {
+		got := solidImage(4, 4, tc.got)
+		bad, diff, err := CompareImages(got, want, tc.tolerance)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bad != tc.bad {
+			t.Errorf("%v against %v, tolerance %d: %d bad pixels, want %d",
+				tc.got, want.RGBAAt(0, 0), tc.tolerance, bad, tc.bad)
+		}
+		if diff.Bounds() != want.Bounds() {
+			t.Errorf("diff bounds %v, want %v", diff.Bounds(), want.Bounds())
+		}
+	}
+}
+
+func TestCompareImagesBadPixels(t *testing.T) {
+	want := solidImage(8, 8, color.RGBA{0, 0, 0, 255})
+	got := solidImage(8, 8, color.RGBA{0, 0, 0, 255})
+	bads := []image.Point{{0, 0}, {3, 5}, {7, 7}}
+	for _, p := range bads {
+		got.SetRGBA(p.X, p.Y, color.RGBA{0, 200, 0, 255})
+	}
+	bad, diff, err := CompareImages(got, want, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bad != len(bads) {
+		t.Fatalf("%d bad pixels, want %d", bad, len(bads))
+	}
+	red := color.RGBA{R: 0xff, A: 0xff}
+	for _, p := range bads {
+		if c := diff.RGBAAt(p.X, p.Y); c != red {
+			t.Errorf("diff at %v is %v, want %v", p, c, red)
+		}
+	}
+	if c := diff.RGBAAt(1, 1); c == red {
+		t.Errorf("diff at (1,1) marks a matching pixel")
+	}
+}
+
+func TestCompareImagesSize(t *testing.T) {
+	_, _, err := CompareImages(solidImage(4, 4, color.RGBA{}), solidImage(4, 5, color.RGBA{}), 0)
+	if err == nil {
+		t.Fatal("images of different sizes compared equal")
+	}
+}
+
+func TestCheckGoldenMaxBadPixels(t *testing.T) {
+	dir, err := ioutil.TempDir("", "golden")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "case.png")
+	want := solidImage(4, 4, color.RGBA{10, 20, 30, 255})
+	if err := SavePNG(path, want); err != nil {
+		t.Fatal(err)
+	}
+	got := solidImage(4, 4, color.RGBA{10, 20, 30, 255})
+	got.SetRGBA(1, 2, color.RGBA{255, 20, 30, 255})
+	got.SetRGBA(2, 1, color.RGBA{10, 255, 30, 255})
+
+	if err := CheckGolden(path, got, 2, 2); err != nil {
+		t.Errorf("2 bad pixels with 2 allowed: %v", err)
+	}
+	err = CheckGolden(path, got, 2, 1)
+	if err == nil || !strings.Contains(err.Error(), "2 pixels differ") {
+		t.Errorf("2 bad pixels with 1 allowed: %v", err)
+	}
+	for _, name := range []string{"case.got.png", "case.diff.png"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Errorf("failing case did not write %s: %v", name, err)
+		}
+	}
+	if err := CheckGolden(filepath.Join(dir, "missing.png"), got, 2, 0); err == nil {
+		t.Error("missing golden image matched")
+	}
+}
@@ -0,0 +1,181 @@
+package util
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+
+	vk "github.com/vulkan-go/vulkan"
+)
+
+// decodeHDR decodes a Radiance RGBE image, as written for HDR environment
+// maps, into R32G32B32A32_SFLOAT texels with an alpha of 1.
+func decodeHDR(data []byte) (*TextureImage, error) {
+	// the header lines end with an empty line, followed by the resolution
+	var width, height int
+	var flipX, flipY bool
+	pos := 0
+	readLine := func() (string, error) {
+		i := bytes.IndexByte(data[pos:], '\n')
+		if i < 0 {
+			return "", errors.New("truncated header")
+		}
+		line := string(data[pos : pos+i])
+		pos += i + 1
+		return strings.TrimRight(line, "\r"), nil
+	}
+	for {
+		line, err := readLine()
+		if err != nil {
+			return nil, err
+		}
+		if line == "" {
+			break
+		}
+		if strings.HasPrefix(line, "FORMAT=") && line != "FORMAT=32-bit_rle_rgbe" {
+			return nil, fmt.Errorf("unsupported %s", line)
+		}
+	}
+	line, err := readLine()
+	if err != nil {
+		return nil, err
+	}
+	var ySign, xSign, yAxis, xAxis byte
+	if _, err := fmt.Sscanf(line, "%c%c %d %c%c %d",
+		&ySign, &yAxis, &height, &xSign, &xAxis, &width); err != nil || yAxis != 'Y' || xAxis != 'X' {
+		return nil, fmt.Errorf("unsupported resolution %q", line)
+	}
+	if width <= 0 || height <= 0 {
+		return nil, errors.New("empty image")
+	}
+	// -Y +X is the usual top-down, left to right order
+	flipY = ySign == '+'
+	flipX = xSign == '-'
+
+	texels := make([]byte, 16*width*height)
+	scanline := make([]byte, 4*width)
+	for y := 0; y < height; y++ {
+		n, err := readScanline(data[pos:], scanline)
+		if err != nil {
+			return nil, fmt.Errorf("scanline %d: %v", y, err)
+		}
+		pos += n
+		row := y
+		if flipY {
+			row = height - 1 - y
+		}
+		for x := 0; x < width; x++ {
+			col := x
+			if flipX {
+				col = width - 1 - x
+			}
+			rgbe := scanline[4*x : 4*x+4]
+			var scale float32
+			if rgbe[3] != 0 {
+				scale = float32(math.Ldexp(1, int(rgbe[3])-(128+8)))
+			}
+			t := texels[16*(row*width+col):]
+			binary.LittleEndian.PutUint32(t[0:], math.Float32bits(float32(rgbe[0])*scale))
+			binary.LittleEndian.PutUint32(t[4:], math.Float32bits(float32(rgbe[1])*scale))
+			binary.LittleEndian.PutUint32(t[8:], math.Float32bits(float32(rgbe[2])*scale))
+			binary.LittleEndian.PutUint32(t[12:], math.Float32bits(1))
+		}
+	}
+	return &TextureImage{
+		Format: vk.FormatR32g32b32a32Sfloat,
+		Width:  width,
+		Height: height,
+		Levels: [][]byte{texels},
+	}, nil
+}
+
+// readScanline reads the RGBE pixels of one scanline from data into
+// scanline, and returns the number of bytes read. Scanlines are either
+// flat, run-length encoded per component, or in the old run-length
+// encoding that repeats the previous pixel.
+func readScanline(data []byte, scanline []byte) (int, error) {
+	width := len(scanline) / 4
+	errShort := errors.New("truncated image data")
+	if width < 8 || width > 0x7fff || len(data) < 4 ||
+		data[0] != 2 || data[1] != 2 || data[2]&0x80 != 0 {
+		return readFlatScanline(data, scanline)
+	}
+	if int(data[2])<<8|int(data[3]) != width {
+		return 0, errors.New("scanline width mismatch")
+	}
+	pos := 4
+	// the four components are encoded one after the other
+	for c := 0; c < 4; c++ {
+		for x := 0; x < width; {
+			if pos >= len(data) {
+				return 0, errShort
+			}
+			n := int(data[pos])
+			pos++
+			if n > 128 {
+				n -= 128
+				if pos >= len(data) {
+					return 0, errShort
+				}
+				if x+n > width {
+					return 0, errors.New("run past the end of the scanline")
+				}
+				v := data[pos]
+				pos++
+				for ; n > 0; n-- {
+					scanline[4*x+c] = v
+					x++
+				}
+			} else {
+				if n == 0 || x+n > width {
+					return 0, errors.New("bad run length")
+				}
+				if pos+n > len(data) {
+					return 0, errShort
+				}
+				for ; n > 0; n-- {
+					scanline[4*x+c] = data[pos]
+					pos++
+					x++
+				}
+			}
+		}
+	}
+	return pos, nil
+}
+
+func readFlatScanline(data []byte, scanline []byte) (int, error) {
+	width := len(scanline) / 4
+	pos := 0
+	shift := uint(0)
+	for x := 0; x < width; {
+		if pos+4 > len(data) {
+			return 0, errors.New("truncated image data")
+		}
+		p := data[pos : pos+4]
+		pos += 4
+		if p[0] == 1 && p[1] == 1 && p[2] == 1 {
+			// old run-length encoding, repeats the previous pixel
+			if x == 0 {
+				return 0, errors.New("run without a previous pixel")
+			}
+			n := int(p[3]) << shift
+			if x+n > width {
+				return 0, errors.New("run past the end of the scanline")
+			}
+			for ; n > 0; n-- {
+				copy(scanline[4*x:4*x+4], scanline[4*x-4:4*x])
+				x++
+			}
+			shift += 8
+			continue
+		}
+		copy(scanline[4*x:4*x+4], p)
+		x++
+		shift = 0
+	}
+	return pos, nil
+}
@@ -0,0 +1,113 @@
+package util
+
+import (
+	"encoding/binary"
+	"math"
+	"testing"
+)
+
+const hdrHeader = "#?RADIANCE\nFORMAT=32-bit_rle_rgbe\nEXPOSURE=1.0\n\n"
+
+func hdrTexels(t *testing.T, img *TextureImage) []float32 {
+	t.Helper()
+	if len(img.Levels) != 1 || len(img.Levels[0]) != 16*img.Width*img.Height {
+		t.Fatalf("%d levels, want one of %dx%d texels", len(img.Levels), img.Width, img.Height)
+	}
+	f := make([]float32, 4*img.Width*img.Height)
+	for i := range f {
+		f[i] = math.Float32frombits(binary.LittleEndian.Uint32(img.Levels[0][4*i:]))
+	}
+	return f
+}
+
+func checkHDRRow(t *testing.T, f []float32, width, row int, want [][3]float32) {
+	t.Helper()
+	for x, w := range want {
+		got := f[4*(row*width+x) : 4*(row*width+x)+4]
+		if got[0] != w[0] || got[1] != w[1] || got[2] != w[2] || got[3] != 1 {
+			t.Errorf("texel (%d,%d) is %v, want %v with alpha 1", x, row, got, w)
+		}
+	}
+}
+
+func TestDecodeHDRRLE(t *testing.T) {
+	data := []byte(hdrHeader + "+Y 2 +X 8\n")
+	data = append(data,
+		// the bottom row: red is a run of 128, green literals,
+		// blue runs of 0 and 64, the exponent a run of 2^-7
+		2, 2, 0, 8,
+		128+8, 128,
+		8, 0, 16, 32, 48, 64, 80, 96, 112,
+		128+4, 0, 128+4, 64,
+		128+8, 129,
+		// the top row: all components are runs
+		2, 2, 0, 8,
+		128+8, 64,
+		128+8, 0,
+		128+8, 0,
+		128+8, 130,
+	)
+	img, err := decodeHDR(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img.Width != 8 || img.Height != 2 {
+		t.Fatalf("size %dx%d, want 8x2", img.Width, img.Height)
+	}
+	f := hdrTexels(t, img)
+	// +Y stores the rows bottom-up
+	checkHDRRow(t, f, 8, 1, [][3]float32{
+		{1, 0, 0}, {1, 0.125, 0}, {1, 0.25, 0}, {1, 0.375, 0},
+		{1, 0.5, 0.5}, {1, 0.625, 0.5}, {1, 0.75, 0.5}, {1, 0.875, 0.5},
+	})
+	var top [][3]float32
+	for x := 0; x < 8; x++ {
+		top = append(top, [3]float32{1, 0, 0})
+	}
+	checkHDRRow(t, f, 8, 0, top)
+}
+
+func TestDecodeHDRFlat(t *testing.T) {
+	// 4 pixels are too short for the run-length encoding per component,
+	// the old encoding repeats the previous pixel
+	data := []byte(hdrHeader + "-Y 1 -X 4\n")
+	data = append(data,
+		128, 64, 0, 129,
+		1, 1, 1, 2,
+		0, 0, 0, 0,
+	)
+	img, err := decodeHDR(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// -X stores the columns right to left
+	checkHDRRow(t, hdrTexels(t, img), 4, 0, [][3]float32{
+		{0, 0, 0}, {1, 0.5, 0}, {1, 0.5, 0}, {1, 0.5, 0},
+	})
+}
+
+func TestDecodeHDRErrors(t *testing.T) {
+	rle := []byte(hdrHeader + "-Y 1 +X 8\n")
+	rle = append(rle, 2, 2, 0, 8, 128+8, 128, 128+8, 0, 128+8, 0, 128+8, 129)
+	if _, err := decodeHDR(rle); err != nil {
+		t.Fatalf("complete image: %v", err)
+	}
+	for _, n := range []int{len(hdrHeader) - 1, len(hdrHeader) + 4, len(rle) - 1} {
+		if _, err := decodeHDR(rle[:n]); err == nil {
+			t.Errorf("HDR truncated to %d of %d bytes decoded", n, len(rle))
+		}
+	}
+	for name, src := range map[string]string{
+		"format":       "#?RADIANCE\nFORMAT=32-bit_rle_xyze\n\n-Y 1 +X 1\n\x80\x80\x80\x80",
+		"resolution":   hdrHeader + "+X 1 -Y 1\n\x80\x80\x80\x80",
+		"empty":        hdrHeader + "-Y 0 +X 1\n",
+		"width":        hdrHeader + "-Y 1 +X 8\n\x02\x02\x00\x09",
+		"run past end": hdrHeader + "-Y 1 +X 8\n\x02\x02\x00\x08\x89\x80",
+		"zero run":     hdrHeader + "-Y 1 +X 8\n\x02\x02\x00\x08\x00",
+		"first repeat": hdrHeader + "-Y 1 +X 2\n\x01\x01\x01\x02",
+	} {
+		if _, err := decodeHDR([]byte(src)); err == nil {
+			t.Errorf("%s: decoded without an error", name)
+		}
+	}
+}
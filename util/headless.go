@@ -0,0 +1,195 @@
+package util
+
+import (
+	"image"
+
+	as "github.com/vulkan-go/asche"
+	vk "github.com/vulkan-go/vulkan"
+)
+
+// Headless renders a SpinningCube into an offscreen color+depth target,
+// without a window, a surface or a swapchain. It runs on any Vulkan
+// implementation that exposes a graphics queue, including software ICDs
+// such as lavapipe.
+type Headless struct {
+	cube *SpinningCube
+	ctx  *headlessContext
+}
+
+// NewHeadless creates a Vulkan device without a surface and prepares cube
+// to render width x height frames offscreen. vk.Init must have been called.
+func NewHeadless(cube *SpinningCube, width, height uint32) (h *Headless, err error) {
+	defer checkErr(&err)
+
+	ctx := &headlessContext{
+		dimensions: &as.SwapchainDimensions{
+			Width: width, Height: height, Format: vk.FormatR8g8b8a8Srgb,
+		},
+	}
+	ctx.prepareDevice(nil, nil)
+	ctx.prepareCommandPool()
+
+	cube.headless = ctx
+	// the target is allocated from the cube's memory, where
+	// MemoryStats accounts for it
+	cube.memory = newMemoryAllocator(ctx.device, ctx.gpu, ctx.memProps)
+	ctx.prepareTarget(cube.memory)
+	ctx.beginInitCmd()
+	orPanic(cube.VulkanContextPrepare())
+	ctx.flushInitCmd()
+	cube.deletions.flush()
+
+	h = &Headless{
+		cube: cube,
+		ctx:  ctx,
+	}
+	return h, nil
+}
+
+// Render draws a single frame and returns the contents of the color target.
+func (h *Headless) Render() (img *image.RGBA, err error) {
+	defer checkErr(&err)
+
+	orPanic(h.cube.VulkanContextInvalidate(0))
+	if h.cube.record != nil {
+		// the dynamic mode records every frame anew
+		h.cube.drawBuildCommandBuffer(h.ctx.target, h.ctx.target.cmd, 0,
+			vk.CommandBufferUsageOneTimeSubmitBit)
+	}
+	submitAndWait(h.ctx.device, h.ctx.queue, h.ctx.target.cmd)
+	// frames are rendered synchronously, nothing is in flight anymore
+	h.cube.deletions.flush()
+	return h.cube.CaptureFrame()
+}
+
+// Destroy releases the cube resources and the headless device.
+func (h *Headless) Destroy() {
+	vk.DeviceWaitIdle(h.ctx.device)
+	orPanic(h.cube.VulkanContextCleanup())
+	// the memory of the target is freed before the cube's allocator
+	h.ctx.target.Destroy(h.ctx.device)
+	h.cube.Destroy()
+	h.cube.headless = nil
+	h.ctx.destroy()
+}
+
+// headlessContext implements renderContext on a device created without
+// a surface, with a single offscreen target in place of the swapchain images.
+type headlessContext struct {
+	deviceContext
+
+	dimensions *as.SwapchainDimensions
+	target     *offscreenTarget
+}
+
+func (c *headlessContext) Dimensions() *as.SwapchainDimensions {
+	return c.dimensions
+}
+
+func (c *headlessContext) ImageResources() []imageResources {
+	return []imageResources{c.target}
+}
+
+// FramesInFlight returns 1, frames are rendered synchronously.
+func (c *headlessContext) FramesInFlight() int {
+	return 1
+}
+
+func (c *headlessContext) ColorFinalLayout() vk.ImageLayout {
+	return vk.ImageLayoutTransferSrcOptimal
+}
+
+func (c *headlessContext) prepareTarget(memory *memoryAllocator) {
+	dim := c.dimensions
+	c.target = newOffscreenTarget(c.device, memory, dim.Width, dim.Height, dim.Format)
+	c.target.cmd = allocCommandBuffer(c.device, c.cmdPool)
+}
+
+// offscreenTarget stands in for a swapchain image when rendering headless
+// or capturing a frame.
+type offscreenTarget struct {
+	image vk.Image
+	mem   *memoryAllocation
+	view  vk.ImageView
+
+	framebuffer vk.Framebuffer
+	descSet     vk.DescriptorSet
+	cmd         vk.CommandBuffer
+}
+
+func newOffscreenTarget(dev vk.Device, memory *memoryAllocator,
+	width, height uint32, format vk.Format) *offscreenTarget {
+
+	t := &offscreenTarget{}
+	var image vk.Image
+	ret := vk.CreateImage(dev, &vk.ImageCreateInfo{
+		SType:     vk.StructureTypeImageCreateInfo,
+		ImageType: vk.ImageType2d,
+		Format:    format,
+		Extent: vk.Extent3D{
+			Width:  width,
+			Height: height,
+			Depth:  1,
+		},
+		MipLevels:   1,
+		ArrayLayers: 1,
+		Samples:     vk.SampleCount1Bit,
+		Tiling:      vk.ImageTilingOptimal,
+		Usage: vk.ImageUsageFlags(vk.ImageUsageColorAttachmentBit |
+			vk.ImageUsageTransferSrcBit),
+	}, nil, &image)
+	orPanic(as.NewError(ret))
+	t.image = image
+	t.mem = memory.bindImage(image, vk.ImageTilingOptimal, vk.MemoryPropertyDeviceLocalBit)
+
+	var view vk.ImageView
+	ret = vk.CreateImageView(dev, &vk.ImageViewCreateInfo{
+		SType:    vk.StructureTypeImageViewCreateInfo,
+		Image:    image,
+		ViewType: vk.ImageViewType2d,
+		Format:   format,
+		SubresourceRange: vk.ImageSubresourceRange{
+			AspectMask: vk.ImageAspectFlags(vk.ImageAspectColorBit),
+			LevelCount: 1,
+			LayerCount: 1,
+		},
+	}, nil, &view)
+	orPanic(as.NewError(ret))
+	t.view = view
+	return t
+}
+
+func (t *offscreenTarget) Image() vk.Image {
+	return t.image
+}
+
+func (t *offscreenTarget) View() vk.ImageView {
+	return t.view
+}
+
+func (t *offscreenTarget) Framebuffer() vk.Framebuffer {
+	return t.framebuffer
+}
+
+func (t *offscreenTarget) SetFramebuffer(fb vk.Framebuffer) {
+	t.framebuffer = fb
+}
+
+func (t *offscreenTarget) DescriptorSet() vk.DescriptorSet {
+	return t.descSet
+}
+
+func (t *offscreenTarget) SetDescriptorSet(set vk.DescriptorSet) {
+	t.descSet = set
+}
+
+func (t *offscreenTarget) CommandBuffer() vk.CommandBuffer {
+	return t.cmd
+}
+
+func (t *offscreenTarget) Destroy(dev vk.Device) {
+	vk.DestroyFramebuffer(dev, t.framebuffer, nil)
+	vk.DestroyImageView(dev, t.view, nil)
+	vk.DestroyImage(dev, t.image, nil)
+	t.mem.free()
+}
@@ -0,0 +1,69 @@
+package util
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	vk "github.com/vulkan-go/vulkan"
+)
+
+const ktx2Magic = "\xabKTX 20\xbb\r\n\x1a\n"
+
+// decodeKTX2 reads the mip levels of a 2D KTX2 texture. The texels are kept
+// in the Vulkan format of the container, supercompressed (Basis Universal,
+// Zstandard) textures are not supported.
+func decodeKTX2(data []byte) (*TextureImage, error) {
+	if len(data) < 80 {
+		return nil, errors.New("truncated header")
+	}
+	le := binary.LittleEndian
+	format := vk.Format(le.Uint32(data[12:]))
+	width := int(le.Uint32(data[20:]))
+	height := int(le.Uint32(data[24:]))
+	depth := le.Uint32(data[28:])
+	layers := le.Uint32(data[32:])
+	faces := le.Uint32(data[36:])
+	levels := int(le.Uint32(data[40:]))
+	supercompression := le.Uint32(data[44:])
+
+	switch {
+	case format == vk.FormatUndefined:
+		return nil, errors.New("Basis Universal textures are not supported")
+	case supercompression != 0:
+		return nil, fmt.Errorf("supercompression scheme %d not supported", supercompression)
+	case depth > 0 || layers > 1 || faces != 1:
+		return nil, errors.New("only 2D textures are supported")
+	case width == 0 || height == 0:
+		return nil, errors.New("empty image")
+	}
+	if levels == 0 {
+		// the mip levels are to be generated
+		levels = 1
+	}
+	if len(data) < 80+24*levels {
+		return nil, errors.New("truncated level index")
+	}
+
+	img := &TextureImage{
+		Format: format,
+		Width:  width,
+		Height: height,
+	}
+	w, h := width, height
+	for i := 0; i < levels; i++ {
+		index := data[80+24*i:]
+		offset := le.Uint64(index)
+		length := le.Uint64(index[8:])
+		size, err := levelSize(format, w, h)
+		if err != nil {
+			return nil, err
+		}
+		if length != uint64(size) || offset > uint64(len(data)) || uint64(len(data))-offset < length {
+			return nil, fmt.Errorf("bad level %d", i)
+		}
+		img.Levels = append(img.Levels, data[offset:offset+length])
+		w, h = mipSize(w), mipSize(h)
+	}
+	return img, nil
+}
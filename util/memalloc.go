@@ -0,0 +1,420 @@
+package util
+
+import (
+	"fmt"
+	"sort"
+	"unsafe"
+
+	as "github.com/vulkan-go/asche"
+	vk "github.com/vulkan-go/vulkan"
+)
+
+// memoryBlockSize is the size of the device memory blocks resources are
+// suballocated from. Resources needing more than half of it get a
+// dedicated block of their own.
+const memoryBlockSize = 64 << 20
+
+// allocStrategy is how allocations are placed in the blocks of a pool.
+type allocStrategy int
+
+const (
+	// allocFreeList places allocations first fit into the free ranges of
+	// the blocks, freed ranges are merged with their free neighbours.
+	allocFreeList allocStrategy = iota
+	// allocLinear places allocations one after another, a block is reused
+	// once all of its allocations have been freed. It suits short-lived
+	// allocations freed together, such as staging buffers.
+	allocLinear
+)
+
+// resourceKind tells linear resources, buffers and linear tiling images,
+// from optimal tiling images. Neighbours of different kinds must not share
+// a bufferImageGranularity page.
+type resourceKind int
+
+const (
+	resourceLinear resourceKind = iota
+	resourceOptimal
+)
+
+// memoryAllocator suballocates the memory of buffers and images from large
+// device memory blocks, keeping the number of device memory allocations well
+// below maxMemoryAllocationCount. Blocks are kept in a pool per memory type
+// and strategy. Host-visible blocks are mapped for as long as they live.
+type memoryAllocator struct {
+	dev         vk.Device
+	memProps    vk.PhysicalDeviceMemoryProperties
+	granularity vk.DeviceSize
+	pools       map[memoryPoolKey]*memoryPool
+}
+
+type memoryPoolKey struct {
+	typeIndex uint32
+	strategy  allocStrategy
+}
+
+type memoryPool struct {
+	memoryPoolKey
+	allocator *memoryAllocator
+	blocks    []*memoryBlock
+}
+
+type memoryBlock struct {
+	mem       vk.DeviceMemory
+	size      vk.DeviceSize
+	dedicated bool
+	// data is the mapped memory of host-visible blocks
+	data []byte
+	live int
+	used vk.DeviceSize
+
+	// chunks cover free-list blocks in order of offset, there are
+	// no two free chunks in a row
+	chunks []memoryChunk
+	// top is the end of the last allocation of linear blocks,
+	// last its kind
+	top  vk.DeviceSize
+	last resourceKind
+}
+
+type memoryChunk struct {
+	offset vk.DeviceSize
+	size   vk.DeviceSize
+	used   bool
+	kind   resourceKind
+}
+
+// memoryAllocation is the range of a memory block a resource is bound to.
+type memoryAllocation struct {
+	pool   *memoryPool
+	block  *memoryBlock
+	offset vk.DeviceSize
+	size   vk.DeviceSize
+}
+
+func newMemoryAllocator(dev vk.Device, gpu vk.PhysicalDevice,
+	memProps vk.PhysicalDeviceMemoryProperties) *memoryAllocator {
+
+	var props vk.PhysicalDeviceProperties
+	vk.GetPhysicalDeviceProperties(gpu, &props)
+	props.Deref()
+	props.Limits.Deref()
+	granularity := props.Limits.BufferImageGranularity
+	if granularity == 0 {
+		granularity = 1
+	}
+	return &memoryAllocator{
+		dev:         dev,
+		memProps:    memProps,
+		granularity: granularity,
+		pools:       make(map[memoryPoolKey]*memoryPool),
+	}
+}
+
+// alloc allocates memory meeting reqs from a memory type with props.
+// Unless props ask for host-visible memory, it falls back to any memory
+// type reqs allow.
+func (a *memoryAllocator) alloc(reqs vk.MemoryRequirements, props vk.MemoryPropertyFlagBits,
+	kind resourceKind, strategy allocStrategy) (*memoryAllocation, error) {
+
+	typeIndex, ok := a.memoryType(reqs.MemoryTypeBits, props)
+	if !ok && props&vk.MemoryPropertyHostVisibleBit != 0 {
+		return nil, fmt.Errorf("vulkan: no memory type in %#x with properties %#x for %d bytes",
+			reqs.MemoryTypeBits, props, reqs.Size)
+	}
+	if !ok {
+		typeIndex, ok = a.memoryType(reqs.MemoryTypeBits, 0)
+	}
+	if !ok {
+		return nil, fmt.Errorf("vulkan: no memory type in %#x for %d bytes",
+			reqs.MemoryTypeBits, reqs.Size)
+	}
+	key := memoryPoolKey{
+		typeIndex: typeIndex,
+		strategy:  strategy,
+	}
+	pool := a.pools[key]
+	if pool == nil {
+		pool = &memoryPool{
+			memoryPoolKey: key,
+			allocator:     a,
+		}
+		a.pools[key] = pool
+	}
+
+	if reqs.Size > memoryBlockSize/2 {
+		block := a.newBlock(typeIndex, reqs.Size)
+		block.dedicated = true
+		block.live = 1
+		block.used = reqs.Size
+		pool.blocks = append(pool.blocks, block)
+		return &memoryAllocation{
+			pool:  pool,
+			block: block,
+			size:  reqs.Size,
+		}, nil
+	}
+	for _, block := range pool.blocks {
+		if block.dedicated {
+			continue
+		}
+		if offset, ok := a.place(block, strategy, reqs, kind); ok {
+			return &memoryAllocation{
+				pool:   pool,
+				block:  block,
+				offset: offset,
+				size:   reqs.Size,
+			}, nil
+		}
+	}
+	block := a.newBlock(typeIndex, memoryBlockSize)
+	if strategy == allocFreeList {
+		block.chunks = []memoryChunk{{
+			size: memoryBlockSize,
+		}}
+	}
+	pool.blocks = append(pool.blocks, block)
+	offset, _ := a.place(block, strategy, reqs, kind)
+	return &memoryAllocation{
+		pool:   pool,
+		block:  block,
+		offset: offset,
+		size:   reqs.Size,
+	}, nil
+}
+
+// memoryType returns the first memory type in typeBits having all of props.
+func (a *memoryAllocator) memoryType(typeBits uint32, props vk.MemoryPropertyFlagBits) (uint32, bool) {
+	for i := uint32(0); i < a.memProps.MemoryTypeCount; i++ {
+		flags := vk.MemoryPropertyFlagBits(a.memProps.MemoryTypes[i].PropertyFlags)
+		if typeBits&(1<<i) != 0 && flags&props == props {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+func (a *memoryAllocator) newBlock(typeIndex uint32, size vk.DeviceSize) *memoryBlock {
+	block := &memoryBlock{
+		size: size,
+	}
+	ret := vk.AllocateMemory(a.dev, &vk.MemoryAllocateInfo{
+		SType:           vk.StructureTypeMemoryAllocateInfo,
+		AllocationSize:  size,
+		MemoryTypeIndex: typeIndex,
+	}, nil, &block.mem)
+	orPanic(as.NewError(ret))
+
+	flags := a.memProps.MemoryTypes[typeIndex].PropertyFlags
+	if flags&vk.MemoryPropertyFlags(vk.MemoryPropertyHostVisibleBit) != 0 {
+		var pData unsafe.Pointer
+		ret = vk.MapMemory(a.dev, block.mem, 0, size, 0, &pData)
+		orPanic(as.NewError(ret))
+		const m = 0x7fffffff
+		block.data = (*[m]byte)(pData)[:size:size]
+	}
+	return block
+}
+
+// place finds room for reqs in block, returning its offset.
+func (a *memoryAllocator) place(block *memoryBlock, strategy allocStrategy,
+	reqs vk.MemoryRequirements, kind resourceKind) (vk.DeviceSize, bool) {
+
+	if strategy == allocLinear {
+		offset := alignSize(block.top, reqs.Alignment)
+		if block.live > 0 && block.last != kind {
+			offset = alignSize(offset, a.granularity)
+		}
+		if offset+reqs.Size > block.size {
+			return 0, false
+		}
+		block.top = offset + reqs.Size
+		block.last = kind
+		block.live++
+		block.used += reqs.Size
+		return offset, true
+	}
+
+	for i, c := range block.chunks {
+		if c.used || c.size < reqs.Size {
+			continue
+		}
+		offset := alignSize(c.offset, reqs.Alignment)
+		if i > 0 && a.conflicts(block.chunks[i-1], kind, c.offset-1, offset) {
+			offset = alignSize(offset, a.granularity)
+		}
+		end := offset + reqs.Size
+		if end > c.offset+c.size {
+			continue
+		}
+		if i+1 < len(block.chunks) && a.conflicts(block.chunks[i+1], kind, end-1, c.offset+c.size) {
+			continue
+		}
+
+		// split the chunk into the padding, the allocation and the rest
+		var chunks []memoryChunk
+		if offset > c.offset {
+			chunks = append(chunks, memoryChunk{
+				offset: c.offset,
+				size:   offset - c.offset,
+			})
+		}
+		chunks = append(chunks, memoryChunk{
+			offset: offset,
+			size:   reqs.Size,
+			used:   true,
+			kind:   kind,
+		})
+		if rest := c.offset + c.size - end; rest > 0 {
+			chunks = append(chunks, memoryChunk{
+				offset: end,
+				size:   rest,
+			})
+		}
+		tail := append(chunks, block.chunks[i+1:]...)
+		block.chunks = append(block.chunks[:i], tail...)
+		block.live++
+		block.used += reqs.Size
+		return offset, true
+	}
+	return 0, false
+}
+
+// conflicts reports whether n is a used chunk of another kind than kind
+// sharing a bufferImageGranularity page with the allocation next to it,
+// x and y being the bytes facing each other across their boundary.
+func (a *memoryAllocator) conflicts(n memoryChunk, kind resourceKind, x, y vk.DeviceSize) bool {
+	if !n.used || n.kind == kind {
+		return false
+	}
+	return x/a.granularity == y/a.granularity
+}
+
+// free returns the range of m to its block. Emptied blocks are released,
+// but for the last one of the pool, which is kept for reuse.
+func (m *memoryAllocation) free() {
+	block := m.block
+	block.live--
+	block.used -= m.size
+	switch {
+	case block.dedicated:
+	case m.pool.strategy == allocLinear:
+		if block.live == 0 {
+			block.top = 0
+		}
+	default:
+		i := sort.Search(len(block.chunks), func(i int) bool {
+			return block.chunks[i].offset >= m.offset
+		})
+		block.chunks[i].used = false
+		// merge with the free neighbours
+		if i+1 < len(block.chunks) && !block.chunks[i+1].used {
+			block.chunks[i].size += block.chunks[i+1].size
+			block.chunks = append(block.chunks[:i+1], block.chunks[i+2:]...)
+		}
+		if i > 0 && !block.chunks[i-1].used {
+			block.chunks[i-1].size += block.chunks[i].size
+			block.chunks = append(block.chunks[:i], block.chunks[i+1:]...)
+		}
+	}
+	if block.live > 0 || block.mem == nil {
+		return
+	}
+	if !block.dedicated && len(m.pool.blocks) == 1 {
+		return
+	}
+	blocks := m.pool.blocks
+	for i, b := range blocks {
+		if b == block {
+			m.pool.blocks = append(blocks[:i], blocks[i+1:]...)
+			break
+		}
+	}
+	m.pool.allocator.releaseBlock(block)
+}
+
+func (a *memoryAllocator) releaseBlock(block *memoryBlock) {
+	if block.data != nil {
+		vk.UnmapMemory(a.dev, block.mem)
+		block.data = nil
+	}
+	vk.FreeMemory(a.dev, block.mem, nil)
+	block.mem = nil
+}
+
+// destroy releases all blocks. Resources still bound to them must not
+// be used anymore, freeing their allocations afterwards is harmless.
+func (a *memoryAllocator) destroy() {
+	for _, pool := range a.pools {
+		for _, block := range pool.blocks {
+			a.releaseBlock(block)
+		}
+		pool.blocks = nil
+	}
+}
+
+// bindImage allocates the memory of image and binds it.
+func (a *memoryAllocator) bindImage(image vk.Image, tiling vk.ImageTiling,
+	props vk.MemoryPropertyFlagBits) *memoryAllocation {
+
+	var memReqs vk.MemoryRequirements
+	vk.GetImageMemoryRequirements(a.dev, image, &memReqs)
+	memReqs.Deref()
+
+	kind := resourceOptimal
+	if tiling == vk.ImageTilingLinear {
+		kind = resourceLinear
+	}
+	m, err := a.alloc(memReqs, props, kind, allocFreeList)
+	orPanic(err)
+	ret := vk.BindImageMemory(a.dev, image, m.block.mem, m.offset)
+	orPanic(as.NewError(ret))
+	return m
+}
+
+// bindBuffer allocates the memory of buffer with strategy and binds it.
+func (a *memoryAllocator) bindBuffer(buffer vk.Buffer, props vk.MemoryPropertyFlagBits,
+	strategy allocStrategy) *memoryAllocation {
+
+	var memReqs vk.MemoryRequirements
+	vk.GetBufferMemoryRequirements(a.dev, buffer, &memReqs)
+	memReqs.Deref()
+
+	m, err := a.alloc(memReqs, props, resourceLinear, strategy)
+	orPanic(err)
+	ret := vk.BindBufferMemory(a.dev, buffer, m.block.mem, m.offset)
+	orPanic(as.NewError(ret))
+	return m
+}
+
+// data returns the mapped memory of m, nil unless it is host-visible.
+func (m *memoryAllocation) data() []byte {
+	if m.block.data == nil {
+		return nil
+	}
+	return m.block.data[m.offset : m.offset+m.size : m.offset+m.size]
+}
+
+func alignSize(size, alignment vk.DeviceSize) vk.DeviceSize {
+	if alignment <= 1 {
+		return size
+	}
+	return (size + alignment - 1) / alignment * alignment
+}
+
+// createBuffer creates a buffer of size bytes for usage, bound to memory
+// with props allocated with strategy.
+func (s *SpinningCube) createBuffer(size int, usage vk.BufferUsageFlagBits,
+	props vk.MemoryPropertyFlagBits, strategy allocStrategy) (vk.Buffer, *memoryAllocation) {
+
+	dev := s.rc().Device()
+	var buffer vk.Buffer
+	ret := vk.CreateBuffer(dev, &vk.BufferCreateInfo{
+		SType:       vk.StructureTypeBufferCreateInfo,
+		Size:        vk.DeviceSize(size),
+		Usage:       vk.BufferUsageFlags(usage),
+		SharingMode: vk.SharingModeExclusive,
+	}, nil, &buffer)
+	orPanic(as.NewError(ret))
+	return buffer, s.memory.bindBuffer(buffer, props, strategy)
+}
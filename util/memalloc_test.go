@@ -0,0 +1,184 @@
+package util
+
+import (
+	"reflect"
+	"testing"
+
+	vk "github.com/vulkan-go/vulkan"
+)
+
+// testBlock returns a pool of strategy with a single block of size bytes,
+// which is not backed by device memory.
+func testBlock(strategy allocStrategy, granularity, size vk.DeviceSize) (*memoryPool, *memoryBlock) {
+	pool := &memoryPool{
+		memoryPoolKey: memoryPoolKey{strategy: strategy},
+		allocator:     &memoryAllocator{granularity: granularity},
+	}
+	block := &memoryBlock{size: size}
+	if strategy == allocFreeList {
+		block.chunks = []memoryChunk{{size: size}}
+	}
+	pool.blocks = []*memoryBlock{block}
+	return pool, block
+}
+
+func testPlace(t *testing.T, pool *memoryPool, block *memoryBlock,
+	size, alignment vk.DeviceSize, kind resourceKind, want vk.DeviceSize) *memoryAllocation {
+
+	t.Helper()
+	reqs := vk.MemoryRequirements{Size: size, Alignment: alignment}
+	offset, ok := pool.allocator.place(block, pool.strategy, reqs, kind)
+	if !ok {
+		t.Fatalf("%d bytes aligned to %d do not fit", size, alignment)
+	}
+	if offset != want {
+		t.Errorf("%d bytes aligned to %d placed at %d, want %d", size, alignment, offset, want)
+	}
+	return &memoryAllocation{
+		pool:   pool,
+		block:  block,
+		offset: offset,
+		size:   size,
+	}
+}
+
+func checkChunks(t *testing.T, block *memoryBlock, want []memoryChunk) {
+	t.Helper()
+	if !reflect.DeepEqual(block.chunks, want) {
+		t.Errorf("chunks are %v, want %v", block.chunks, want)
+	}
+}
+
+func TestMemoryAllocatorAlignment(t *testing.T) {
+	pool, block := testBlock(allocFreeList, 1, 1024)
+	testPlace(t, pool, block, 10, 1, resourceLinear, 0)
+	testPlace(t, pool, block, 16, 64, resourceLinear, 64)
+	// the padding before an aligned allocation is free for later ones
+	testPlace(t, pool, block, 50, 2, resourceLinear, 10)
+	checkChunks(t, block, []memoryChunk{
+		{offset: 0, size: 10, used: true},
+		{offset: 10, size: 50, used: true},
+		{offset: 60, size: 4},
+		{offset: 64, size: 16, used: true},
+		{offset: 80, size: 944},
+	})
+	if block.live != 3 || block.used != 76 {
+		t.Errorf("%d allocations of %d bytes, want 3 of 76", block.live, block.used)
+	}
+
+	reqs := vk.MemoryRequirements{Size: 1000, Alignment: 1}
+	if _, ok := pool.allocator.place(block, allocFreeList, reqs, resourceLinear); ok {
+		t.Error("1000 bytes placed in a block with 944 free")
+	}
+}
+
+func TestMemoryAllocatorCoalescing(t *testing.T) {
+	pool, block := testBlock(allocFreeList, 1, 1024)
+	a := testPlace(t, pool, block, 100, 1, resourceLinear, 0)
+	b := testPlace(t, pool, block, 100, 1, resourceLinear, 100)
+	c := testPlace(t, pool, block, 100, 1, resourceLinear, 200)
+
+	a.free()
+	c.free()
+	// c merges with the free rest of the block
+	checkChunks(t, block, []memoryChunk{
+		{offset: 0, size: 100},
+		{offset: 100, size: 100, used: true},
+		{offset: 200, size: 824},
+	})
+	// b merges with both neighbours
+	b.free()
+	checkChunks(t, block, []memoryChunk{{offset: 0, size: 1024}})
+	if block.live != 0 || block.used != 0 {
+		t.Errorf("%d allocations of %d bytes left", block.live, block.used)
+	}
+	testPlace(t, pool, block, 1024, 1, resourceLinear, 0)
+}
+
+func TestMemoryAllocatorLinearReset(t *testing.T) {
+	pool, block := testBlock(allocLinear, 1, 1024)
+	a := testPlace(t, pool, block, 100, 1, resourceLinear, 0)
+	b := testPlace(t, pool, block, 100, 64, resourceLinear, 128)
+
+	// freed space is not reused while the block has live allocations
+	a.free()
+	if block.top != 228 {
+		t.Errorf("top is %d after freeing one allocation, want 228", block.top)
+	}
+	testPlace(t, pool, block, 100, 1, resourceLinear, 228).free()
+	b.free()
+	if block.top != 0 || block.live != 0 || block.used != 0 {
+		t.Errorf("emptied block has top %d, %d allocations of %d bytes",
+			block.top, block.live, block.used)
+	}
+	testPlace(t, pool, block, 1024, 1, resourceLinear, 0)
+}
+
+func TestMemoryAllocatorGranularity(t *testing.T) {
+	// linear allocations following an optimal one start on the next page
+	pool, block := testBlock(allocLinear, 256, 1024)
+	testPlace(t, pool, block, 100, 16, resourceLinear, 0)
+	testPlace(t, pool, block, 100, 16, resourceLinear, 112)
+	testPlace(t, pool, block, 100, 16, resourceOptimal, 256)
+	testPlace(t, pool, block, 100, 16, resourceLinear, 512)
+
+	// so do free-list allocations after a neighbour of another kind
+	pool, block = testBlock(allocFreeList, 256, 1024)
+	testPlace(t, pool, block, 100, 16, resourceLinear, 0)
+	testPlace(t, pool, block, 100, 16, resourceOptimal, 256)
+	// the same kind may share the page
+	testPlace(t, pool, block, 50, 16, resourceOptimal, 368)
+	// the padding before it holds linear allocations ending on an earlier page
+	testPlace(t, pool, block, 100, 16, resourceLinear, 112)
+
+	pool, block = testBlock(allocFreeList, 256, 1024)
+	block.chunks = []memoryChunk{
+		{offset: 0, size: 300},
+		{offset: 300, size: 100, used: true, kind: resourceOptimal},
+		{offset: 400, size: 624},
+	}
+	block.live = 1
+	block.used = 100
+	// 280 bytes at 0 would end on the page of the optimal chunk at 300,
+	// past it they start on the next page
+	testPlace(t, pool, block, 280, 1, resourceLinear, 512)
+	// an optimal allocation may end on its page
+	testPlace(t, pool, block, 280, 1, resourceOptimal, 0)
+}
+
+func TestMemoryType(t *testing.T) {
+	a := &memoryAllocator{}
+	a.memProps.MemoryTypeCount = 3
+	a.memProps.MemoryTypes[0].PropertyFlags = vk.MemoryPropertyFlags(vk.MemoryPropertyDeviceLocalBit)
+	a.memProps.MemoryTypes[1].PropertyFlags = vk.MemoryPropertyFlags(vk.MemoryPropertyHostVisibleBit)
+	a.memProps.MemoryTypes[2].PropertyFlags = vk.MemoryPropertyFlags(
+		vk.MemoryPropertyHostVisibleBit | vk.MemoryPropertyHostCoherentBit)
+
+	hostCoherent := vk.MemoryPropertyHostVisibleBit | vk.MemoryPropertyHostCoherentBit
+	for _, tc := range []struct {
+		typeBits uint32
+		props    vk.MemoryPropertyFlagBits
+		want     uint32
+		ok       bool
+	}{
+		{0x7, vk.MemoryPropertyDeviceLocalBit, 0, true},
+		{0x7, hostCoherent, 2, true},
+		{0x7, vk.MemoryPropertyHostVisibleBit, 1, true},
+		{0x3, hostCoherent, 0, false},
+		{0x6, 0, 1, true},
+		// types past the count are never returned
+		{0x8, 0, 0, false},
+	} {
+		got, ok := a.memoryType(tc.typeBits, tc.props)
+		if got != tc.want || ok != tc.ok {
+			t.Errorf("memory type in %#x with %#x is %d, %v, want %d, %v",
+				tc.typeBits, tc.props, got, ok, tc.want, tc.ok)
+		}
+	}
+
+	// host-visible requests do not fall back to other memory types
+	reqs := vk.MemoryRequirements{Size: 16, Alignment: 1, MemoryTypeBits: 0x1}
+	if _, err := a.alloc(reqs, hostCoherent, resourceLinear, allocFreeList); err == nil {
+		t.Error("host-visible allocation from device-local memory")
+	}
+}
@@ -0,0 +1,190 @@
+package util
+
+// #include <stdlib.h>
+import "C"
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"unsafe"
+
+	vk "github.com/vulkan-go/vulkan"
+)
+
+// MemoryStats reports the device memory of a SpinningCube, the blocks its
+// resources are suballocated from, in total and by memory type and heap.
+type MemoryStats struct {
+	// Blocks is the number of device memory allocations,
+	// Allocations the number of resources bound to them.
+	Blocks      int `json:"blocks"`
+	Allocations int `json:"allocations"`
+	// Size is the size of the blocks in bytes, Used the part
+	// of it bound to resources.
+	Size uint64 `json:"size"`
+	Used uint64 `json:"used"`
+	// Budget reports whether the heaps come with the driver budget,
+	// which needs VK_EXT_memory_budget.
+	Budget bool `json:"budget"`
+
+	Types []MemoryTypeStats `json:"types"`
+	Heaps []MemoryHeapStats `json:"heaps"`
+}
+
+// MemoryTypeStats reports the blocks of a memory type.
+type MemoryTypeStats struct {
+	TypeIndex     uint32                 `json:"typeIndex"`
+	HeapIndex     uint32                 `json:"heapIndex"`
+	PropertyFlags vk.MemoryPropertyFlags `json:"propertyFlags"`
+	Blocks        int                    `json:"blocks"`
+	Allocations   int                    `json:"allocations"`
+	Size          uint64                 `json:"size"`
+	Used          uint64                 `json:"used"`
+}
+
+// MemoryHeapStats reports the blocks of the memory types of a heap.
+// HeapBudget is the memory the driver estimates the process can allocate
+// from the heap without degrading performance, HeapUsage what the process
+// has allocated from it, including memory not allocated by the SpinningCube.
+type MemoryHeapStats struct {
+	HeapIndex   uint32             `json:"heapIndex"`
+	Flags       vk.MemoryHeapFlags `json:"flags"`
+	HeapSize    uint64             `json:"heapSize"`
+	Blocks      int                `json:"blocks"`
+	Allocations int                `json:"allocations"`
+	Size        uint64             `json:"size"`
+	Used        uint64             `json:"used"`
+	HeapBudget  uint64             `json:"heapBudget,omitempty"`
+	HeapUsage   uint64             `json:"heapUsage,omitempty"`
+}
+
+// MemoryStats returns the statistics of the device memory allocator.
+func (s *SpinningCube) MemoryStats() MemoryStats {
+	var stats MemoryStats
+	if s.memory == nil {
+		return stats
+	}
+	a := s.memory
+	types := make(map[uint32]*MemoryTypeStats)
+	for key, pool := range a.pools {
+		t := types[key.typeIndex]
+		if t == nil {
+			memType := a.memProps.MemoryTypes[key.typeIndex]
+			t = &MemoryTypeStats{
+				TypeIndex:     key.typeIndex,
+				HeapIndex:     memType.HeapIndex,
+				PropertyFlags: memType.PropertyFlags,
+			}
+			types[key.typeIndex] = t
+		}
+		for _, block := range pool.blocks {
+			t.Blocks++
+			t.Allocations += block.live
+			t.Size += uint64(block.size)
+			t.Used += uint64(block.used)
+		}
+	}
+
+	stats.Heaps = make([]MemoryHeapStats, a.memProps.MemoryHeapCount)
+	for i := range stats.Heaps {
+		heap := a.memProps.MemoryHeaps[i]
+		stats.Heaps[i] = MemoryHeapStats{
+			HeapIndex: uint32(i),
+			Flags:     heap.Flags,
+			HeapSize:  uint64(heap.Size),
+		}
+	}
+	for _, t := range types {
+		stats.Blocks += t.Blocks
+		stats.Allocations += t.Allocations
+		stats.Size += t.Size
+		stats.Used += t.Used
+		stats.Types = append(stats.Types, *t)
+
+		heap := &stats.Heaps[t.HeapIndex]
+		heap.Blocks += t.Blocks
+		heap.Allocations += t.Allocations
+		heap.Size += t.Size
+		heap.Used += t.Used
+	}
+	sort.Slice(stats.Types, func(i, j int) bool {
+		return stats.Types[i].TypeIndex < stats.Types[j].TypeIndex
+	})
+
+	if budget, ok := s.rc().MemoryBudget(); ok {
+		for i := range stats.Heaps {
+			stats.Heaps[i].HeapBudget = uint64(budget.heapBudget[i])
+			stats.Heaps[i].HeapUsage = uint64(budget.heapUsage[i])
+		}
+		stats.Budget = true
+	}
+	return stats
+}
+
+// memoryBudget is VkPhysicalDeviceMemoryBudgetPropertiesEXT, which is
+// newer than the headers of the binding.
+type memoryBudget struct {
+	sType      vk.StructureType
+	pNext      unsafe.Pointer
+	heapBudget [vk.MaxMemoryHeaps]vk.DeviceSize
+	heapUsage  [vk.MaxMemoryHeaps]vk.DeviceSize
+}
+
+const structureTypePhysicalDeviceMemoryBudgetProperties vk.StructureType = 1000237000
+
+// getMemoryProperties2 returns vkGetPhysicalDeviceMemoryProperties2
+// of instance, nil when it has none.
+func getMemoryProperties2(instance vk.Instance) unsafe.Pointer {
+	return instanceProc(instance,
+		"vkGetPhysicalDeviceMemoryProperties2", "vkGetPhysicalDeviceMemoryProperties2KHR")
+}
+
+// queryMemoryBudget returns the current budget and usage of the heaps of
+// gpu, as reported by VK_EXT_memory_budget. It reports false when instance
+// has no vkGetPhysicalDeviceMemoryProperties2.
+func queryMemoryBudget(instance vk.Instance, gpu vk.PhysicalDevice) (memoryBudget, bool) {
+	getProps2 := getMemoryProperties2(instance)
+	if getProps2 == nil {
+		return memoryBudget{}, false
+	}
+	// the driver writes the budget through pNext, it must be in C memory
+	budget := (*memoryBudget)(C.calloc(1, C.size_t(unsafe.Sizeof(memoryBudget{}))))
+	defer C.free(unsafe.Pointer(budget))
+	budget.sType = structureTypePhysicalDeviceMemoryBudgetProperties
+
+	props := vk.PhysicalDeviceMemoryProperties2{
+		SType: vk.StructureTypePhysicalDeviceMemoryProperties2,
+		PNext: unsafe.Pointer(budget),
+	}
+	ref, _ := props.PassRef()
+	defer props.Free()
+	callPhysicalDeviceQuery(getProps2, gpu, unsafe.Pointer(ref))
+	return *budget, true
+}
+
+// WriteJSON writes st to w as indented JSON.
+func (st MemoryStats) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(st)
+}
+
+func (st MemoryStats) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d allocations in %d blocks, %d of %d KiB used",
+		st.Allocations, st.Blocks, st.Used>>10, st.Size>>10)
+	for _, h := range st.Heaps {
+		fmt.Fprintf(&b, "\n  heap %d (%d MiB): %d allocations in %d blocks, %d of %d KiB used",
+			h.HeapIndex, h.HeapSize>>20, h.Allocations, h.Blocks, h.Used>>10, h.Size>>10)
+		if st.Budget {
+			fmt.Fprintf(&b, ", process usage %d of %d MiB budget", h.HeapUsage>>20, h.HeapBudget>>20)
+		}
+	}
+	for _, t := range st.Types {
+		fmt.Fprintf(&b, "\n  type %d (heap %d, flags %#x): %d allocations in %d blocks, %d of %d KiB used",
+			t.TypeIndex, t.HeapIndex, t.PropertyFlags, t.Allocations, t.Blocks, t.Used>>10, t.Size>>10)
+	}
+	return b.String()
+}
@@ -0,0 +1,353 @@
+package util
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"unsafe"
+
+	as "github.com/vulkan-go/asche"
+	vk "github.com/vulkan-go/vulkan"
+	lin "github.com/xlab/linmath"
+)
+
+// Vertex is the vertex layout consumed by the pipeline,
+// interleaved in a single vertex buffer binding.
+type Vertex struct {
+	Position [3]float32
+	Normal   [3]float32
+	UV       [2]float32
+	Color    [4]float32
+}
+
+const vertexSize = int(unsafe.Sizeof(Vertex{}))
+
+var vertexBindings = []vk.VertexInputBindingDescription{{
+	Binding:   0,
+	Stride:    uint32(vertexSize),
+	InputRate: vk.VertexInputRateVertex,
+}}
+
+var vertexAttributes = []vk.VertexInputAttributeDescription{{
+	Location: 0,
+	Binding:  0,
+	Format:   vk.FormatR32g32b32Sfloat,
+	Offset:   uint32(unsafe.Offsetof(Vertex{}.Position)),
+}, {
+	Location: 1,
+	Binding:  0,
+	Format:   vk.FormatR32g32b32Sfloat,
+	Offset:   uint32(unsafe.Offsetof(Vertex{}.Normal)),
+}, {
+	Location: 2,
+	Binding:  0,
+	Format:   vk.FormatR32g32Sfloat,
+	Offset:   uint32(unsafe.Offsetof(Vertex{}.UV)),
+}, {
+	Location: 3,
+	Binding:  0,
+	Format:   vk.FormatR32g32b32a32Sfloat,
+	Offset:   uint32(unsafe.Offsetof(Vertex{}.Color)),
+}}
+
+// MeshData is indexed triangle list geometry on the host.
+type MeshData struct {
+	Vertices []Vertex
+	Indices  []uint32
+
+	// Groups split Indices into ranges drawn with their own material.
+	// Without groups all indices are drawn with the default texture.
+	Groups []MeshGroup
+
+	// Transform places the mesh in the scene, pushed as the model matrix
+	// of its draws. Nil is the identity.
+	Transform *lin.Mat4x4
+}
+
+// MeshGroup is a range of MeshData.Indices sharing one material.
+type MeshGroup struct {
+	Material   *Material
+	FirstIndex uint32
+	IndexCount uint32
+}
+
+// Material describes the surface of a mesh group. The loaders apply the
+// diffuse color through the vertex colors, which modulate the diffuse map.
+type Material struct {
+	Name    string
+	Diffuse [4]float32
+	// DiffuseMap is the diffuse texture, groups without
+	// one are drawn with the default texture.
+	DiffuseMap TextureRef
+
+	// Metallic-roughness parameters of glTF materials. They are kept
+	// for the application, the default pipeline only uses the diffuse map.
+	Metallic             float32
+	Roughness            float32
+	MetallicRoughnessMap TextureRef
+	NormalMap            TextureRef
+	OcclusionMap         TextureRef
+	Emissive             [3]float32
+	EmissiveMap          TextureRef
+	AlphaMode            string
+	AlphaCutoff          float32
+	DoubleSided          bool
+}
+
+// TextureRef names a texture image, a file or an image embedded
+// in a model file. The zero TextureRef refers to no texture.
+type TextureRef struct {
+	// Name is the file path, or a unique name for embedded images.
+	Name string
+	// Data is the encoded image for embedded images.
+	Data []byte
+	// Sampler describes how the texture is sampled,
+	// nil selects the default of the TextureManager.
+	Sampler *SamplerDesc
+	// Linear marks data textures, such as normal or roughness maps, whose
+	// texels are sampled as they are. Color textures are decoded from sRGB,
+	// except for KTX2 and DDS files stored in a UNORM format.
+	Linear bool
+}
+
+func (r TextureRef) IsZero() bool {
+	return r.Name == "" && r.Data == nil
+}
+
+func (r TextureRef) load() ([]byte, error) {
+	if r.Data != nil {
+		return r.Data, nil
+	}
+	return ioutil.ReadFile(r.Name)
+}
+
+func (d *MeshData) vertexData() []byte {
+	const m = 0x7fffffff
+	return (*[m]byte)(unsafe.Pointer(&d.Vertices[0]))[:len(d.Vertices)*vertexSize]
+}
+
+func (d *MeshData) indexData() []byte {
+	const m = 0x7fffffff
+	return (*[m]byte)(unsafe.Pointer(&d.Indices[0]))[:len(d.Indices)*4]
+}
+
+// Mesh is MeshData uploaded to device-local vertex and index buffers,
+// holding a reference to the texture of each of its groups.
+type Mesh struct {
+	vertexBuffer vk.Buffer
+	vertexMem    *memoryAllocation
+	indexBuffer  vk.Buffer
+	indexMem     *memoryAllocation
+
+	textures *TextureManager
+	groups   []meshGroup
+	push     PushConstants
+
+	// center and radius bound the vertices of the mesh, before its transform
+	center lin.Vec3
+	radius float32
+}
+
+type meshGroup struct {
+	firstIndex uint32
+	indexCount uint32
+	tex        *Texture
+}
+
+func (m *Mesh) draw(cmd vk.CommandBuffer, layout vk.PipelineLayout) {
+	if len(m.groups) == 0 {
+		return
+	}
+	vk.CmdBindVertexBuffers(cmd, 0, 1, []vk.Buffer{m.vertexBuffer}, []vk.DeviceSize{0})
+	vk.CmdBindIndexBuffer(cmd, m.indexBuffer, 0, vk.IndexTypeUint32)
+	m.push.Push(cmd, layout)
+	for _, g := range m.groups {
+		if g.tex.bindless {
+			// the texture ID reaches the shaders as gl_InstanceIndex
+			vk.CmdDrawIndexed(cmd, g.indexCount, 1, g.firstIndex, 0, g.tex.index)
+			continue
+		}
+		vk.CmdBindDescriptorSets(cmd, vk.PipelineBindPointGraphics, layout,
+			1, 1, []vk.DescriptorSet{g.tex.set}, 0, nil)
+		vk.CmdDrawIndexed(cmd, g.indexCount, 1, g.firstIndex, 0, 0)
+	}
+}
+
+func (m *Mesh) Destroy(dev vk.Device) {
+	if m.textures == nil {
+		return
+	}
+	for _, g := range m.groups {
+		m.textures.Release(g.tex)
+	}
+	m.groups = nil
+	vk.DestroyBuffer(dev, m.vertexBuffer, nil)
+	m.vertexMem.free()
+	vk.DestroyBuffer(dev, m.indexBuffer, nil)
+	m.indexMem.free()
+}
+
+// AddMesh adds data to the scene. Meshes added before the context is prepared
+// are uploaded in VulkanContextPrepare, later ones are uploaded right away and
+// the command buffers are recorded again.
+func (s *SpinningCube) AddMesh(data *MeshData) (err error) {
+	defer checkErr(&err)
+
+	s.meshData = append(s.meshData, data)
+	if !s.prepared {
+		return nil
+	}
+	ret := vk.DeviceWaitIdle(s.rc().Device())
+	orPanic(as.NewError(ret))
+
+	var m *Mesh
+	s.upload(func() {
+		m = s.newMesh(data)
+	})
+	s.meshes = append(s.meshes, m)
+	s.buildCommandBuffers()
+	return nil
+}
+
+// SetMeshTransform sets the transform of the i-th mesh of the scene,
+// in the order the meshes were added, the built-in cube being the first.
+func (s *SpinningCube) SetMeshTransform(i int, transform lin.Mat4x4) (err error) {
+	defer checkErr(&err)
+
+	if i < 0 || i >= len(s.meshData) {
+		return fmt.Errorf("mesh: no mesh %d, %d meshes", i, len(s.meshData))
+	}
+	s.meshData[i].Transform = &transform
+	if !s.prepared {
+		return nil
+	}
+	// push constants are recorded, the frames in flight keep the old transform
+	s.meshes[i].push.Model.Dup(&transform)
+	s.recordAgain()
+	return nil
+}
+
+// RemoveMesh removes the i-th mesh from the scene, the meshes after it move
+// down by one. Its buffers are destroyed once no frame in flight draws it.
+func (s *SpinningCube) RemoveMesh(i int) (err error) {
+	defer checkErr(&err)
+
+	if i < 0 || i >= len(s.meshData) {
+		return fmt.Errorf("mesh: no mesh %d, %d meshes", i, len(s.meshData))
+	}
+	s.meshData = append(s.meshData[:i], s.meshData[i+1:]...)
+	if !s.prepared {
+		return nil
+	}
+	m := s.meshes[i]
+	s.meshes = append(s.meshes[:i], s.meshes[i+1:]...)
+	dev := s.rc().Device()
+	s.deferDestroy(func() {
+		m.Destroy(dev)
+	})
+	s.recordAgain()
+	return nil
+}
+
+// LoadModel adds the model file at path to the scene, picking the loader
+// by the file extension: .obj, .gltf or .glb.
+func (s *SpinningCube) LoadModel(path string) error {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".obj":
+		return s.LoadOBJ(path)
+	case ".gltf", ".glb":
+		return s.LoadGLTF(path)
+	}
+	return fmt.Errorf("mesh: unknown model format %q", path)
+}
+
+func (s *SpinningCube) prepareMeshes() {
+	s.meshes = make([]*Mesh, 0, len(s.meshData))
+	for _, data := range s.meshData {
+		s.meshes = append(s.meshes, s.newMesh(data))
+	}
+}
+
+func (s *SpinningCube) newMesh(data *MeshData) *Mesh {
+	m := &Mesh{}
+	if len(data.Vertices) == 0 || len(data.Indices) == 0 {
+		return m
+	}
+	groups := data.Groups
+	if len(groups) == 0 {
+		groups = []MeshGroup{{
+			IndexCount: uint32(len(data.Indices)),
+		}}
+	}
+	for _, g := range groups {
+		if int(g.FirstIndex+g.IndexCount) > len(data.Indices) {
+			orPanic(fmt.Errorf("mesh: group indices [%d:%d] out of range, %d indices",
+				g.FirstIndex, g.FirstIndex+g.IndexCount, len(data.Indices)))
+		}
+	}
+
+	dev := s.rc().Device()
+	m.textures = s.textures
+	m.push.Model.Identity()
+	if data.Transform != nil {
+		m.push.Model.Dup(data.Transform)
+	}
+	m.center, m.radius = boundingSphere(data.Vertices)
+	m.vertexBuffer, m.vertexMem = s.createDeviceLocalBuffer(data.vertexData(),
+		vk.BufferUsageVertexBufferBit)
+	m.indexBuffer, m.indexMem = s.createDeviceLocalBuffer(data.indexData(),
+		vk.BufferUsageIndexBufferBit)
+
+	for _, g := range groups {
+		var tex *Texture
+		var err error
+		if g.Material != nil && !g.Material.DiffuseMap.IsZero() {
+			tex, err = s.textures.Acquire(g.Material.DiffuseMap)
+		} else {
+			tex, err = s.textures.Get(DefaultTexture)
+		}
+		orPanic(err, func() {
+			m.Destroy(dev)
+		})
+		m.groups = append(m.groups, meshGroup{
+			firstIndex: g.FirstIndex,
+			indexCount: g.IndexCount,
+			tex:        tex,
+		})
+	}
+	return m
+}
+
+// createDeviceLocalBuffer creates a device-local buffer for usage and records
+// the copy of data into it, through the staging buffers of the upload batch, into the
+// setup command buffer.
+func (s *SpinningCube) createDeviceLocalBuffer(data []byte,
+	usage vk.BufferUsageFlagBits) (vk.Buffer, *memoryAllocation) {
+
+	staging, offset := s.stage(data)
+	buffer, mem := s.createBuffer(len(data), usage|vk.BufferUsageTransferDstBit,
+		vk.MemoryPropertyDeviceLocalBit, allocFreeList)
+
+	cmd := s.setupCmd()
+	vk.CmdCopyBuffer(cmd, staging, buffer, 1, []vk.BufferCopy{{
+		SrcOffset: offset,
+		Size:      vk.DeviceSize(len(data)),
+	}})
+	// make the copy visible to the vertex input stage of later submissions
+	vk.CmdPipelineBarrier(cmd,
+		vk.PipelineStageFlags(vk.PipelineStageTransferBit),
+		vk.PipelineStageFlags(vk.PipelineStageVertexInputBit),
+		0, 0, nil, 1, []vk.BufferMemoryBarrier{{
+			SType:         vk.StructureTypeBufferMemoryBarrier,
+			SrcAccessMask: vk.AccessFlags(vk.AccessTransferWriteBit),
+			DstAccessMask: vk.AccessFlags(vk.AccessVertexAttributeReadBit |
+				vk.AccessIndexReadBit),
+			SrcQueueFamilyIndex: vk.QueueFamilyIgnored,
+			DstQueueFamilyIndex: vk.QueueFamilyIgnored,
+			Buffer:              buffer,
+			Size:                vk.DeviceSize(len(data)),
+		}}, 0, nil)
+
+	return buffer, mem
+}
@@ -0,0 +1,271 @@
+package util
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+
+	vk "github.com/vulkan-go/vulkan"
+)
+
+// mipLevels returns the number of levels of a full mip chain
+// for a width x height image.
+func mipLevels(width, height int) uint32 {
+	levels := uint32(1)
+	for width > 1 || height > 1 {
+		width >>= 1
+		height >>= 1
+		levels++
+	}
+	return levels
+}
+
+// mipSize returns the size of the next mip level along an axis of size n.
+func mipSize(n int) int {
+	if n > 1 {
+		return n >> 1
+	}
+	return 1
+}
+
+// mipChain appends the missing levels of the mip chain of img, each
+// a box filtered half-size copy of the one before it. This is the CPU
+// fallback for formats the device can't blit.
+func mipChain(img *TextureImage) {
+	levels := int(mipLevels(img.Width, img.Height))
+	w, h := img.Width, img.Height
+	for i := 1; i < len(img.Levels); i++ {
+		w, h = mipSize(w), mipSize(h)
+	}
+	for len(img.Levels) < levels {
+		texels := decodeTexels(img.Format, img.Levels[len(img.Levels)-1])
+		texels = boxFilter(texels, w, h)
+		w, h = mipSize(w), mipSize(h)
+		img.Levels = append(img.Levels, encodeTexels(img.Format, texels))
+	}
+}
+
+// boxFilter averages the 2x2 blocks of the w x h RGBA texels into the
+// texels of the next mip level. Odd rows and columns are folded into
+// the last block.
+func boxFilter(src []float32, w, h int) []float32 {
+	dw, dh := mipSize(w), mipSize(h)
+	dst := make([]float32, 4*dw*dh)
+	for y := 0; y < dh; y++ {
+		y0, y1 := 2*y, 2*y+2
+		if y == dh-1 || y1 > h {
+			y1 = h
+		}
+		for x := 0; x < dw; x++ {
+			x0, x1 := 2*x, 2*x+2
+			if x == dw-1 || x1 > w {
+				x1 = w
+			}
+			var sum [4]float32
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					p := src[4*(sy*w+sx):]
+					sum[0] += p[0]
+					sum[1] += p[1]
+					sum[2] += p[2]
+					sum[3] += p[3]
+				}
+			}
+			n := float32((y1 - y0) * (x1 - x0))
+			p := dst[4*(y*dw+x):]
+			for c := range sum {
+				p[c] = sum[c] / n
+			}
+		}
+	}
+	return dst
+}
+
+// decodeTexels converts texels of format into linear RGBA values.
+func decodeTexels(format vk.Format, b []byte) []float32 {
+	var f []float32
+	switch format {
+	case vk.FormatR8g8b8a8Unorm:
+		f = make([]float32, len(b))
+		for i, v := range b {
+			f[i] = float32(v) / 255
+		}
+	case vk.FormatR8g8b8a8Snorm:
+		f = make([]float32, len(b))
+		for i, v := range b {
+			f[i] = float32(math.Max(float64(int8(v))/127, -1))
+		}
+	case vk.FormatR8g8b8a8Srgb:
+		f = make([]float32, len(b))
+		for i, v := range b {
+			if i%4 == 3 {
+				f[i] = float32(v) / 255
+			} else {
+				f[i] = srgbToLinear(float32(v) / 255)
+			}
+		}
+	case vk.FormatR16g16b16a16Sfloat:
+		f = make([]float32, len(b)/2)
+		for i := range f {
+			f[i] = float32From16(uint16(b[2*i]) | uint16(b[2*i+1])<<8)
+		}
+	case vk.FormatR32g32b32a32Sfloat:
+		f = make([]float32, len(b)/4)
+		for i := range f {
+			f[i] = math.Float32frombits(binary.LittleEndian.Uint32(b[4*i:]))
+		}
+	default:
+		orPanic(fmt.Errorf("texture: can't filter format %d", format))
+	}
+	return f
+}
+
+// encodeTexels converts linear RGBA values into texels of format.
+func encodeTexels(format vk.Format, f []float32) []byte {
+	var b []byte
+	switch format {
+	case vk.FormatR8g8b8a8Unorm:
+		b = make([]byte, len(f))
+		for i, v := range f {
+			b[i] = unorm8(v)
+		}
+	case vk.FormatR8g8b8a8Snorm:
+		b = make([]byte, len(f))
+		for i, v := range f {
+			b[i] = byte(int8(math.Floor(math.Max(-1, math.Min(1, float64(v)))*127 + 0.5)))
+		}
+	case vk.FormatR8g8b8a8Srgb:
+		b = make([]byte, len(f))
+		for i, v := range f {
+			if i%4 == 3 {
+				b[i] = unorm8(v)
+			} else {
+				b[i] = unorm8(linearToSRGB(v))
+			}
+		}
+	case vk.FormatR16g16b16a16Sfloat:
+		b = make([]byte, 2*len(f))
+		for i, v := range f {
+			h := float16(v)
+			b[2*i], b[2*i+1] = byte(h), byte(h>>8)
+		}
+	case vk.FormatR32g32b32a32Sfloat:
+		b = make([]byte, 4*len(f))
+		for i, v := range f {
+			binary.LittleEndian.PutUint32(b[4*i:], math.Float32bits(v))
+		}
+	default:
+		orPanic(fmt.Errorf("texture: can't filter format %d", format))
+	}
+	return b
+}
+
+func unorm8(v float32) byte {
+	if v <= 0 {
+		return 0
+	}
+	if v >= 1 {
+		return 255
+	}
+	return byte(v*255 + 0.5)
+}
+
+func srgbToLinear(v float32) float32 {
+	if v <= 0.04045 {
+		return v / 12.92
+	}
+	return float32(math.Pow(float64(v+0.055)/1.055, 2.4))
+}
+
+func linearToSRGB(v float32) float32 {
+	if v <= 0.0031308 {
+		return v * 12.92
+	}
+	return float32(1.055*math.Pow(float64(v), 1/2.4) - 0.055)
+}
+
+// canBlitMipmaps reports whether the mip chain of optimal tiling images of
+// format can be generated on the device with linear filtered blits.
+func (s *SpinningCube) canBlitMipmaps(format vk.Format) bool {
+	props := s.formatProperties(format)
+	required := vk.FormatFeatureFlags(vk.FormatFeatureBlitSrcBit |
+		vk.FormatFeatureBlitDstBit | vk.FormatFeatureSampledImageFilterLinearBit)
+	return props.OptimalTilingFeatures&required == required
+}
+
+// generateMipmaps records the blits filling the levels of dst after base
+// from the width x height level base, in each of the array layers of dst.
+// The levels from base on must be in vk.ImageLayoutTransferDstOptimal,
+// and are left in layout.
+func (s *SpinningCube) generateMipmaps(dst vk.Image, width, height int32,
+	base, levels, layers uint32, layout vk.ImageLayout) {
+
+	cmd := s.setupCmd()
+	for i := base + 1; i < levels; i++ {
+		imageBarrier(cmd, dst, i-1, 1, layers,
+			vk.ImageLayoutTransferDstOptimal, vk.ImageLayoutTransferSrcOptimal,
+			vk.AccessTransferWriteBit, vk.AccessTransferReadBit,
+			vk.PipelineStageTransferBit, vk.PipelineStageTransferBit)
+
+		w, h := width>>1, height>>1
+		if w == 0 {
+			w = 1
+		}
+		if h == 0 {
+			h = 1
+		}
+		vk.CmdBlitImage(cmd, dst, vk.ImageLayoutTransferSrcOptimal,
+			dst, vk.ImageLayoutTransferDstOptimal, 1, []vk.ImageBlit{{
+				SrcSubresource: vk.ImageSubresourceLayers{
+					AspectMask: vk.ImageAspectFlags(vk.ImageAspectColorBit),
+					MipLevel:   i - 1,
+					LayerCount: layers,
+				},
+				SrcOffsets: [2]vk.Offset3D{{}, {X: width, Y: height, Z: 1}},
+				DstSubresource: vk.ImageSubresourceLayers{
+					AspectMask: vk.ImageAspectFlags(vk.ImageAspectColorBit),
+					MipLevel:   i,
+					LayerCount: layers,
+				},
+				DstOffsets: [2]vk.Offset3D{{}, {X: w, Y: h, Z: 1}},
+			}}, vk.FilterLinear)
+
+		imageBarrier(cmd, dst, i-1, 1, layers,
+			vk.ImageLayoutTransferSrcOptimal, layout,
+			vk.AccessTransferReadBit, vk.AccessShaderReadBit,
+			vk.PipelineStageTransferBit, vk.PipelineStageFragmentShaderBit)
+		width, height = w, h
+	}
+	imageBarrier(cmd, dst, levels-1, 1, layers,
+		vk.ImageLayoutTransferDstOptimal, layout,
+		vk.AccessTransferWriteBit, vk.AccessShaderReadBit,
+		vk.PipelineStageTransferBit, vk.PipelineStageFragmentShaderBit)
+}
+
+// imageBarrier records the transition of levelCount mip levels of the color
+// image from baseLevel on from oldLayout to newLayout, in its first
+// layerCount array layers.
+func imageBarrier(cmd vk.CommandBuffer, img vk.Image, baseLevel, levelCount, layerCount uint32,
+	oldLayout, newLayout vk.ImageLayout,
+	srcAccess, dstAccess vk.AccessFlagBits,
+	srcStages, dstStages vk.PipelineStageFlagBits) {
+
+	vk.CmdPipelineBarrier(cmd,
+		vk.PipelineStageFlags(srcStages), vk.PipelineStageFlags(dstStages),
+		0, 0, nil, 0, nil, 1, []vk.ImageMemoryBarrier{{
+			SType:               vk.StructureTypeImageMemoryBarrier,
+			SrcAccessMask:       vk.AccessFlags(srcAccess),
+			DstAccessMask:       vk.AccessFlags(dstAccess),
+			OldLayout:           oldLayout,
+			NewLayout:           newLayout,
+			SrcQueueFamilyIndex: vk.QueueFamilyIgnored,
+			DstQueueFamilyIndex: vk.QueueFamilyIgnored,
+			SubresourceRange: vk.ImageSubresourceRange{
+				AspectMask:   vk.ImageAspectFlags(vk.ImageAspectColorBit),
+				BaseMipLevel: baseLevel,
+				LevelCount:   levelCount,
+				LayerCount:   layerCount,
+			},
+			Image: img,
+		}})
+}
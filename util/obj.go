@@ -0,0 +1,400 @@
+package util
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// LoadOBJ reads a Wavefront OBJ file and the MTL libraries it references.
+// Polygons are triangulated as fans, and faces are grouped by the material
+// selected with usemtl. The material diffuse color is written into the vertex
+// colors, and map_Kd paths are resolved relative to the MTL file. Vertices
+// without a normal get the average normal of the faces sharing them.
+func LoadOBJ(path string) (*MeshData, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ParseOBJ(f, path)
+}
+
+// LoadOBJ loads the OBJ file at path and adds it to the scene.
+func (s *SpinningCube) LoadOBJ(path string) error {
+	data, err := LoadOBJ(path)
+	if err != nil {
+		return err
+	}
+	return s.AddMesh(data)
+}
+
+// ParseOBJ parses OBJ data from r. path names the file in error messages,
+// and mtllib references are resolved relative to it.
+func ParseOBJ(r io.Reader, path string) (*MeshData, error) {
+	p := &objParser{
+		path:      path,
+		dir:       filepath.Dir(path),
+		materials: make(map[string]*Material),
+		vertexIdx: make(map[objVertex]uint32),
+		data:      &MeshData{},
+	}
+	if err := p.parse(r); err != nil {
+		return nil, err
+	}
+	p.closeGroup()
+	p.smoothNormals()
+	return p.data, nil
+}
+
+// objVertex is a face corner, as 0-based position, texcoord
+// and normal indices with -1 for absent ones.
+type objVertex struct {
+	v, vt, vn int
+}
+
+type objParser struct {
+	path string
+	dir  string
+	line int
+
+	positions [][3]float32
+	texcoords [][2]float32
+	normals   [][3]float32
+
+	materials map[string]*Material
+	material  *Material
+
+	vertexIdx map[objVertex]uint32
+	corners   []objVertex
+	data      *MeshData
+	group     MeshGroup
+}
+
+func (p *objParser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("obj: %s:%d: %s", p.path, p.line, fmt.Sprintf(format, args...))
+}
+
+func (p *objParser) parse(r io.Reader) error {
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		p.line++
+		fields := strings.Fields(stripComment(sc.Text()))
+		if len(fields) == 0 {
+			continue
+		}
+		args := fields[1:]
+		switch fields[0] {
+		case "v":
+			v, err := p.floats(args, 3)
+			if err != nil {
+				return err
+			}
+			p.positions = append(p.positions, [3]float32{v[0], v[1], v[2]})
+		case "vt":
+			v, err := p.floats(args, 1)
+			if err != nil {
+				return err
+			}
+			var uv [2]float32
+			copy(uv[:], v)
+			// OBJ texture coordinates start at the bottom left
+			uv[1] = 1 - uv[1]
+			p.texcoords = append(p.texcoords, uv)
+		case "vn":
+			v, err := p.floats(args, 3)
+			if err != nil {
+				return err
+			}
+			p.normals = append(p.normals, [3]float32{v[0], v[1], v[2]})
+		case "f":
+			if err := p.face(args); err != nil {
+				return err
+			}
+		case "usemtl":
+			if len(args) == 0 {
+				return p.errorf("usemtl without a name")
+			}
+			name := strings.Join(args, " ")
+			m, ok := p.materials[name]
+			if !ok {
+				log.Printf("obj: %s:%d: unknown material %q", p.path, p.line, name)
+				m = &Material{Name: name, Diffuse: [4]float32{1, 1, 1, 1}}
+				p.materials[name] = m
+			}
+			if m != p.material {
+				p.closeGroup()
+				p.material = m
+			}
+		case "mtllib":
+			for _, lib := range args {
+				if err := p.loadMTL(filepath.Join(p.dir, lib)); err != nil {
+					return err
+				}
+			}
+		}
+		// o, g, s and the free-form geometry statements are ignored,
+		// faces are only grouped by material.
+	}
+	return sc.Err()
+}
+
+func (p *objParser) floats(args []string, min int) ([]float32, error) {
+	if len(args) < min {
+		return nil, p.errorf("expected %d values, got %d", min, len(args))
+	}
+	v := make([]float32, len(args))
+	for i, a := range args {
+		f, err := strconv.ParseFloat(a, 32)
+		if err != nil {
+			return nil, p.errorf("%v", err)
+		}
+		v[i] = float32(f)
+	}
+	return v, nil
+}
+
+func (p *objParser) face(args []string) error {
+	if len(args) < 3 {
+		return p.errorf("face with %d vertices", len(args))
+	}
+	p.corners = p.corners[:0]
+	for _, a := range args {
+		c, err := p.corner(a)
+		if err != nil {
+			return err
+		}
+		p.corners = append(p.corners, c)
+	}
+	for i := 1; i+1 < len(p.corners); i++ {
+		p.data.Indices = append(p.data.Indices,
+			p.vertex(p.corners[0]), p.vertex(p.corners[i]), p.vertex(p.corners[i+1]))
+	}
+	return nil
+}
+
+// corner parses a v, v/vt, v//vn or v/vt/vn face corner.
+func (p *objParser) corner(a string) (objVertex, error) {
+	c := objVertex{v: -1, vt: -1, vn: -1}
+	parts := strings.Split(a, "/")
+	if len(parts) > 3 {
+		return c, p.errorf("bad face vertex %q", a)
+	}
+	var err error
+	if c.v, err = p.index(parts[0], len(p.positions)); err != nil {
+		return c, err
+	}
+	if c.v < 0 {
+		return c, p.errorf("face vertex %q without a position", a)
+	}
+	if len(parts) > 1 {
+		if c.vt, err = p.index(parts[1], len(p.texcoords)); err != nil {
+			return c, err
+		}
+	}
+	if len(parts) > 2 {
+		if c.vn, err = p.index(parts[2], len(p.normals)); err != nil {
+			return c, err
+		}
+	}
+	return c, nil
+}
+
+// index resolves a 1-based or negative relative OBJ index into
+// a list of n elements, an empty index yields -1.
+func (p *objParser) index(a string, n int) (int, error) {
+	if a == "" {
+		return -1, nil
+	}
+	i, err := strconv.Atoi(a)
+	if err != nil {
+		return 0, p.errorf("bad index %q", a)
+	}
+	if i < 0 {
+		i += n
+	} else {
+		i--
+	}
+	if i < 0 || i >= n {
+		return 0, p.errorf("index %s out of range", a)
+	}
+	return i, nil
+}
+
+func (p *objParser) vertex(c objVertex) uint32 {
+	if idx, ok := p.vertexIdx[c]; ok {
+		return idx
+	}
+	v := Vertex{
+		Position: p.positions[c.v],
+		Color:    [4]float32{1, 1, 1, 1},
+	}
+	if c.vt >= 0 {
+		v.UV = p.texcoords[c.vt]
+	}
+	if c.vn >= 0 {
+		v.Normal = p.normals[c.vn]
+	}
+	if p.material != nil {
+		v.Color = p.material.Diffuse
+	}
+	idx := uint32(len(p.data.Vertices))
+	p.data.Vertices = append(p.data.Vertices, v)
+	p.vertexIdx[c] = idx
+	return idx
+}
+
+// closeGroup ends the group of the current material. Vertices are not shared
+// across groups since their colors come from the material.
+func (p *objParser) closeGroup() {
+	first := p.group.FirstIndex
+	count := uint32(len(p.data.Indices)) - first
+	if count > 0 {
+		p.data.Groups = append(p.data.Groups, MeshGroup{
+			Material:   p.material,
+			FirstIndex: first,
+			IndexCount: count,
+		})
+	}
+	p.group = MeshGroup{FirstIndex: uint32(len(p.data.Indices))}
+	p.vertexIdx = make(map[objVertex]uint32)
+}
+
+func (p *objParser) smoothNormals() {
+	var missing bool
+	for _, v := range p.data.Vertices {
+		if v.Normal == [3]float32{} {
+			missing = true
+			break
+		}
+	}
+	if !missing {
+		return
+	}
+	sums := make([][3]float32, len(p.data.Vertices))
+	idx := p.data.Indices
+	for i := 0; i+2 < len(idx); i += 3 {
+		a := p.data.Vertices[idx[i]].Position
+		b := p.data.Vertices[idx[i+1]].Position
+		c := p.data.Vertices[idx[i+2]].Position
+		n := cross3(sub3(b, a), sub3(c, a))
+		for _, j := range idx[i : i+3] {
+			sums[j] = add3(sums[j], n)
+		}
+	}
+	for i := range p.data.Vertices {
+		v := &p.data.Vertices[i]
+		if v.Normal != [3]float32{} {
+			continue
+		}
+		v.Normal = normalize3(sums[i])
+	}
+}
+
+func (p *objParser) loadMTL(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("obj: %s:%d: %v", p.path, p.line, err)
+	}
+	defer f.Close()
+
+	dir := filepath.Dir(path)
+	var m *Material
+	sc := bufio.NewScanner(f)
+	line := 0
+	for sc.Scan() {
+		line++
+		fields := strings.Fields(stripComment(sc.Text()))
+		if len(fields) == 0 {
+			continue
+		}
+		if fields[0] == "newmtl" {
+			m = &Material{
+				Name:    strings.Join(fields[1:], " "),
+				Diffuse: [4]float32{1, 1, 1, 1},
+			}
+			p.materials[m.Name] = m
+			continue
+		}
+		if m == nil {
+			continue
+		}
+		switch fields[0] {
+		case "Kd":
+			if len(fields) < 4 {
+				return fmt.Errorf("mtl: %s:%d: Kd needs 3 values", path, line)
+			}
+			for i := 0; i < 3; i++ {
+				v, err := strconv.ParseFloat(fields[i+1], 32)
+				if err != nil {
+					return fmt.Errorf("mtl: %s:%d: %v", path, line, err)
+				}
+				m.Diffuse[i] = float32(v)
+			}
+		case "d", "Tr":
+			if len(fields) < 2 {
+				return fmt.Errorf("mtl: %s:%d: %s needs a value", path, line, fields[0])
+			}
+			v, err := strconv.ParseFloat(fields[len(fields)-1], 32)
+			if err != nil {
+				return fmt.Errorf("mtl: %s:%d: %v", path, line, err)
+			}
+			if fields[0] == "Tr" {
+				v = 1 - v
+			}
+			m.Diffuse[3] = float32(v)
+		case "map_Kd":
+			if len(fields) < 2 {
+				return fmt.Errorf("mtl: %s:%d: map_Kd needs a file name", path, line)
+			}
+			// the file name comes last, after any map options
+			name := filepath.FromSlash(strings.Replace(fields[len(fields)-1], "\\", "/", -1))
+			if !filepath.IsAbs(name) {
+				name = filepath.Join(dir, name)
+			}
+			if _, err := os.Stat(name); err != nil {
+				log.Printf("mtl: %s:%d: diffuse map: %v", path, line, err)
+				continue
+			}
+			m.DiffuseMap = TextureRef{Name: name}
+		}
+	}
+	return sc.Err()
+}
+
+func stripComment(line string) string {
+	if i := strings.IndexByte(line, '#'); i >= 0 {
+		return line[:i]
+	}
+	return line
+}
+
+func sub3(a, b [3]float32) [3]float32 {
+	return [3]float32{a[0] - b[0], a[1] - b[1], a[2] - b[2]}
+}
+
+func add3(a, b [3]float32) [3]float32 {
+	return [3]float32{a[0] + b[0], a[1] + b[1], a[2] + b[2]}
+}
+
+func cross3(a, b [3]float32) [3]float32 {
+	return [3]float32{
+		a[1]*b[2] - a[2]*b[1],
+		a[2]*b[0] - a[0]*b[2],
+		a[0]*b[1] - a[1]*b[0],
+	}
+}
+
+func normalize3(v [3]float32) [3]float32 {
+	l := float32(math.Sqrt(float64(v[0]*v[0] + v[1]*v[1] + v[2]*v[2])))
+	if l == 0 {
+		return [3]float32{0, 0, 1}
+	}
+	return [3]float32{v[0] / l, v[1] / l, v[2] / l}
+}
@@ -0,0 +1,123 @@
+package util
+
+import (
+	"strings"
+	"testing"
+)
+
+func parseTestOBJ(t *testing.T, src string) *MeshData {
+	t.Helper()
+	data, err := ParseOBJ(strings.NewReader(src), "test.obj")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func checkIndices(t *testing.T, got, want []uint32) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("indices %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("indices %v, want %v", got, want)
+		}
+	}
+}
+
+func TestParseOBJNegativeIndices(t *testing.T) {
+	data := parseTestOBJ(t, `
+v 0 0 0
+v 1 0 0
+v 0 1 0
+vt 0 0
+vt 1 0
+vt 0 1
+vn 0 0 1
+f -3/-3/-1 -2/-2/-1 -1/-1/-1
+`)
+	checkIndices(t, data.Indices, []uint32{0, 1, 2})
+	want := []Vertex{
+		{Position: [3]float32{0, 0, 0}, UV: [2]float32{0, 1}},
+		{Position: [3]float32{1, 0, 0}, UV: [2]float32{1, 1}},
+		{Position: [3]float32{0, 1, 0}, UV: [2]float32{0, 0}},
+	}
+	for i, w := range want {
+		v := data.Vertices[i]
+		if v.Position != w.Position || v.UV != w.UV || v.Normal != [3]float32{0, 0, 1} {
+			t.Errorf("vertex %d is %+v, want position %v, uv %v, normal (0,0,1)",
+				i, v, w.Position, w.UV)
+		}
+	}
+}
+
+func TestParseOBJPolygonFan(t *testing.T) {
+	data := parseTestOBJ(t, `
+v 0 0 0
+v 1 0 0
+v 1 1 0
+v 0 1 0
+v -1 1 0
+f 1 2 3 4 5
+`)
+	checkIndices(t, data.Indices, []uint32{0, 1, 2, 0, 2, 3, 0, 3, 4})
+	if len(data.Vertices) != 5 {
+		t.Errorf("%d vertices, want 5", len(data.Vertices))
+	}
+}
+
+func TestParseOBJMissingAttributes(t *testing.T) {
+	// positions only, positions and normals, positions and uvs
+	data := parseTestOBJ(t, `
+v 0 0 0
+v 1 0 0
+v 0 1 0
+vt 0.5 0.25
+vn 1 0 0
+f 1 2 3
+f 1//1 2//1 3//1
+f 1/1 2/1 3/1
+`)
+	checkIndices(t, data.Indices, []uint32{0, 1, 2, 3, 4, 5, 6, 7, 8})
+	for i, v := range data.Vertices {
+		var normal [3]float32
+		var uv [2]float32
+		switch i / 3 {
+		case 0, 2:
+			// counter-clockwise in the xy plane
+			normal = [3]float32{0, 0, 1}
+		case 1:
+			normal = [3]float32{1, 0, 0}
+		}
+		if i/3 == 2 {
+			uv = [2]float32{0.5, 0.75}
+		}
+		if v.Normal != normal || v.UV != uv {
+			t.Errorf("vertex %d has normal %v and uv %v, want %v and %v",
+				i, v.Normal, v.UV, normal, uv)
+		}
+		if v.Color != [4]float32{1, 1, 1, 1} {
+			t.Errorf("vertex %d has color %v without a material", i, v.Color)
+		}
+	}
+}
+
+func TestParseOBJErrors(t *testing.T) {
+	for _, src := range []string{
+		"v 0 0 0\nv 1 0 0\nf 1 2\n",
+		"v 0 0 0\nv 1 0 0\nv 0 1 0\nf 1 2 4\n",
+		"v 0 0 0\nv 1 0 0\nv 0 1 0\nf 1 2 -4\n",
+		"v 0 0 0\nv 1 0 0\nv 0 1 0\nf 1 2 0\n",
+		"v 0 0 0\nv 1 0 0\nv 0 1 0\nf 1/1 2/1 3/1\n",
+		"v 0 0 0\nv 1 0 0\nv 0 1 0\nf 1//1 2//1 3//1\n",
+		"v 0 0 0\nv 1 0 0\nv 0 1 0\nf 1 2 x\n",
+		"v 0 0 0\nv 1 0 0\nv 0 1 0\nf 1/1/1/1 2 3\n",
+		"v 0 0\n",
+		"v 0 0 nan0\n",
+	} {
+		if _, err := ParseOBJ(strings.NewReader(src), "test.obj"); err == nil {
+			t.Errorf("%q parsed without an error", src)
+		}
+	}
+}
@@ -0,0 +1,155 @@
+package util
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"unsafe"
+
+	as "github.com/vulkan-go/asche"
+	vk "github.com/vulkan-go/vulkan"
+)
+
+// pipelineCacheHeaderSize is the size of the header of the
+// vk.PipelineCacheHeaderVersionOne pipeline cache data.
+const pipelineCacheHeaderSize = 16 + vk.UuidSize
+
+// defaultPipelineCachePath returns the file the pipeline cache is kept in
+// by default, in the user cache directory, named after the executable and
+// the vendor and device of props. It is empty when there is none.
+func defaultPipelineCachePath(props vk.PhysicalDeviceProperties) string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+	app := filepath.Base(os.Args[0])
+	app = strings.TrimSuffix(app, filepath.Ext(app))
+	name := fmt.Sprintf("%s-%04x-%04x.bin", app, props.VendorID, props.DeviceID)
+	return filepath.Join(dir, "FieboLib", "pipeline-cache", name)
+}
+
+// SetPipelineCachePath sets the file the pipeline cache is loaded from when
+// the context is prepared and saved to by Destroy, so that pipelines built
+// on an earlier run are not compiled again. By default it is a file in the
+// user cache directory named after the executable and the GPU, an empty
+// path keeps the cache in memory.
+func (s *SpinningCube) SetPipelineCachePath(path string) {
+	s.pipelineCachePath = path
+	s.pipelineCacheDefault = false
+}
+
+// createPipelineCache creates the pipeline cache, with the data saved
+// before when it was saved by the same device and driver.
+func (s *SpinningCube) createPipelineCache() vk.PipelineCache {
+	rc := s.rc()
+	var props vk.PhysicalDeviceProperties
+	vk.GetPhysicalDeviceProperties(rc.PhysicalDevice(), &props)
+	props.Deref()
+	if s.pipelineCacheDefault {
+		s.pipelineCachePath = defaultPipelineCachePath(props)
+	}
+
+	info := vk.PipelineCacheCreateInfo{
+		SType: vk.StructureTypePipelineCacheCreateInfo,
+	}
+	if data := s.loadPipelineCache(props); len(data) > 0 {
+		info.InitialDataSize = uint(len(data))
+		info.PInitialData = unsafe.Pointer(&data[0])
+	}
+	var cache vk.PipelineCache
+	ret := vk.CreatePipelineCache(rc.Device(), &info, nil, &cache)
+	orPanic(as.NewError(ret))
+	return cache
+}
+
+// loadPipelineCache reads the saved pipeline cache data. Data of another
+// device or driver version is discarded, drivers may not validate it.
+func (s *SpinningCube) loadPipelineCache(props vk.PhysicalDeviceProperties) []byte {
+	if s.pipelineCachePath == "" {
+		return nil
+	}
+	data, err := ioutil.ReadFile(s.pipelineCachePath)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		log.Printf("vulkan warn: pipeline cache not loaded: %v", err)
+		return nil
+	}
+
+	if !pipelineCacheMatches(data, props) {
+		log.Printf("vulkan: pipeline cache %s is stale, discarding it", s.pipelineCachePath)
+		return nil
+	}
+	return data
+}
+
+// pipelineCacheMatches reports whether the header of the pipeline cache
+// data matches the vendor, device and pipeline cache UUID of props.
+func pipelineCacheMatches(data []byte, props vk.PhysicalDeviceProperties) bool {
+	if len(data) < pipelineCacheHeaderSize {
+		return false
+	}
+	// the header is in host byte order, which is little-endian
+	// on the platforms supported
+	le := binary.LittleEndian
+	headerSize := le.Uint32(data[0:])
+	version := le.Uint32(data[4:])
+	vendorID := le.Uint32(data[8:])
+	deviceID := le.Uint32(data[12:])
+	return headerSize >= pipelineCacheHeaderSize && int(headerSize) <= len(data) &&
+		version == uint32(vk.PipelineCacheHeaderVersionOne) &&
+		vendorID == props.VendorID && deviceID == props.DeviceID &&
+		bytes.Equal(data[16:pipelineCacheHeaderSize], props.PipelineCacheUUID[:])
+}
+
+// savePipelineCache writes the data of the pipeline cache to its file.
+// Failing to save it is not fatal, the next run compiles the pipelines.
+func (s *SpinningCube) savePipelineCache() {
+	if s.pipelineCachePath == "" {
+		return
+	}
+	dev := s.rc().Device()
+	var size uint
+	ret := vk.GetPipelineCacheData(dev, s.pipelineCache, &size, nil)
+	if err := as.NewError(ret); err != nil || size == 0 {
+		return
+	}
+	data := make([]byte, size)
+	ret = vk.GetPipelineCacheData(dev, s.pipelineCache, &size, unsafe.Pointer(&data[0]))
+	if err := as.NewError(ret); err != nil {
+		log.Printf("vulkan warn: pipeline cache not saved: %v", err)
+		return
+	}
+	if err := writeFileAtomic(s.pipelineCachePath, data[:size]); err != nil {
+		log.Printf("vulkan warn: pipeline cache not saved: %v", err)
+	}
+}
+
+// writeFileAtomic writes data to a temporary file next to path and renames
+// it to path, so that concurrent runs never read a partly written file.
+func writeFileAtomic(path string, data []byte) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(dir, filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return err
+}
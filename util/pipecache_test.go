@@ -0,0 +1,86 @@
+package util
+
+import (
+	"encoding/binary"
+	"testing"
+
+	vk "github.com/vulkan-go/vulkan"
+)
+
+var testPipelineCacheProps = vk.PhysicalDeviceProperties{
+	VendorID:          0x10de,
+	DeviceID:          0x1c82,
+	PipelineCacheUUID: [vk.UuidSize]byte{0: 1, 7: 0xaa, 15: 0xff},
+}
+
+// pipelineCacheHeader returns the header of a pipeline cache of props,
+// followed by 8 bytes of cache data.
+func pipelineCacheHeader(props vk.PhysicalDeviceProperties) []byte {
+	data := make([]byte, pipelineCacheHeaderSize+8)
+	le := binary.LittleEndian
+	le.PutUint32(data[0:], pipelineCacheHeaderSize)
+	le.PutUint32(data[4:], uint32(vk.PipelineCacheHeaderVersionOne))
+	le.PutUint32(data[8:], props.VendorID)
+	le.PutUint32(data[12:], props.DeviceID)
+	copy(data[16:], props.PipelineCacheUUID[:])
+	return data
+}
+
+func TestPipelineCacheMatches(t *testing.T) {
+	props := testPipelineCacheProps
+	valid := pipelineCacheHeader(props)
+	if !pipelineCacheMatches(valid, props) {
+		t.Fatal("valid header rejected")
+	}
+	if !pipelineCacheMatches(valid[:pipelineCacheHeaderSize], props) {
+		t.Error("header without cache data rejected")
+	}
+
+	for _, tc := range []struct {
+		name   string
+		modify func(data []byte) []byte
+	}{
+		{"short header", func(data []byte) []byte {
+			return data[:pipelineCacheHeaderSize-1]
+		}},
+		{"empty", func(data []byte) []byte {
+			return nil
+		}},
+		{"header size too small", func(data []byte) []byte {
+			binary.LittleEndian.PutUint32(data[0:], pipelineCacheHeaderSize-1)
+			return data
+		}},
+		{"header size past the data", func(data []byte) []byte {
+			binary.LittleEndian.PutUint32(data[0:], uint32(len(data)+1))
+			return data
+		}},
+		{"header version", func(data []byte) []byte {
+			binary.LittleEndian.PutUint32(data[4:], uint32(vk.PipelineCacheHeaderVersionOne)+1)
+			return data
+		}},
+		{"vendor ID", func(data []byte) []byte {
+			binary.LittleEndian.PutUint32(data[8:], 0x1002)
+			return data
+		}},
+		{"device ID", func(data []byte) []byte {
+			binary.LittleEndian.PutUint32(data[12:], 0x1c83)
+			return data
+		}},
+		{"UUID", func(data []byte) []byte {
+			data[16+15] ^= 1
+			return data
+		}},
+	} {
+		data := tc.modify(pipelineCacheHeader(props))
+		if pipelineCacheMatches(data, props) {
+			t.Errorf("%s: header accepted", tc.name)
+		}
+	}
+
+	// the header is checked against the device, not just for consistency
+	other := props
+	other.DeviceID++
+	if pipelineCacheMatches(valid, other) {
+		t.Error("header of another device accepted")
+	}
+}
@@ -0,0 +1,504 @@
+package util
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+
+	as "github.com/vulkan-go/asche"
+	vk "github.com/vulkan-go/vulkan"
+)
+
+// VertexInput is the vertex input of a pipeline.
+type VertexInput int
+
+const (
+	// VertexInputMesh reads the Vertex layout of the meshes
+	// from vertex buffer binding 0.
+	VertexInputMesh VertexInput = iota
+	// VertexInputNone reads no vertex buffers, the vertex shader
+	// generates the vertices from gl_VertexIndex.
+	VertexInputNone
+)
+
+// BlendMode is a preset of the color blend state.
+type BlendMode int
+
+const (
+	// BlendOpaque writes the fragment color as is.
+	BlendOpaque BlendMode = iota
+	// BlendAlpha blends the fragment color over the attachment by its
+	// alpha, for colors that are not premultiplied.
+	BlendAlpha
+	// BlendPremultiplied blends the fragment color, premultiplied
+	// by its alpha, over the attachment.
+	BlendPremultiplied
+	// BlendAdditive adds the fragment color, weighted by its alpha,
+	// to the attachment.
+	BlendAdditive
+)
+
+// StencilOp is the stencil state of a face, see vk.StencilOpState.
+type StencilOp struct {
+	FailOp      vk.StencilOp
+	PassOp      vk.StencilOp
+	DepthFailOp vk.StencilOp
+	CompareOp   vk.CompareOp
+	CompareMask uint32
+	WriteMask   uint32
+	Reference   uint32
+}
+
+// PipelineDesc describes a graphics pipeline. Pipelines built from equal
+// descriptions share a single vk.Pipeline.
+type PipelineDesc struct {
+	// VertexShader and FragmentShader are the paths of the SPIR-V
+	// shaders, with "main" as their entry point.
+	VertexShader   string
+	FragmentShader string
+	Layout         vk.PipelineLayout
+	RenderPass     vk.RenderPass
+	Subpass        uint32
+
+	VertexInput      VertexInput
+	Topology         vk.PrimitiveTopology
+	PrimitiveRestart bool
+
+	PolygonMode vk.PolygonMode
+	CullMode    vk.CullModeFlagBits
+	FrontFace   vk.FrontFace
+	LineWidth   float32
+
+	Blend          BlendMode
+	ColorWriteMask vk.ColorComponentFlags
+	BlendConstants [4]float32
+	// LogicOp replaces blending with the logical operation LogicOpcode,
+	// it needs the logicOp feature.
+	LogicOp     bool
+	LogicOpcode vk.LogicOp
+
+	DepthTest    bool
+	DepthWrite   bool
+	DepthCompare vk.CompareOp
+	// DepthBias enables the depth bias of the constant and slope factors,
+	// clamped to DepthBiasClamp unless 0, which needs the depthBiasClamp
+	// feature.
+	DepthBias         bool
+	DepthBiasConstant float32
+	DepthBiasClamp    float32
+	DepthBiasSlope    float32
+	// StencilTest enables the stencil test with StencilFront
+	// and StencilBack.
+	StencilTest  bool
+	StencilFront StencilOp
+	StencilBack  StencilOp
+
+	// DynamicStates is the set of core dynamic states of the pipeline,
+	// a bit 1<<state for each vk.DynamicState. The viewport and scissor
+	// are always dynamic, the draws set them to the extent of the frame.
+	DynamicStates uint32
+
+	// Samples is the sample count, which must match the attachments
+	// of the render pass. The render passes of the scene are single-sampled,
+	// pipelines drawn in them must keep vk.SampleCount1Bit. MinSampleShading
+	// above 0 enables sample shading.
+	Samples          vk.SampleCountFlagBits
+	MinSampleShading float32
+	AlphaToCoverage  bool
+}
+
+// viewportScissorStates are the dynamic states of every pipeline.
+const viewportScissorStates = 1<<uint32(vk.DynamicStateViewport) | 1<<uint32(vk.DynamicStateScissor)
+
+// DefaultPipelineDesc returns the description pipelines are built with
+// unless overridden: opaque triangle lists of the meshes, with back faces
+// culled, depth tested and written, and the viewport and scissor dynamic.
+// The shaders, layout and render pass are left to the builder.
+func DefaultPipelineDesc() PipelineDesc {
+	return PipelineDesc{
+		VertexInput:    VertexInputMesh,
+		Topology:       vk.PrimitiveTopologyTriangleList,
+		PolygonMode:    vk.PolygonModeFill,
+		CullMode:       vk.CullModeBackBit,
+		FrontFace:      vk.FrontFaceCounterClockwise,
+		LineWidth:      1,
+		Blend:          BlendOpaque,
+		ColorWriteMask: 0xF,
+		DepthTest:      true,
+		DepthWrite:     true,
+		DepthCompare:   vk.CompareOpLessOrEqual,
+		DynamicStates:  viewportScissorStates,
+		Samples:        vk.SampleCount1Bit,
+	}
+}
+
+// PipelineBuilder builds a graphics pipeline of a SpinningCube, starting
+// from DefaultPipelineDesc with the scene layout and render pass. Each
+// method overrides a part of the description and returns the builder.
+type PipelineBuilder struct {
+	cube *SpinningCube
+	desc PipelineDesc
+	// err is the first invalid override, returned by Build
+	err error
+}
+
+// NewPipeline returns a builder of a pipeline drawn in the render pass
+// of the scene, with the pipeline layout of the meshes. Pipelines are
+// built once the context is prepared.
+func (s *SpinningCube) NewPipeline() *PipelineBuilder {
+	desc := DefaultPipelineDesc()
+	if s.renderPass != nil {
+		desc.Layout = s.sceneLayout()
+		desc.RenderPass = s.renderPass
+	}
+	return &PipelineBuilder{
+		cube: s,
+		desc: desc,
+	}
+}
+
+// Shaders sets the paths of the SPIR-V vertex and fragment shaders.
+func (b *PipelineBuilder) Shaders(vertex, fragment string) *PipelineBuilder {
+	b.desc.VertexShader, b.desc.FragmentShader = vertex, fragment
+	return b
+}
+
+// Layout sets the pipeline layout.
+func (b *PipelineBuilder) Layout(layout vk.PipelineLayout) *PipelineBuilder {
+	b.desc.Layout = layout
+	return b
+}
+
+// RenderPass sets the render pass and the subpass the pipeline is used in.
+func (b *PipelineBuilder) RenderPass(renderPass vk.RenderPass, subpass uint32) *PipelineBuilder {
+	b.desc.RenderPass, b.desc.Subpass = renderPass, subpass
+	return b
+}
+
+// VertexInput sets the vertex input.
+func (b *PipelineBuilder) VertexInput(input VertexInput) *PipelineBuilder {
+	b.desc.VertexInput = input
+	return b
+}
+
+// Topology sets the primitive topology, with primitive restart
+// for the strip and fan topologies.
+func (b *PipelineBuilder) Topology(topology vk.PrimitiveTopology, restart bool) *PipelineBuilder {
+	b.desc.Topology, b.desc.PrimitiveRestart = topology, restart
+	return b
+}
+
+// PolygonMode sets how polygons are rasterized, with lineWidth for
+// vk.PolygonModeLine. Other modes than vk.PolygonModeFill need the
+// fillModeNonSolid feature.
+func (b *PipelineBuilder) PolygonMode(mode vk.PolygonMode, lineWidth float32) *PipelineBuilder {
+	b.desc.PolygonMode, b.desc.LineWidth = mode, lineWidth
+	return b
+}
+
+// Cull sets the faces culled and the winding of the front faces.
+func (b *PipelineBuilder) Cull(mode vk.CullModeFlagBits, frontFace vk.FrontFace) *PipelineBuilder {
+	b.desc.CullMode, b.desc.FrontFace = mode, frontFace
+	return b
+}
+
+// Blend sets the color blending preset.
+func (b *PipelineBuilder) Blend(mode BlendMode) *PipelineBuilder {
+	b.desc.Blend = mode
+	return b
+}
+
+// ColorWriteMask sets the color components written.
+func (b *PipelineBuilder) ColorWriteMask(mask vk.ColorComponentFlags) *PipelineBuilder {
+	b.desc.ColorWriteMask = mask
+	return b
+}
+
+// DepthBias enables the depth bias, with the constant and slope factors
+// and the bias clamped to clamp unless 0.
+func (b *PipelineBuilder) DepthBias(constant, clamp, slope float32) *PipelineBuilder {
+	b.desc.DepthBias = true
+	b.desc.DepthBiasConstant, b.desc.DepthBiasClamp, b.desc.DepthBiasSlope = constant, clamp, slope
+	return b
+}
+
+// BlendConstants sets the constant color of the blend factors using it.
+func (b *PipelineBuilder) BlendConstants(r, g, bl, a float32) *PipelineBuilder {
+	b.desc.BlendConstants = [4]float32{r, g, bl, a}
+	return b
+}
+
+// LogicOp replaces color blending with the logical operation op.
+func (b *PipelineBuilder) LogicOp(op vk.LogicOp) *PipelineBuilder {
+	b.desc.LogicOp, b.desc.LogicOpcode = true, op
+	return b
+}
+
+// Depth sets whether the depth is tested, with compare, and written.
+func (b *PipelineBuilder) Depth(test, write bool, compare vk.CompareOp) *PipelineBuilder {
+	b.desc.DepthTest, b.desc.DepthWrite, b.desc.DepthCompare = test, write, compare
+	return b
+}
+
+// Stencil enables the stencil test with the state of the front
+// and back faces.
+func (b *PipelineBuilder) Stencil(front, back StencilOp) *PipelineBuilder {
+	b.desc.StencilTest = true
+	b.desc.StencilFront, b.desc.StencilBack = front, back
+	return b
+}
+
+// Dynamic sets the dynamic states, besides the viewport and scissor which
+// are always dynamic. Only the core states up to
+// vk.DynamicStateStencilReference are supported.
+func (b *PipelineBuilder) Dynamic(states ...vk.DynamicState) *PipelineBuilder {
+	b.desc.DynamicStates = viewportScissorStates
+	for _, state := range states {
+		if state < 0 || state > vk.DynamicStateStencilReference {
+			if b.err == nil {
+				b.err = fmt.Errorf("vulkan: dynamic state %d not supported by PipelineBuilder", state)
+			}
+			continue
+		}
+		b.desc.DynamicStates |= 1 << uint(state)
+	}
+	return b
+}
+
+// Multisample sets the sample count, with sample shading of at least
+// minSampleShading of the samples when above 0, and alpha to coverage.
+// Sample counts above 1 need a render pass of multisampled attachments
+// set with RenderPass.
+func (b *PipelineBuilder) Multisample(samples vk.SampleCountFlagBits, minSampleShading float32,
+	alphaToCoverage bool) *PipelineBuilder {
+
+	b.desc.Samples = samples
+	b.desc.MinSampleShading = minSampleShading
+	b.desc.AlphaToCoverage = alphaToCoverage
+	return b
+}
+
+// Desc returns the description built so far.
+func (b *PipelineBuilder) Desc() PipelineDesc {
+	return b.desc
+}
+
+// Build returns the pipeline of the description, creating it unless one
+// was built from an equal description before. The pipeline is owned by
+// the SpinningCube and destroyed with it.
+func (b *PipelineBuilder) Build() (pipeline vk.Pipeline, err error) {
+	defer checkErr(&err)
+
+	return b.build(), nil
+}
+
+func (b *PipelineBuilder) build() vk.Pipeline {
+	orPanic(b.err)
+	s := b.cube
+	if s.pipelines == nil {
+		orPanic(errors.New("vulkan: pipelines are built once the context is prepared"))
+	}
+	features := s.rc().Features()
+	if b.desc.LogicOp && features.LogicOp != vk.True {
+		orPanic(errors.New("vulkan: logic op without the logicOp feature"))
+	}
+	if b.desc.DepthBias && b.desc.DepthBiasClamp != 0 && features.DepthBiasClamp != vk.True {
+		orPanic(errors.New("vulkan: depth bias clamp without the depthBiasClamp feature"))
+	}
+	if b.desc.Samples != vk.SampleCount1Bit && b.desc.RenderPass == s.renderPass {
+		orPanic(fmt.Errorf("vulkan: %d samples in the single-sampled render pass of the scene",
+			b.desc.Samples))
+	}
+	return s.pipelines.get(b.desc)
+}
+
+// pipelineSet creates the graphics pipelines of a device, one for each
+// distinct description. The pipelines live as long as the set.
+type pipelineSet struct {
+	dev       vk.Device
+	cache     vk.PipelineCache
+	pipelines map[PipelineDesc]vk.Pipeline
+}
+
+func newPipelineSet(dev vk.Device, cache vk.PipelineCache) *pipelineSet {
+	return &pipelineSet{
+		dev:       dev,
+		cache:     cache,
+		pipelines: make(map[PipelineDesc]vk.Pipeline),
+	}
+}
+
+// get returns the pipeline of desc, creating it on first use.
+func (p *pipelineSet) get(desc PipelineDesc) vk.Pipeline {
+	if pipeline, ok := p.pipelines[desc]; ok {
+		return pipeline
+	}
+	dev := p.dev
+	vs := loadShader(dev, desc.VertexShader)
+	defer vk.DestroyShaderModule(dev, vs, nil)
+	fs := loadShader(dev, desc.FragmentShader)
+	defer vk.DestroyShaderModule(dev, fs, nil)
+
+	vertexInput := &vk.PipelineVertexInputStateCreateInfo{
+		SType: vk.StructureTypePipelineVertexInputStateCreateInfo,
+	}
+	if desc.VertexInput == VertexInputMesh {
+		vertexInput.VertexBindingDescriptionCount = uint32(len(vertexBindings))
+		vertexInput.PVertexBindingDescriptions = vertexBindings
+		vertexInput.VertexAttributeDescriptionCount = uint32(len(vertexAttributes))
+		vertexInput.PVertexAttributeDescriptions = vertexAttributes
+	}
+	var dynamicStates []vk.DynamicState
+	for state := vk.DynamicStateViewport; state <= vk.DynamicStateStencilReference; state++ {
+		if (desc.DynamicStates|viewportScissorStates)&(1<<uint(state)) != 0 {
+			dynamicStates = append(dynamicStates, state)
+		}
+	}
+	multisample := &vk.PipelineMultisampleStateCreateInfo{
+		SType:                 vk.StructureTypePipelineMultisampleStateCreateInfo,
+		RasterizationSamples:  desc.Samples,
+		AlphaToCoverageEnable: vkBool(desc.AlphaToCoverage),
+	}
+	if desc.MinSampleShading > 0 {
+		multisample.SampleShadingEnable = vk.True
+		multisample.MinSampleShading = desc.MinSampleShading
+	}
+
+	pipeline := make([]vk.Pipeline, 1)
+	ret := vk.CreateGraphicsPipelines(dev, p.cache, 1, []vk.GraphicsPipelineCreateInfo{{
+		SType:      vk.StructureTypeGraphicsPipelineCreateInfo,
+		Layout:     desc.Layout,
+		RenderPass: desc.RenderPass,
+		Subpass:    desc.Subpass,
+
+		PDynamicState: &vk.PipelineDynamicStateCreateInfo{
+			SType:             vk.StructureTypePipelineDynamicStateCreateInfo,
+			DynamicStateCount: uint32(len(dynamicStates)),
+			PDynamicStates:    dynamicStates,
+		},
+		PVertexInputState: vertexInput,
+		PInputAssemblyState: &vk.PipelineInputAssemblyStateCreateInfo{
+			SType:                  vk.StructureTypePipelineInputAssemblyStateCreateInfo,
+			Topology:               desc.Topology,
+			PrimitiveRestartEnable: vkBool(desc.PrimitiveRestart),
+		},
+		PRasterizationState: &vk.PipelineRasterizationStateCreateInfo{
+			SType:       vk.StructureTypePipelineRasterizationStateCreateInfo,
+			PolygonMode: desc.PolygonMode,
+			CullMode:    vk.CullModeFlags(desc.CullMode),
+			FrontFace:   desc.FrontFace,
+			LineWidth:   desc.LineWidth,
+
+			DepthBiasEnable:         vkBool(desc.DepthBias),
+			DepthBiasConstantFactor: desc.DepthBiasConstant,
+			DepthBiasClamp:          desc.DepthBiasClamp,
+			DepthBiasSlopeFactor:    desc.DepthBiasSlope,
+		},
+		PColorBlendState: &vk.PipelineColorBlendStateCreateInfo{
+			SType:           vk.StructureTypePipelineColorBlendStateCreateInfo,
+			LogicOpEnable:   vkBool(desc.LogicOp),
+			LogicOp:         desc.LogicOpcode,
+			AttachmentCount: 1,
+			PAttachments:    []vk.PipelineColorBlendAttachmentState{blendAttachment(desc)},
+			BlendConstants:  desc.BlendConstants,
+		},
+		PMultisampleState: multisample,
+		PViewportState: &vk.PipelineViewportStateCreateInfo{
+			SType:         vk.StructureTypePipelineViewportStateCreateInfo,
+			ScissorCount:  1,
+			ViewportCount: 1,
+		},
+		PDepthStencilState: &vk.PipelineDepthStencilStateCreateInfo{
+			SType:             vk.StructureTypePipelineDepthStencilStateCreateInfo,
+			DepthTestEnable:   vkBool(desc.DepthTest),
+			DepthWriteEnable:  vkBool(desc.DepthWrite),
+			DepthCompareOp:    desc.DepthCompare,
+			StencilTestEnable: vkBool(desc.StencilTest),
+			Front:             desc.StencilFront.state(),
+			Back:              desc.StencilBack.state(),
+		},
+		StageCount: 2,
+		PStages: []vk.PipelineShaderStageCreateInfo{{
+			SType:  vk.StructureTypePipelineShaderStageCreateInfo,
+			Stage:  vk.ShaderStageVertexBit,
+			Module: vs,
+			PName:  "main\x00",
+		}, {
+			SType:  vk.StructureTypePipelineShaderStageCreateInfo,
+			Stage:  vk.ShaderStageFragmentBit,
+			Module: fs,
+			PName:  "main\x00",
+		}},
+	}}, nil, pipeline)
+	orPanic(as.NewError(ret))
+	p.pipelines[desc] = pipeline[0]
+	return pipeline[0]
+}
+
+func (p *pipelineSet) destroy() {
+	for desc, pipeline := range p.pipelines {
+		vk.DestroyPipeline(p.dev, pipeline, nil)
+		delete(p.pipelines, desc)
+	}
+}
+
+// blendAttachment returns the color blend state of the preset of desc.
+func blendAttachment(desc PipelineDesc) vk.PipelineColorBlendAttachmentState {
+	state := vk.PipelineColorBlendAttachmentState{
+		ColorWriteMask: desc.ColorWriteMask,
+		ColorBlendOp:   vk.BlendOpAdd,
+		AlphaBlendOp:   vk.BlendOpAdd,
+	}
+	switch desc.Blend {
+	case BlendOpaque:
+		state.BlendEnable = vk.False
+		return state
+	case BlendAlpha:
+		state.SrcColorBlendFactor = vk.BlendFactorSrcAlpha
+		state.DstColorBlendFactor = vk.BlendFactorOneMinusSrcAlpha
+		state.SrcAlphaBlendFactor = vk.BlendFactorOne
+		state.DstAlphaBlendFactor = vk.BlendFactorOneMinusSrcAlpha
+	case BlendPremultiplied:
+		state.SrcColorBlendFactor = vk.BlendFactorOne
+		state.DstColorBlendFactor = vk.BlendFactorOneMinusSrcAlpha
+		state.SrcAlphaBlendFactor = vk.BlendFactorOne
+		state.DstAlphaBlendFactor = vk.BlendFactorOneMinusSrcAlpha
+	case BlendAdditive:
+		state.SrcColorBlendFactor = vk.BlendFactorSrcAlpha
+		state.DstColorBlendFactor = vk.BlendFactorOne
+		state.SrcAlphaBlendFactor = vk.BlendFactorOne
+		state.DstAlphaBlendFactor = vk.BlendFactorOne
+	default:
+		orPanic(fmt.Errorf("vulkan: unknown blend mode %d", desc.Blend))
+	}
+	state.BlendEnable = vk.True
+	return state
+}
+
+func (op StencilOp) state() vk.StencilOpState {
+	return vk.StencilOpState{
+		FailOp:      op.FailOp,
+		PassOp:      op.PassOp,
+		DepthFailOp: op.DepthFailOp,
+		CompareOp:   op.CompareOp,
+		CompareMask: op.CompareMask,
+		WriteMask:   op.WriteMask,
+		Reference:   op.Reference,
+	}
+}
+
+// loadShader creates a shader module of the SPIR-V file at path.
+func loadShader(dev vk.Device, path string) vk.ShaderModule {
+	code, err := ioutil.ReadFile(path)
+	orPanic(err)
+	module, err := as.LoadShaderModule(dev, code)
+	orPanic(err)
+	return module
+}
+
+func vkBool(b bool) vk.Bool32 {
+	if b {
+		return vk.True
+	}
+	return vk.False
+}
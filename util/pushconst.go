@@ -0,0 +1,54 @@
+package util
+
+import (
+	"unsafe"
+
+	as "github.com/vulkan-go/asche"
+	vk "github.com/vulkan-go/vulkan"
+	lin "github.com/xlab/linmath"
+)
+
+// PushConstants is the push constant block of the scene pipeline, pushed
+// per draw. It matches the PushConstants block of the vertex shaders.
+type PushConstants struct {
+	// Model transforms the mesh drawn into the space of the scene,
+	// before the transforms of the uniforms.
+	Model lin.Mat4x4
+}
+
+const (
+	pushConstantsSize   = uint32(unsafe.Sizeof(PushConstants{}))
+	pushConstantsStages = vk.ShaderStageVertexBit
+)
+
+// pushConstantRanges are the push constant ranges of the scene pipeline
+// layouts, which must agree for their descriptor sets to stay compatible.
+var pushConstantRanges = []vk.PushConstantRange{{
+	StageFlags: vk.ShaderStageFlags(pushConstantsStages),
+	Offset:     0,
+	Size:       pushConstantsSize,
+}}
+
+// Push records pushing p into cmd for the draws that follow,
+// with layout the pipeline layout they are drawn with.
+func (p *PushConstants) Push(cmd vk.CommandBuffer, layout vk.PipelineLayout) {
+	vk.CmdPushConstants(cmd, layout, vk.ShaderStageFlags(pushConstantsStages),
+		0, pushConstantsSize, unsafe.Pointer(p))
+}
+
+// createPipelineLayout creates a pipeline layout with sets as its
+// descriptor set layouts and the push constant ranges given.
+func createPipelineLayout(dev vk.Device, sets []vk.DescriptorSetLayout,
+	pushConstants []vk.PushConstantRange) vk.PipelineLayout {
+
+	var layout vk.PipelineLayout
+	ret := vk.CreatePipelineLayout(dev, &vk.PipelineLayoutCreateInfo{
+		SType:                  vk.StructureTypePipelineLayoutCreateInfo,
+		SetLayoutCount:         uint32(len(sets)),
+		PSetLayouts:            sets,
+		PushConstantRangeCount: uint32(len(pushConstants)),
+		PPushConstantRanges:    pushConstants,
+	}, nil, &layout)
+	orPanic(as.NewError(ret))
+	return layout
+}
@@ -0,0 +1,196 @@
+package util
+
+import (
+	"math"
+
+	as "github.com/vulkan-go/asche"
+	vk "github.com/vulkan-go/vulkan"
+	lin "github.com/xlab/linmath"
+)
+
+// RecordFunc records the draws of a frame in the dynamic mode,
+// see SetRecordFunc.
+type RecordFunc func(f *Frame)
+
+// Frame is a frame being recorded by a RecordFunc. Cmd is within the render
+// pass of the scene, with the scene pipeline, the uniforms, the viewport
+// and the texture table bound.
+type Frame struct {
+	Cmd vk.CommandBuffer
+	// Image, View and Framebuffer are the resources of the image
+	// rendered to.
+	Image       vk.Image
+	View        vk.ImageView
+	Framebuffer vk.Framebuffer
+	// Index is the index of the frame in flight, the region
+	// of the uniform ring its uniforms are written to.
+	Index int
+	// Layout is the pipeline layout the meshes are drawn with,
+	// for draws pushing constants of their own.
+	Layout vk.PipelineLayout
+
+	cube *SpinningCube
+	// mvp is the transform of the uniforms of the frame
+	mvp lin.Mat4x4
+}
+
+// SetRecordFunc switches s to the dynamic mode, in which fn records the
+// draws of every frame in place of the skybox and all meshes, so that the
+// scene drawn can change from frame to frame. A nil fn switches back.
+//
+// Windows and Headless record every frame anew. The command buffers of
+// the asche context are replayed, there fn only records them again when
+// the scene changes, such as with AddMesh.
+func (s *SpinningCube) SetRecordFunc(fn RecordFunc) (err error) {
+	defer checkErr(&err)
+
+	s.record = fn
+	if s.prepared {
+		s.recordAgain()
+	}
+	return nil
+}
+
+// recordFrame records the draws of a frame rendering to res with fn.
+func (s *SpinningCube) recordFrame(cmd vk.CommandBuffer, res imageResources, region int) {
+	f := &Frame{
+		Cmd:         cmd,
+		Image:       res.Image(),
+		View:        res.View(),
+		Framebuffer: res.Framebuffer(),
+		Index:       region,
+		Layout:      s.sceneLayout(),
+		cube:        s,
+	}
+	f.mvp = s.uniformData().mvp
+	s.bindTextureTable(cmd)
+	s.record(f)
+}
+
+// recordAgain records the command buffers replayed by the images again
+// after the scene has changed, once the device no longer executes them.
+// Frames recorded as they are rendered pick up the change by themselves.
+func (s *SpinningCube) recordAgain() {
+	for _, res := range s.rc().ImageResources() {
+		if res.CommandBuffer() != nil {
+			ret := vk.DeviceWaitIdle(s.rc().Device())
+			orPanic(as.NewError(ret))
+			s.buildCommandBuffers()
+			return
+		}
+	}
+}
+
+// Meshes returns the number of meshes of the scene.
+func (f *Frame) Meshes() int {
+	return len(f.cube.meshes)
+}
+
+// DrawMesh draws the i-th mesh of the scene with its transform.
+func (f *Frame) DrawMesh(i int) {
+	f.cube.meshes[i].draw(f.Cmd, f.Layout)
+}
+
+// DrawMeshAt draws the i-th mesh of the scene with model in place of
+// its transform, such as to draw a mesh more than once.
+func (f *Frame) DrawMeshAt(i int, model lin.Mat4x4) {
+	m := *f.cube.meshes[i]
+	m.push.Model = model
+	m.draw(f.Cmd, f.Layout)
+}
+
+// DrawSkybox draws the skybox, if the scene has one. It is drawn at the
+// far plane, before or after the meshes.
+func (f *Frame) DrawSkybox() {
+	f.cube.drawSkybox(f.Cmd)
+	// the skybox texture replaced the texture table as set 1
+	f.cube.bindTextureTable(f.Cmd)
+}
+
+// Uniforms reserves size bytes of the uniforms of the frame for a draw,
+// filled with the data fn returns whenever the frame is rendered, and
+// returns the dynamic offset of their range in UniformBuffer.
+func (f *Frame) Uniforms(size int, fn func() []byte) uint32 {
+	return f.cube.uniforms.slot(f.Index, size, fn)
+}
+
+// UniformBuffer returns the buffer the ranges reserved with Uniforms are
+// in, to be bound as a dynamic uniform buffer.
+func (f *Frame) UniformBuffer() vk.Buffer {
+	return f.cube.uniforms.buffer
+}
+
+// Visible reports whether the i-th mesh of the scene may be visible in the
+// frame: whether its bounding sphere, with its transform, intersects the view
+// frustum. Meshes that are not visible can be culled.
+func (f *Frame) Visible(i int) bool {
+	m := f.cube.meshes[i]
+	if len(m.groups) == 0 {
+		return false
+	}
+	var mvp lin.Mat4x4
+	mvp.Mult(&f.mvp, &m.push.Model)
+	return sphereInFrustum(&mvp, m.center, m.radius)
+}
+
+// sphereInFrustum reports whether the sphere intersects the view frustum of
+// the transform mvp into Vulkan clip space, -w <= x, y <= w and 0 <= z <= w.
+func sphereInFrustum(mvp *lin.Mat4x4, center lin.Vec3, radius float32) bool {
+	// the frustum planes are sums of the rows of mvp, in the space of
+	// the sphere; mvp is column-major
+	var rows [4]lin.Vec4
+	for r := range rows {
+		for c := range rows[r] {
+			rows[r][c] = mvp[c][r]
+		}
+	}
+	planes := [6]lin.Vec4{
+		add4(rows[3], rows[0], 1), add4(rows[3], rows[0], -1),
+		add4(rows[3], rows[1], 1), add4(rows[3], rows[1], -1),
+		rows[2], add4(rows[3], rows[2], -1),
+	}
+	for _, p := range planes {
+		dist := p[0]*center[0] + p[1]*center[1] + p[2]*center[2] + p[3]
+		n := float32(math.Sqrt(float64(p[0]*p[0] + p[1]*p[1] + p[2]*p[2])))
+		if dist < -radius*n {
+			return false
+		}
+	}
+	return true
+}
+
+func add4(a, b lin.Vec4, scale float32) lin.Vec4 {
+	return lin.Vec4{a[0] + scale*b[0], a[1] + scale*b[1], a[2] + scale*b[2], a[3] + scale*b[3]}
+}
+
+// boundingSphere returns a sphere around vertices, centered on their
+// bounding box.
+func boundingSphere(vertices []Vertex) (center lin.Vec3, radius float32) {
+	if len(vertices) == 0 {
+		return center, 0
+	}
+	min, max := vertices[0].Position, vertices[0].Position
+	for _, v := range vertices[1:] {
+		for k := 0; k < 3; k++ {
+			if v.Position[k] < min[k] {
+				min[k] = v.Position[k]
+			}
+			if v.Position[k] > max[k] {
+				max[k] = v.Position[k]
+			}
+		}
+	}
+	for k := 0; k < 3; k++ {
+		center[k] = (min[k] + max[k]) / 2
+	}
+	var r2 float32
+	for _, v := range vertices {
+		dx := v.Position[0] - center[0]
+		dy := v.Position[1] - center[1]
+		dz := v.Position[2] - center[2]
+		if d := dx*dx + dy*dy + dz*dz; d > r2 {
+			r2 = d
+		}
+	}
+	return center, float32(math.Sqrt(float64(r2)))
+}
@@ -0,0 +1,81 @@
+package util
+
+import (
+	"testing"
+
+	lin "github.com/xlab/linmath"
+)
+
+// testProjection returns a perspective projection into Vulkan clip space
+// looking down -z, with a field of view of 90 degrees and an aspect of 1:
+// the frustum is |x| <= -z, |y| <= -z and 1 <= -z <= 10.
+func testProjection() *lin.Mat4x4 {
+	const near, far = 1.0, 10.0
+	var m lin.Mat4x4
+	m[0][0] = 1
+	m[1][1] = 1
+	m[2][2] = far / (near - far)
+	m[2][3] = -1
+	m[3][2] = near * far / (near - far)
+	return &m
+}
+
+func TestSphereInFrustum(t *testing.T) {
+	mvp := testProjection()
+	// a radius of 0.5 leaves centers up to 0.5 outside a plane visible,
+	// which is 0.5*sqrt(2) off the slanted sides
+	for _, tc := range []struct {
+		name   string
+		center lin.Vec3
+		want   bool
+	}{
+		{"inside", lin.Vec3{0, 0, -5}, true},
+		{"inside corner", lin.Vec3{4.5, 4.5, -5}, true},
+		{"left straddling", lin.Vec3{-5.5, 0, -5}, true},
+		{"left outside", lin.Vec3{-6, 0, -5}, false},
+		{"right straddling", lin.Vec3{5.5, 0, -5}, true},
+		{"right outside", lin.Vec3{6, 0, -5}, false},
+		{"bottom straddling", lin.Vec3{0, -5.5, -5}, true},
+		{"bottom outside", lin.Vec3{0, -6, -5}, false},
+		{"top straddling", lin.Vec3{0, 5.5, -5}, true},
+		{"top outside", lin.Vec3{0, 6, -5}, false},
+		{"near straddling", lin.Vec3{0, 0, -0.7}, true},
+		{"near outside", lin.Vec3{0, 0, -0.2}, false},
+		{"far straddling", lin.Vec3{0, 0, -10.3}, true},
+		{"far outside", lin.Vec3{0, 0, -10.8}, false},
+		{"behind", lin.Vec3{0, 0, 5}, false},
+	} {
+		if got := sphereInFrustum(mvp, tc.center, 0.5); got != tc.want {
+			t.Errorf("%s: sphere at %v in frustum is %v, want %v", tc.name, tc.center, got, tc.want)
+		}
+	}
+
+	// a sphere enclosing the frustum is never culled
+	if !sphereInFrustum(mvp, lin.Vec3{0, 0, -5}, 100) {
+		t.Error("sphere enclosing the frustum culled")
+	}
+}
+
+func TestBoundingSphere(t *testing.T) {
+	if center, radius := boundingSphere(nil); center != (lin.Vec3{}) || radius != 0 {
+		t.Errorf("sphere around no vertices at %v of radius %v", center, radius)
+	}
+
+	center, radius := boundingSphere([]Vertex{
+		{Position: [3]float32{1, 2, 3}},
+	})
+	if center != (lin.Vec3{1, 2, 3}) || radius != 0 {
+		t.Errorf("sphere around a vertex at %v of radius %v", center, radius)
+	}
+
+	// centered on the bounding box, reaching the vertex farthest from it
+	center, radius = boundingSphere([]Vertex{
+		{Position: [3]float32{-1, 0, 0}},
+		{Position: [3]float32{3, 0, 0}},
+		{Position: [3]float32{1, 3, 0}},
+		{Position: [3]float32{1, -1, 0}},
+	})
+	if center != (lin.Vec3{1, 1, 0}) || radius != 2.236068 {
+		t.Errorf("sphere at %v of radius %v, want at [1 1 0] of radius sqrt(5)", center, radius)
+	}
+}
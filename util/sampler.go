@@ -0,0 +1,179 @@
+package util
+
+import (
+	"log"
+
+	as "github.com/vulkan-go/asche"
+	vk "github.com/vulkan-go/vulkan"
+)
+
+// SamplerDesc describes how a texture is sampled. Textures loaded with equal
+// descriptions share a single vk.Sampler.
+type SamplerDesc struct {
+	MagFilter  vk.Filter
+	MinFilter  vk.Filter
+	MipmapMode vk.SamplerMipmapMode
+
+	AddressModeU vk.SamplerAddressMode
+	AddressModeV vk.SamplerAddressMode
+	AddressModeW vk.SamplerAddressMode
+	// BorderColor is used by vk.SamplerAddressModeClampToBorder.
+	BorderColor vk.BorderColor
+
+	// Anisotropy is the maximum number of samples taken along the axis of
+	// anisotropy. Values above 1 enable anisotropic filtering, which is
+	// limited to what the device supports.
+	Anisotropy float32
+
+	// CompareEnable enables comparing the samples against a reference value
+	// with CompareOp, as used for shadow maps.
+	CompareEnable bool
+	CompareOp     vk.CompareOp
+
+	// MinLod and MaxLod clamp the sampled mip levels,
+	// vk.LodClampNone allows all of them.
+	MinLod float32
+	MaxLod float32
+}
+
+// DefaultSamplerDesc returns the description textures are sampled with unless
+// another one is given, TrilinearSamplerDesc.
+func DefaultSamplerDesc() SamplerDesc {
+	return TrilinearSamplerDesc()
+}
+
+// TrilinearSamplerDesc returns bilinear filtering blended between the two
+// closest mip levels, clamped to the edge.
+func TrilinearSamplerDesc() SamplerDesc {
+	return SamplerDesc{
+		MagFilter:    vk.FilterLinear,
+		MinFilter:    vk.FilterLinear,
+		MipmapMode:   vk.SamplerMipmapModeLinear,
+		AddressModeU: vk.SamplerAddressModeClampToEdge,
+		AddressModeV: vk.SamplerAddressModeClampToEdge,
+		AddressModeW: vk.SamplerAddressModeClampToEdge,
+		BorderColor:  vk.BorderColorFloatOpaqueWhite,
+		Anisotropy:   1,
+		CompareOp:    vk.CompareOpNever,
+		MaxLod:       vk.LodClampNone,
+	}
+}
+
+// AnisotropicSamplerDesc returns TrilinearSamplerDesc with anisotropic
+// filtering of up to n samples. The sampler cache limits n to the
+// maxSamplerAnisotropy of the device, and falls back to trilinear filtering
+// where samplerAnisotropy is not enabled.
+func AnisotropicSamplerDesc(n float32) SamplerDesc {
+	desc := TrilinearSamplerDesc()
+	if n > 1 {
+		desc.Anisotropy = n
+	}
+	return desc
+}
+
+// samplerCache creates the samplers of a device, one for each distinct
+// description. The samplers live as long as the cache.
+type samplerCache struct {
+	dev      vk.Device
+	samplers map[SamplerDesc]vk.Sampler
+
+	anisotropy    bool
+	maxAnisotropy float32
+	// noAnisotropy is why anisotropic filtering is unavailable,
+	// logged the first time it is asked for
+	noAnisotropy string
+}
+
+// newSamplerCache returns the sampler cache of dev, created on gpu
+// with the optional features enabled.
+func newSamplerCache(dev vk.Device, gpu vk.PhysicalDevice,
+	features vk.PhysicalDeviceFeatures) *samplerCache {
+
+	var props vk.PhysicalDeviceProperties
+	vk.GetPhysicalDeviceProperties(gpu, &props)
+	props.Deref()
+	props.Limits.Deref()
+	c := &samplerCache{
+		dev:           dev,
+		samplers:      make(map[SamplerDesc]vk.Sampler),
+		anisotropy:    features.SamplerAnisotropy == vk.True,
+		maxAnisotropy: props.Limits.MaxSamplerAnisotropy,
+	}
+	if !c.anisotropy {
+		var supported vk.PhysicalDeviceFeatures
+		vk.GetPhysicalDeviceFeatures(gpu, &supported)
+		supported.Deref()
+		c.noAnisotropy = "the device does not support samplerAnisotropy"
+		if supported.SamplerAnisotropy == vk.True {
+			// the asche context creates the device without optional features
+			c.noAnisotropy = "samplerAnisotropy is not enabled on the device, the asche context " +
+				"enables no optional features, render to a Window instead"
+		}
+	}
+	return c
+}
+
+// get returns the sampler of desc, creating it on first use.
+func (c *samplerCache) get(desc SamplerDesc) vk.Sampler {
+	desc = c.supported(desc)
+	if sampler, ok := c.samplers[desc]; ok {
+		return sampler
+	}
+	info := vk.SamplerCreateInfo{
+		SType:                   vk.StructureTypeSamplerCreateInfo,
+		MagFilter:               desc.MagFilter,
+		MinFilter:               desc.MinFilter,
+		MipmapMode:              desc.MipmapMode,
+		AddressModeU:            desc.AddressModeU,
+		AddressModeV:            desc.AddressModeV,
+		AddressModeW:            desc.AddressModeW,
+		AnisotropyEnable:        vk.False,
+		MaxAnisotropy:           1,
+		CompareEnable:           vk.False,
+		CompareOp:               desc.CompareOp,
+		MinLod:                  desc.MinLod,
+		MaxLod:                  desc.MaxLod,
+		BorderColor:             desc.BorderColor,
+		UnnormalizedCoordinates: vk.False,
+	}
+	if desc.Anisotropy > 1 {
+		info.AnisotropyEnable = vk.True
+		info.MaxAnisotropy = desc.Anisotropy
+	}
+	if desc.CompareEnable {
+		info.CompareEnable = vk.True
+	}
+	var sampler vk.Sampler
+	ret := vk.CreateSampler(c.dev, &info, nil, &sampler)
+	orPanic(as.NewError(ret))
+	c.samplers[desc] = sampler
+	return sampler
+}
+
+// supported returns desc with the anisotropy limited to what the device
+// supports, so descriptions that end up equal share their sampler.
+func (c *samplerCache) supported(desc SamplerDesc) SamplerDesc {
+	if desc.Anisotropy <= 1 {
+		desc.Anisotropy = 1
+		return desc
+	}
+	if !c.anisotropy {
+		if c.noAnisotropy != "" {
+			log.Printf("vulkan warn: anisotropic filtering unavailable, %s", c.noAnisotropy)
+			c.noAnisotropy = ""
+		}
+		desc.Anisotropy = 1
+	} else if desc.Anisotropy > c.maxAnisotropy {
+		log.Printf("vulkan warn: anisotropy %v limited to maxSamplerAnisotropy %v",
+			desc.Anisotropy, c.maxAnisotropy)
+		desc.Anisotropy = c.maxAnisotropy
+	}
+	return desc
+}
+
+func (c *samplerCache) destroy() {
+	for desc, sampler := range c.samplers {
+		vk.DestroySampler(c.dev, sampler, nil)
+		delete(c.samplers, desc)
+	}
+}
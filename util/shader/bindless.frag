@@ -0,0 +1,12 @@
+#version 450
+#extension GL_ARB_separate_shader_objects : enable
+#extension GL_ARB_shading_language_420pack : enable
+layout (set = 1, binding = 0) uniform sampler2D textures[4096];
+
+layout (location = 0) in vec4 texcoord;
+layout (location = 1) in vec4 color;
+layout (location = 2) flat in int texIndex;
+layout (location = 0) out vec4 uFragColor;
+void main() {
+    uFragColor = texture(textures[texIndex], texcoord.xy) * color;
+}
\ No newline at end of file
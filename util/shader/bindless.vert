@@ -0,0 +1,30 @@
+#version 450
+#extension GL_ARB_separate_shader_objects : enable
+#extension GL_ARB_shading_language_420pack : enable
+layout(std140, binding = 0) uniform buf {
+    mat4 MVP;
+} ubuf;
+layout(push_constant) uniform PushConstants {
+    mat4 model;
+} pc;
+
+layout (location = 0) in vec3 inPosition;
+layout (location = 2) in vec2 inUV;
+layout (location = 3) in vec4 inColor;
+
+layout (location = 0) out vec4 texcoord;
+layout (location = 1) out vec4 color;
+// the texture ID is passed as the first instance of the draw
+layout (location = 2) flat out int texIndex;
+
+out gl_PerVertex {
+    vec4 gl_Position;
+};
+
+void main()
+{
+    texcoord = vec4(inUV, 0.0, 0.0);
+    color = inColor;
+    texIndex = gl_InstanceIndex;
+    gl_Position = ubuf.MVP * pc.model * vec4(inPosition, 1.0);
+}
\ No newline at end of file
@@ -1,10 +1,11 @@
 #version 450
 #extension GL_ARB_separate_shader_objects : enable
 #extension GL_ARB_shading_language_420pack : enable
-layout (binding = 1) uniform sampler2D tex;
+layout (set = 1, binding = 0) uniform sampler2D tex;
 
 layout (location = 0) in vec4 texcoord;
+layout (location = 1) in vec4 color;
 layout (location = 0) out vec4 uFragColor;
 void main() {
-    uFragColor = texture(tex, texcoord.xy);
+    uFragColor = texture(tex, texcoord.xy) * color;
 }
\ No newline at end of file
@@ -3,11 +3,17 @@
 #extension GL_ARB_shading_language_420pack : enable
 layout(std140, binding = 0) uniform buf {
     mat4 MVP;
-    vec4 position[12*3];
-    vec4 attr[12*3];
 } ubuf;
+layout(push_constant) uniform PushConstants {
+    mat4 model;
+} pc;
+
+layout (location = 0) in vec3 inPosition;
+layout (location = 2) in vec2 inUV;
+layout (location = 3) in vec4 inColor;
 
 layout (location = 0) out vec4 texcoord;
+layout (location = 1) out vec4 color;
 
 out gl_PerVertex {
     vec4 gl_Position;
@@ -15,6 +21,7 @@ out gl_PerVertex {
 
 void main()
 {
-    texcoord = ubuf.attr[gl_VertexIndex];
-    gl_Position = ubuf.MVP * ubuf.position[gl_VertexIndex];
+    texcoord = vec4(inUV, 0.0, 0.0);
+    color = inColor;
+    gl_Position = ubuf.MVP * pc.model * vec4(inPosition, 1.0);
 }
\ No newline at end of file
@@ -0,0 +1,10 @@
+#version 450
+#extension GL_ARB_separate_shader_objects : enable
+#extension GL_ARB_shading_language_420pack : enable
+layout (set = 1, binding = 0) uniform samplerCube sky;
+
+layout (location = 0) in vec3 direction;
+layout (location = 0) out vec4 uFragColor;
+void main() {
+    uFragColor = texture(sky, direction);
+}
\ No newline at end of file
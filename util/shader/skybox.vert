@@ -0,0 +1,22 @@
+#version 450
+#extension GL_ARB_separate_shader_objects : enable
+#extension GL_ARB_shading_language_420pack : enable
+layout(std140, binding = 0) uniform buf {
+    mat4 MVP;
+    mat4 skyInvViewProj;
+} ubuf;
+
+layout (location = 0) out vec3 direction;
+
+out gl_PerVertex {
+    vec4 gl_Position;
+};
+
+// a triangle covering the viewport at the far plane, drawn without vertex buffers
+void main()
+{
+    vec4 pos = vec4(float((gl_VertexIndex << 1) & 2) * 2.0 - 1.0,
+                    float(gl_VertexIndex & 2) * 2.0 - 1.0, 1.0, 1.0);
+    direction = (ubuf.skyInvViewProj * pos).xyz;
+    gl_Position = pos;
+}
\ No newline at end of file
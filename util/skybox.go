@@ -0,0 +1,85 @@
+package util
+
+import (
+	as "github.com/vulkan-go/asche"
+	vk "github.com/vulkan-go/vulkan"
+)
+
+// skybox is the cubemap drawn behind the scene, with the pipeline
+// drawing it at the far plane.
+type skybox struct {
+	ref      CubemapRef
+	tex      *Texture
+	pipeline vk.Pipeline
+}
+
+// SetSkybox sets the cubemap drawn behind the scene, replacing
+// the previous one.
+func (s *SpinningCube) SetSkybox(ref CubemapRef) (err error) {
+	defer checkErr(&err)
+
+	if s.skybox == nil {
+		s.skybox = &skybox{}
+	}
+	s.skybox.ref = ref
+	if !s.prepared {
+		return nil
+	}
+	ret := vk.DeviceWaitIdle(s.rc().Device())
+	orPanic(as.NewError(ret))
+	// the new cubemap is acquired first, so that setting the same one
+	// again takes it from the cache rather than loading it again
+	old := s.skybox.tex
+	s.prepareSkybox()
+	if old != nil {
+		s.textures.Release(old)
+	}
+	s.buildCommandBuffers()
+	return nil
+}
+
+func (s *SpinningCube) prepareSkybox() {
+	tex, err := s.textures.AcquireCubemap(s.skybox.ref)
+	orPanic(err)
+	s.skybox.tex = tex
+	if s.skybox.pipeline == nil {
+		s.skybox.pipeline = s.createSkyboxPipeline()
+	}
+}
+
+func (s *SpinningCube) destroySkybox() {
+	if s.skybox.tex != nil {
+		s.textures.Release(s.skybox.tex)
+		s.skybox.tex = nil
+	}
+	// the pipeline is destroyed with the other pipelines
+	s.skybox.pipeline = nil
+}
+
+// drawSkybox records the skybox into the render pass being recorded
+// into cmd, with the scene pipeline bound again afterwards.
+func (s *SpinningCube) drawSkybox(cmd vk.CommandBuffer) {
+	if s.skybox == nil || s.skybox.tex == nil {
+		return
+	}
+	vk.CmdBindPipeline(cmd, vk.PipelineBindPointGraphics, s.skybox.pipeline)
+	vk.CmdBindDescriptorSets(cmd, vk.PipelineBindPointGraphics, s.pipelineLayout,
+		1, 1, []vk.DescriptorSet{s.skybox.tex.set}, 0, nil)
+	vk.CmdDraw(cmd, 3, 1, 0, 0)
+	vk.CmdBindPipeline(cmd, vk.PipelineBindPointGraphics, s.pipeline)
+}
+
+// createSkyboxPipeline creates the pipeline of the skybox. It draws a
+// triangle covering the viewport without vertex buffers, at the far plane
+// so that it passes the depth test only where nothing has been drawn.
+func (s *SpinningCube) createSkyboxPipeline() vk.Pipeline {
+	// the depth is cleared to the far plane, the skybox is drawn
+	// there without writing it
+	return s.NewPipeline().
+		Shaders("./util/shader/skybox_vert.spv", "./util/shader/skybox_frag.spv").
+		Layout(s.pipelineLayout).
+		VertexInput(VertexInputNone).
+		Cull(vk.CullModeNone, vk.FrontFaceCounterClockwise).
+		Depth(true, false, vk.CompareOpLessOrEqual).
+		build()
+}
@@ -0,0 +1,66 @@
+package util
+
+import (
+	"log"
+
+	as "github.com/vulkan-go/asche"
+	vk "github.com/vulkan-go/vulkan"
+)
+
+// sRGB formats the swapchain is preferably created with, in order of preference.
+var surfaceSRGBFormats = []vk.Format{
+	vk.FormatB8g8r8a8Srgb,
+	vk.FormatR8g8b8a8Srgb,
+	vk.FormatA8b8g8r8SrgbPack32,
+}
+
+// SetColorSpace sets the color space ChooseSurfaceFormat looks for,
+// vk.ColorSpaceSrgbNonlinear by default.
+func (s *SpinningCube) SetColorSpace(colorSpace vk.ColorSpace) {
+	s.colorSpace = colorSpace
+}
+
+// ChooseSurfaceFormat returns the format the swapchain of surface is to be
+// created with: an sRGB format in the color space of s when the surface
+// supports one, so that blending and the shaders work on linear colors
+// and the presentation engine does the gamma encoding. Otherwise the first
+// format of the color space, or of the surface, is chosen.
+//
+// NewWindow calls it once the surface has been created, before the
+// swapchain is. The color space of the chosen format is kept in s.
+func (s *SpinningCube) ChooseSurfaceFormat(gpu vk.PhysicalDevice, surface vk.Surface) vk.Format {
+	var count uint32
+	ret := vk.GetPhysicalDeviceSurfaceFormats(gpu, surface, &count, nil)
+	orPanic(as.NewError(ret))
+	formats := make([]vk.SurfaceFormat, count)
+	ret = vk.GetPhysicalDeviceSurfaceFormats(gpu, surface, &count, formats)
+	orPanic(as.NewError(ret))
+	for i := range formats {
+		formats[i].Deref()
+	}
+
+	if len(formats) == 0 || len(formats) == 1 && formats[0].Format == vk.FormatUndefined {
+		// the surface takes any format
+		return surfaceSRGBFormats[0]
+	}
+	for _, f := range surfaceSRGBFormats {
+		for _, sf := range formats {
+			if sf.Format == f && sf.ColorSpace == s.colorSpace {
+				return f
+			}
+		}
+	}
+	for _, sf := range formats {
+		if sf.ColorSpace == s.colorSpace {
+			return sf.Format
+		}
+	}
+	log.Printf("vulkan warn: surface has no format in color space %d", s.colorSpace)
+	s.colorSpace = formats[0].ColorSpace
+	return formats[0].Format
+}
+
+// isSRGB reports whether the texels of format are sRGB encoded.
+func isSRGB(format vk.Format) bool {
+	return linearFormat(format) != format
+}
@@ -0,0 +1,74 @@
+package util
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	vk "github.com/vulkan-go/vulkan"
+)
+
+// TextureArrayRef names the images of the layers of a 2D array texture.
+type TextureArrayRef struct {
+	// Layers are the images of the layers, all of the same size and format.
+	Layers []TextureRef
+	// Sampler describes how the array is sampled,
+	// nil selects the default of the TextureManager.
+	Sampler *SamplerDesc
+}
+
+func (r TextureArrayRef) name() string {
+	names := make([]string, len(r.Layers))
+	for i, l := range r.Layers {
+		names[i] = l.Name
+	}
+	return strings.Join(names, "|")
+}
+
+// prepareTextureArray loads the layers of ref into a 2D array texture.
+func (s *SpinningCube) prepareTextureArray(ref TextureArrayRef) *Texture {
+	if len(ref.Layers) == 0 {
+		orPanic(errors.New("texture: array without layers"))
+	}
+	layers := make([]*TextureImage, len(ref.Layers))
+	for i, l := range ref.Layers {
+		layers[i] = s.loadTextureImage(l)
+	}
+	return s.prepareLayeredTexture(layers, vk.ImageViewType2dArray)
+}
+
+// prepareLayeredTexture uploads images into the layers of an optimal tiling
+// texture viewed as viewType, with the missing mip levels blitted or
+// computed on the CPU like those of 2D textures.
+func (s *SpinningCube) prepareLayeredTexture(images []*TextureImage, viewType vk.ImageViewType) *Texture {
+	base := images[0]
+	for _, img := range images[1:] {
+		if img.Format != base.Format || img.Width != base.Width || img.Height != base.Height ||
+			len(img.Levels) != len(base.Levels) {
+			orPanic(errors.New("texture: layers differ in format, size or mip levels"))
+		}
+	}
+
+	props := s.formatProperties(base.Format)
+	if props.OptimalTilingFeatures&vk.FormatFeatureFlags(vk.FormatFeatureSampledImageBit) == 0 {
+		orPanic(fmt.Errorf("vulkan: format %d not supported as layered texture format", base.Format))
+	}
+	levels := mipLevels(base.Width, base.Height)
+	usage := vk.ImageUsageTransferDstBit | vk.ImageUsageSampledBit
+	if isCompressed(base.Format) {
+		levels = uint32(len(base.Levels))
+	} else if len(base.Levels) < int(levels) {
+		if s.canBlitMipmaps(base.Format) {
+			usage |= vk.ImageUsageTransferSrcBit
+		} else {
+			for _, img := range images {
+				mipChain(img)
+			}
+		}
+	}
+	tex := s.prepareTextureImage(base, viewType, uint32(len(images)), levels, vk.ImageTilingOptimal,
+		usage, vk.MemoryPropertyDeviceLocalBit)
+	s.copyToImage(tex.image, images, tex.mipLevels, tex.imageLayout)
+	s.prepareTextureView(tex, base.Format)
+	return tex
+}
@@ -0,0 +1,287 @@
+package util
+
+import (
+	"errors"
+
+	as "github.com/vulkan-go/asche"
+	vk "github.com/vulkan-go/vulkan"
+)
+
+// DefaultTexture is the name of the texture mesh groups without
+// a diffuse map are drawn with.
+const DefaultTexture = "default"
+
+// texturePoolSize is the number of descriptor sets in each
+// of the pools the texture descriptor sets come from.
+const texturePoolSize = 64
+
+// TextureManager loads textures by name on demand and shares them between
+// their users. Textures are reference counted: every Get or Acquire must be
+// paired with a Release, which destroys the texture once its last user has
+// released it.
+//
+// Each texture comes with the descriptor set it is sampled through by the
+// pipeline (set 1). The sets are allocated from pools that are added as
+// more textures are loaded. On devices with descriptor indexing the 2D
+// textures are also kept in a texture table the scene pipeline indexes
+// by texture ID instead. A texture is loaded once for each sampler
+// description it is used with, and once as color and as data texture.
+type TextureManager struct {
+	sources  map[string]TextureRef
+	textures map[textureKey]*Texture
+	sampler  SamplerDesc
+
+	dev       vk.Device
+	load      func(ref TextureRef) *Texture
+	loadCube  func(ref CubemapRef) *Texture
+	loadArray func(ref TextureArrayRef) *Texture
+	samplers  *samplerCache
+	layout    vk.DescriptorSetLayout
+	pools     []*texturePool
+	bindless  *bindlessTable
+}
+
+type textureKey struct {
+	name     string
+	sampler  SamplerDesc
+	linear   bool
+	viewType vk.ImageViewType
+}
+
+type texturePool struct {
+	pool vk.DescriptorPool
+	free uint32
+}
+
+// NewTextureManager returns a TextureManager with DefaultTexture
+// registered as ./util/textures/green.png.
+func NewTextureManager() *TextureManager {
+	m := &TextureManager{
+		sources:  make(map[string]TextureRef),
+		textures: make(map[textureKey]*Texture),
+		sampler:  DefaultSamplerDesc(),
+	}
+	m.Register(DefaultTexture, TextureRef{Name: "./util/textures/green.png"})
+	return m
+}
+
+// Register makes the texture of ref available as name. Registering a name
+// that is already loaded only affects loads after it has been released.
+func (m *TextureManager) Register(name string, ref TextureRef) {
+	m.sources[name] = ref
+}
+
+// Get returns the texture registered as name, or loaded from the file name
+// when nothing is registered as name, and adds a reference to it.
+func (m *TextureManager) Get(name string) (*Texture, error) {
+	ref, ok := m.sources[name]
+	if !ok {
+		ref = TextureRef{Name: name}
+	}
+	return m.acquire(name, ref)
+}
+
+// Acquire returns the texture of ref, loading it on first use,
+// and adds a reference to it.
+func (m *TextureManager) Acquire(ref TextureRef) (*Texture, error) {
+	return m.acquire(ref.Name, ref)
+}
+
+// AcquireCubemap returns the cube texture of ref, loading it on first use,
+// and adds a reference to it.
+func (m *TextureManager) AcquireCubemap(ref CubemapRef) (*Texture, error) {
+	key := textureKey{name: ref.name(), sampler: m.sampler, viewType: vk.ImageViewTypeCube}
+	if ref.Sampler != nil {
+		key.sampler = *ref.Sampler
+	}
+	return m.acquireKey(key, func() *Texture {
+		return m.loadCube(ref)
+	})
+}
+
+// AcquireArray returns the 2D array texture of ref, loading it on first use,
+// and adds a reference to it.
+func (m *TextureManager) AcquireArray(ref TextureArrayRef) (*Texture, error) {
+	key := textureKey{name: ref.name(), sampler: m.sampler, viewType: vk.ImageViewType2dArray}
+	if ref.Sampler != nil {
+		key.sampler = *ref.Sampler
+	}
+	return m.acquireKey(key, func() *Texture {
+		return m.loadArray(ref)
+	})
+}
+
+func (m *TextureManager) acquire(name string, ref TextureRef) (*Texture, error) {
+	key := textureKey{name: name, sampler: m.sampler, linear: ref.Linear, viewType: vk.ImageViewType2d}
+	if ref.Sampler != nil {
+		key.sampler = *ref.Sampler
+	}
+	return m.acquireKey(key, func() *Texture {
+		return m.load(ref)
+	})
+}
+
+func (m *TextureManager) acquireKey(key textureKey, load func() *Texture) (tex *Texture, err error) {
+	defer checkErr(&err)
+
+	if tex, ok := m.textures[key]; ok {
+		tex.refs++
+		return tex, nil
+	}
+	if m.load == nil {
+		return nil, errors.New("texture: no device, the context is not prepared")
+	}
+	tex = load()
+	tex.key = key
+	tex.refs = 1
+	tex.sampler = m.samplers.get(key.sampler)
+	m.allocDescriptorSet(tex)
+	m.textures[key] = tex
+	return tex, nil
+}
+
+// Release drops a reference to tex and destroys it with the last one.
+// The GPU must be done with the texture when the last reference is dropped.
+func (m *TextureManager) Release(tex *Texture) {
+	tex.refs--
+	if tex.refs > 0 {
+		return
+	}
+	delete(m.textures, tex.key)
+	m.destroyTexture(tex)
+}
+
+// SetDefaultSampler sets the sampler description of the textures loaded
+// from now on without one of their own.
+func (m *TextureManager) SetDefaultSampler(desc SamplerDesc) {
+	m.sampler = desc
+}
+
+// Len returns the number of loaded textures.
+func (m *TextureManager) Len() int {
+	return len(m.textures)
+}
+
+func (m *TextureManager) prepare(dev vk.Device, samplers *samplerCache, s *SpinningCube) {
+	m.dev = dev
+	m.load = s.loadTexture
+	m.loadCube = s.loadCubemap
+	m.loadArray = s.loadTextureArray
+	m.samplers = samplers
+	if s.rc().DescriptorIndexing() {
+		m.bindless = newBindlessTable(dev)
+	}
+
+	var layout vk.DescriptorSetLayout
+	ret := vk.CreateDescriptorSetLayout(dev, &vk.DescriptorSetLayoutCreateInfo{
+		SType:        vk.StructureTypeDescriptorSetLayoutCreateInfo,
+		BindingCount: 1,
+		PBindings: []vk.DescriptorSetLayoutBinding{
+			{
+				Binding:         0,
+				DescriptorType:  vk.DescriptorTypeCombinedImageSampler,
+				DescriptorCount: 1,
+				StageFlags:      vk.ShaderStageFlags(vk.ShaderStageFragmentBit),
+			}},
+	}, nil, &layout)
+	orPanic(as.NewError(ret))
+	m.layout = layout
+}
+
+func (m *TextureManager) allocDescriptorSet(tex *Texture) {
+	var pool *texturePool
+	for _, p := range m.pools {
+		if p.free > 0 {
+			pool = p
+			break
+		}
+	}
+	if pool == nil {
+		pool = m.newPool()
+	}
+
+	var set vk.DescriptorSet
+	ret := vk.AllocateDescriptorSets(m.dev, &vk.DescriptorSetAllocateInfo{
+		SType:              vk.StructureTypeDescriptorSetAllocateInfo,
+		DescriptorPool:     pool.pool,
+		DescriptorSetCount: 1,
+		PSetLayouts:        []vk.DescriptorSetLayout{m.layout},
+	}, &set)
+	orPanic(as.NewError(ret))
+	pool.free--
+
+	vk.UpdateDescriptorSets(m.dev, 1, []vk.WriteDescriptorSet{{
+		SType:           vk.StructureTypeWriteDescriptorSet,
+		DstSet:          set,
+		DescriptorCount: 1,
+		DescriptorType:  vk.DescriptorTypeCombinedImageSampler,
+		PImageInfo: []vk.DescriptorImageInfo{{
+			Sampler:     tex.sampler,
+			ImageView:   tex.view,
+			ImageLayout: tex.imageLayout,
+		}},
+	}}, 0, nil)
+	tex.set = set
+	tex.pool = pool
+	if m.bindless != nil && tex.viewType == vk.ImageViewType2d {
+		m.bindless.add(m.dev, tex)
+	}
+}
+
+func (m *TextureManager) newPool() *texturePool {
+	var descPool vk.DescriptorPool
+	ret := vk.CreateDescriptorPool(m.dev, &vk.DescriptorPoolCreateInfo{
+		SType:         vk.StructureTypeDescriptorPoolCreateInfo,
+		Flags:         vk.DescriptorPoolCreateFlags(vk.DescriptorPoolCreateFreeDescriptorSetBit),
+		MaxSets:       texturePoolSize,
+		PoolSizeCount: 1,
+		PPoolSizes: []vk.DescriptorPoolSize{{
+			Type:            vk.DescriptorTypeCombinedImageSampler,
+			DescriptorCount: texturePoolSize,
+		}},
+	}, nil, &descPool)
+	orPanic(as.NewError(ret))
+	pool := &texturePool{
+		pool: descPool,
+		free: texturePoolSize,
+	}
+	m.pools = append(m.pools, pool)
+	return pool
+}
+
+func (m *TextureManager) destroyTexture(tex *Texture) {
+	if tex.bindless {
+		m.bindless.remove(tex)
+	}
+	vk.FreeDescriptorSets(m.dev, tex.pool.pool, 1, &tex.set)
+	tex.pool.free++
+	tex.Destroy(m.dev)
+}
+
+// destroy destroys every texture still loaded, along with the descriptor
+// pools, layout and samplers. Textures can be loaded again once prepare has been called.
+func (m *TextureManager) destroy() {
+	for key, tex := range m.textures {
+		m.destroyTexture(tex)
+		delete(m.textures, key)
+	}
+	for _, p := range m.pools {
+		vk.DestroyDescriptorPool(m.dev, p.pool, nil)
+	}
+	m.pools = nil
+	if m.bindless != nil {
+		m.bindless.destroy(m.dev)
+		m.bindless = nil
+	}
+	vk.DestroyDescriptorSetLayout(m.dev, m.layout, nil)
+	m.samplers.destroy()
+	m.load = nil
+	m.loadCube = nil
+	m.loadArray = nil
+}
+
+// Textures returns the texture manager of s, for registering
+// and loading application textures.
+func (s *SpinningCube) Textures() *TextureManager {
+	return s.textures
+}
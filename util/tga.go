@@ -0,0 +1,203 @@
+package util
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	vk "github.com/vulkan-go/vulkan"
+)
+
+// TGA image types
+const (
+	tgaColorMapped    = 1
+	tgaTrueColor      = 2
+	tgaGrayscale      = 3
+	tgaRLEColorMapped = 9
+	tgaRLETrueColor   = 10
+	tgaRLEGrayscale   = 11
+)
+
+// decodeTGA decodes a Truevision TGA image, uncompressed or run-length
+// encoded, with color-mapped, true-color or grayscale pixels, into
+// R8G8B8A8_SRGB texels.
+func decodeTGA(data []byte) (*TextureImage, error) {
+	if len(data) < 18 {
+		return nil, errors.New("truncated header")
+	}
+	idLen := int(data[0])
+	cmapType := data[1]
+	imageType := data[2]
+	cmapStart := int(binary.LittleEndian.Uint16(data[3:]))
+	cmapLen := int(binary.LittleEndian.Uint16(data[5:]))
+	cmapDepth := int(data[7])
+	width := int(binary.LittleEndian.Uint16(data[12:]))
+	height := int(binary.LittleEndian.Uint16(data[14:]))
+	depth := int(data[16])
+	desc := data[17]
+	alphaBits := desc & 0x0f
+	if width == 0 || height == 0 {
+		return nil, errors.New("empty image")
+	}
+	r := &tgaReader{data: data, pos: 18 + idLen}
+
+	var cmap [][4]byte
+	if cmapType == 1 {
+		read, err := tgaPixelReader(cmapDepth, alphaBits)
+		if err != nil {
+			return nil, fmt.Errorf("color map: %v", err)
+		}
+		size := (cmapDepth + 7) / 8
+		cmap = make([][4]byte, cmapLen)
+		for i := range cmap {
+			entry, err := r.next(size)
+			if err != nil {
+				return nil, err
+			}
+			cmap[i] = read(entry)
+		}
+	}
+
+	var read func([]byte) [4]byte
+	switch imageType {
+	case tgaColorMapped, tgaRLEColorMapped:
+		if cmap == nil || depth != 8 && depth != 16 {
+			return nil, fmt.Errorf("unsupported color-mapped image of depth %d", depth)
+		}
+		read = func(b []byte) [4]byte {
+			i := int(b[0])
+			if len(b) > 1 {
+				i |= int(b[1]) << 8
+			}
+			i -= cmapStart
+			if i < 0 || i >= len(cmap) {
+				return [4]byte{0, 0, 0, 255}
+			}
+			return cmap[i]
+		}
+	case tgaTrueColor, tgaRLETrueColor:
+		var err error
+		if read, err = tgaPixelReader(depth, alphaBits); err != nil {
+			return nil, err
+		}
+	case tgaGrayscale, tgaRLEGrayscale:
+		switch depth {
+		case 8:
+			read = func(b []byte) [4]byte {
+				return [4]byte{b[0], b[0], b[0], 255}
+			}
+		case 16:
+			read = func(b []byte) [4]byte {
+				return [4]byte{b[0], b[0], b[0], b[1]}
+			}
+		default:
+			return nil, fmt.Errorf("unsupported grayscale depth %d", depth)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported image type %d", imageType)
+	}
+	rle := imageType >= tgaRLEColorMapped
+
+	pixelSize := (depth + 7) / 8
+	texels := make([]byte, 4*width*height)
+	var run, raw int
+	var pixel [4]byte
+	for i := 0; i < width*height; i++ {
+		if rle && run == 0 && raw == 0 {
+			h, err := r.next(1)
+			if err != nil {
+				return nil, err
+			}
+			n := int(h[0]&0x7f) + 1
+			if h[0]&0x80 != 0 {
+				b, err := r.next(pixelSize)
+				if err != nil {
+					return nil, err
+				}
+				pixel = read(b)
+				run = n
+			} else {
+				raw = n
+			}
+		}
+		if run > 0 {
+			run--
+		} else {
+			if raw > 0 {
+				raw--
+			}
+			b, err := r.next(pixelSize)
+			if err != nil {
+				return nil, err
+			}
+			pixel = read(b)
+		}
+
+		// pixels are stored bottom-up and left to right unless
+		// the descriptor says otherwise
+		x, y := i%width, i/width
+		if desc&0x10 != 0 {
+			x = width - 1 - x
+		}
+		if desc&0x20 == 0 {
+			y = height - 1 - y
+		}
+		copy(texels[4*(y*width+x):], pixel[:])
+	}
+	return &TextureImage{
+		Format: vk.FormatR8g8b8a8Srgb,
+		Width:  width,
+		Height: height,
+		Levels: [][]byte{texels},
+	}, nil
+}
+
+// tgaPixelReader returns the conversion of BGR(A) stored pixels of depth
+// bits into RGBA. Alpha is only read with alphaBits set, as many writers
+// leave it zero otherwise.
+func tgaPixelReader(depth int, alphaBits byte) (func([]byte) [4]byte, error) {
+	switch depth {
+	case 15, 16:
+		return func(b []byte) [4]byte {
+			v := uint16(b[0]) | uint16(b[1])<<8
+			a := byte(255)
+			if depth == 16 && alphaBits > 0 && v&0x8000 == 0 {
+				a = 0
+			}
+			return [4]byte{expand5(v >> 10), expand5(v >> 5), expand5(v), a}
+		}, nil
+	case 24:
+		return func(b []byte) [4]byte {
+			return [4]byte{b[2], b[1], b[0], 255}
+		}, nil
+	case 32:
+		return func(b []byte) [4]byte {
+			a := b[3]
+			if alphaBits == 0 {
+				a = 255
+			}
+			return [4]byte{b[2], b[1], b[0], a}
+		}, nil
+	}
+	return nil, fmt.Errorf("unsupported pixel depth %d", depth)
+}
+
+// expand5 scales the 5-bit value in the low bits of v to 8 bits.
+func expand5(v uint16) byte {
+	c := byte(v & 0x1f)
+	return c<<3 | c>>2
+}
+
+type tgaReader struct {
+	data []byte
+	pos  int
+}
+
+func (r *tgaReader) next(n int) ([]byte, error) {
+	if r.pos+n > len(r.data) {
+		return nil, errors.New("truncated image data")
+	}
+	b := r.data[r.pos : r.pos+n]
+	r.pos += n
+	return b, nil
+}
@@ -0,0 +1,106 @@
+package util
+
+import (
+	"bytes"
+	"testing"
+)
+
+// tgaHeader returns the 18 byte header of a TGA image without a color map.
+func tgaHeader(imageType byte, width, height int, depth, desc byte) []byte {
+	return []byte{
+		0, 0, imageType,
+		0, 0, 0, 0, 0,
+		0, 0, 0, 0,
+		byte(width), byte(width >> 8), byte(height), byte(height >> 8),
+		depth, desc,
+	}
+}
+
+func checkTexels(t *testing.T, img *TextureImage, want [][4]byte) {
+	t.Helper()
+	if len(img.Levels) != 1 || len(img.Levels[0]) != 4*len(want) {
+		t.Fatalf("%d levels, base of %d bytes, want one of %d",
+			len(img.Levels), len(img.Levels[0]), 4*len(want))
+	}
+	for i, w := range want {
+		if got := img.Levels[0][4*i : 4*i+4]; !bytes.Equal(got, w[:]) {
+			t.Errorf("texel (%d,%d) is %v, want %v", i%img.Width, i/img.Width, got, w)
+		}
+	}
+}
+
+var (
+	tgaRed   = [4]byte{255, 0, 0, 255}
+	tgaGreen = [4]byte{0, 255, 0, 255}
+	tgaBlue  = [4]byte{0, 0, 255, 255}
+	tgaWhite = [4]byte{255, 255, 255, 255}
+)
+
+func TestDecodeTGARLEBottomUp(t *testing.T) {
+	data := tgaHeader(tgaRLETrueColor, 3, 2, 24, 0)
+	data = append(data,
+		// a run of 4 red pixels continues into the second row
+		0x83, 0, 0, 255,
+		// 2 raw pixels, green and white, stored as BGR
+		0x01, 0, 255, 0, 255, 255, 255,
+	)
+	img, err := decodeTGA(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img.Width != 3 || img.Height != 2 {
+		t.Fatalf("size %dx%d, want 3x2", img.Width, img.Height)
+	}
+	// the first stored row is the bottom one
+	checkTexels(t, img, [][4]byte{
+		tgaRed, tgaGreen, tgaWhite,
+		tgaRed, tgaRed, tgaRed,
+	})
+}
+
+func TestDecodeTGATopDownAlpha(t *testing.T) {
+	// 8 alpha bits, top-left origin
+	data := tgaHeader(tgaTrueColor, 2, 2, 32, 0x28)
+	data = append(data,
+		0, 0, 255, 255, 0, 255, 0, 128,
+		255, 0, 0, 0, 255, 255, 255, 255,
+	)
+	img, err := decodeTGA(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkTexels(t, img, [][4]byte{
+		tgaRed, {0, 255, 0, 128},
+		{0, 0, 255, 0}, tgaWhite,
+	})
+}
+
+func TestDecodeTGARLEGrayscaleRightToLeft(t *testing.T) {
+	// bottom-right origin
+	data := tgaHeader(tgaRLEGrayscale, 2, 2, 8, 0x10)
+	data = append(data, 0x00, 10, 0x82, 20)
+	img, err := decodeTGA(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkTexels(t, img, [][4]byte{
+		{20, 20, 20, 255}, {20, 20, 20, 255},
+		{20, 20, 20, 255}, {10, 10, 10, 255},
+	})
+}
+
+func TestDecodeTGATruncated(t *testing.T) {
+	data := tgaHeader(tgaRLETrueColor, 3, 2, 24, 0)
+	data = append(data, 0x83, 0, 0, 255, 0x01, 0, 255, 0, 255, 255, 255)
+	for _, n := range []int{0, 17, 18, 20, 22, len(data) - 1} {
+		if _, err := decodeTGA(data[:n]); err == nil {
+			t.Errorf("TGA truncated to %d of %d bytes decoded", n, len(data))
+		}
+	}
+	if _, err := decodeTGA(tgaHeader(tgaTrueColor, 0, 2, 24, 0)); err == nil {
+		t.Error("TGA without pixels decoded")
+	}
+	if _, err := decodeTGA(tgaHeader(tgaTrueColor, 1, 1, 12, 0)); err == nil {
+		t.Error("TGA of depth 12 decoded")
+	}
+}
@@ -0,0 +1,124 @@
+package util
+
+import (
+	"errors"
+	"fmt"
+
+	vk "github.com/vulkan-go/vulkan"
+)
+
+// uniformRegionSize is the size of the region of the uniform ring each
+// frame writes its uniforms to.
+const uniformRegionSize = 64 << 10
+
+// uniformRing is the uniform buffer of the scene, host-coherent and mapped
+// for as long as it lives. It is split into a region per frame in flight,
+// each frame writes its own region while the GPU reads the others.
+//
+// Draws bind their uniforms with a dynamic offset into the region of the
+// frame, so any number of draws can have uniforms of their own without
+// a buffer or descriptor set each.
+//
+// Command buffers replayed for many frames read the ranges reserved as slots
+// while recording them, each frame the slots of its region are filled anew
+// from their update functions. Frames recorded anew write their uniforms as
+// the slots are reserved.
+type uniformRing struct {
+	buffer  vk.Buffer
+	mem     *memoryAllocation
+	align   vk.DeviceSize
+	regions []uniformRegion
+}
+
+type uniformRegion struct {
+	used  vk.DeviceSize
+	slots []uniformSlot
+}
+
+// uniformSlot is a range of a region written with the data returned by
+// update every frame.
+type uniformSlot struct {
+	offset vk.DeviceSize
+	update func() []byte
+}
+
+func (s *SpinningCube) newUniformRing(regions int) *uniformRing {
+	rc := s.rc()
+	var props vk.PhysicalDeviceProperties
+	vk.GetPhysicalDeviceProperties(rc.PhysicalDevice(), &props)
+	props.Deref()
+	props.Limits.Deref()
+
+	r := &uniformRing{
+		align:   props.Limits.MinUniformBufferOffsetAlignment,
+		regions: make([]uniformRegion, regions),
+	}
+	r.buffer, r.mem = s.createBuffer(regions*uniformRegionSize, vk.BufferUsageUniformBufferBit,
+		vk.MemoryPropertyHostVisibleBit|vk.MemoryPropertyHostCoherentBit, allocFreeList)
+	if r.mem.data() == nil {
+		r.destroy(rc.Device())
+		orPanic(errors.New("vulkan: uniform buffer memory is not mapped"))
+	}
+	return r
+}
+
+// scratch returns the region past those of the frames in flight, written
+// by one-off draws such as captures while no frame is in flight.
+func (r *uniformRing) scratch() int {
+	return len(r.regions) - 1
+}
+
+// reset releases the slots of region, before command buffers
+// using it are recorded again.
+func (r *uniformRing) reset(region int) {
+	r.regions[region] = uniformRegion{}
+}
+
+// slot reserves size bytes of region for the uniforms returned by update,
+// writing them right away, and returns the dynamic offset they are bound with.
+func (r *uniformRing) slot(region, size int, update func() []byte) uint32 {
+	reg := &r.regions[region]
+	offset := alignSize(reg.used, r.align)
+	if offset+vk.DeviceSize(size) > uniformRegionSize {
+		orPanic(fmt.Errorf("vulkan: uniform region of %d bytes full", uniformRegionSize))
+	}
+	reg.used = offset + vk.DeviceSize(size)
+	offset += vk.DeviceSize(region * uniformRegionSize)
+	reg.slots = append(reg.slots, uniformSlot{
+		offset: offset,
+		update: update,
+	})
+	copy(r.mem.data()[offset:], update())
+	return uint32(offset)
+}
+
+// update writes the uniforms of the slots of region.
+func (r *uniformRing) update(region int) {
+	data := r.mem.data()
+	for _, slot := range r.regions[region].slots {
+		copy(data[slot.offset:], slot.update())
+	}
+}
+
+func (r *uniformRing) destroy(dev vk.Device) {
+	vk.DestroyBuffer(dev, r.buffer, nil)
+	r.mem.free()
+}
+
+// prepareUniforms creates the uniform ring with a region per
+// frame in flight and the scratch region.
+func (s *SpinningCube) prepareUniforms() {
+	s.uniforms = s.newUniformRing(s.rc().FramesInFlight() + 1)
+}
+
+// bindUniforms binds set, with the uniforms of the scene in a slot
+// of region, as set 0 of layout.
+func (s *SpinningCube) bindUniforms(cmd vk.CommandBuffer, layout vk.PipelineLayout,
+	set vk.DescriptorSet, region int) {
+
+	offset := s.uniforms.slot(region, vkTexCubeUniformSize, func() []byte {
+		return s.uniformData().Data()
+	})
+	vk.CmdBindDescriptorSets(cmd, vk.PipelineBindPointGraphics, layout,
+		0, 1, []vk.DescriptorSet{set}, 1, []uint32{offset})
+}
@@ -0,0 +1,217 @@
+package util
+
+import (
+	"errors"
+
+	as "github.com/vulkan-go/asche"
+	vk "github.com/vulkan-go/vulkan"
+)
+
+// stagingBufferSize is the minimum size of the staging buffers upload data
+// is written to. Larger uploads get a staging buffer of their own.
+const stagingBufferSize = 4 << 20
+
+// uploader hands out space in host-visible staging buffers, which copies
+// recorded into the setup command buffer read from. Uploads are batched:
+// all copies recorded into one setup command buffer share the staging
+// buffers, which are destroyed once it has executed.
+type uploader struct {
+	buffers []*stagingBuffer
+}
+
+type stagingBuffer struct {
+	buffer vk.Buffer
+	mem    *memoryAllocation
+	// data is the persistently mapped memory of buffer
+	data   []byte
+	offset int
+}
+
+// stage copies data into a staging buffer and returns the buffer
+// and the offset data was written at.
+func (u *uploader) stage(dev vk.Device, memory *memoryAllocator,
+	data []byte) (vk.Buffer, vk.DeviceSize) {
+
+	// buffer to image copies need offsets aligned to 4 and the texel
+	// or block size, 16 covers every format
+	var buf *stagingBuffer
+	var offset int
+	if n := len(u.buffers); n > 0 {
+		buf = u.buffers[n-1]
+		offset = (buf.offset + 15) &^ 15
+	}
+	if buf == nil || offset+len(data) > len(buf.data) {
+		size := stagingBufferSize
+		if len(data) > size {
+			size = len(data)
+		}
+		buf = newStagingBuffer(dev, memory, size)
+		u.buffers = append(u.buffers, buf)
+		offset = 0
+	}
+	copy(buf.data[offset:], data)
+	buf.offset = offset + len(data)
+	return buf.buffer, vk.DeviceSize(offset)
+}
+
+// finish ends the batch, the staging buffers are released with release
+// once the commands reading from them have completed.
+func (u *uploader) finish(dev vk.Device, release func(fn func())) {
+	for _, buf := range u.buffers {
+		buf := buf
+		release(func() {
+			buf.Destroy(dev)
+		})
+	}
+	u.buffers = nil
+}
+
+// newStagingBuffer creates a staging buffer of size bytes. Staging buffers
+// are freed together once their batch has executed, their memory is
+// allocated linearly.
+func newStagingBuffer(dev vk.Device, memory *memoryAllocator, size int) *stagingBuffer {
+	var buffer vk.Buffer
+	ret := vk.CreateBuffer(dev, &vk.BufferCreateInfo{
+		SType:       vk.StructureTypeBufferCreateInfo,
+		Size:        vk.DeviceSize(size),
+		Usage:       vk.BufferUsageFlags(vk.BufferUsageTransferSrcBit),
+		SharingMode: vk.SharingModeExclusive,
+	}, nil, &buffer)
+	orPanic(as.NewError(ret))
+
+	mem := memory.bindBuffer(buffer, vk.MemoryPropertyHostVisibleBit|vk.MemoryPropertyHostCoherentBit,
+		allocLinear)
+	if mem.data() == nil {
+		vk.DestroyBuffer(dev, buffer, nil)
+		mem.free()
+		orPanic(errors.New("vulkan: staging buffer memory is not mapped"))
+	}
+	return &stagingBuffer{
+		buffer: buffer,
+		mem:    mem,
+		data:   mem.data()[:size],
+	}
+}
+
+func (b *stagingBuffer) Destroy(dev vk.Device) {
+	vk.DestroyBuffer(dev, b.buffer, nil)
+	b.mem.free()
+}
+
+// Upload runs fn and submits the texture and mesh uploads it starts as
+// a single batch, waiting for their completion once.
+func (s *SpinningCube) Upload(fn func() error) (err error) {
+	defer checkErr(&err)
+
+	if !s.prepared {
+		return fn()
+	}
+	s.upload(func() {
+		orPanic(fn())
+	})
+	return nil
+}
+
+// setupCmd returns the command buffer setup work such as layout transitions
+// and copies is recorded into.
+func (s *SpinningCube) setupCmd() vk.CommandBuffer {
+	if s.uploadCmd == nil {
+		orPanic(errors.New("vulkan: command buffer not initialized"))
+	}
+	return s.uploadCmd
+}
+
+// upload runs fn with a setup command buffer to record into. Within
+// VulkanContextPrepare and nested uploads that is the current one, otherwise
+// a command buffer is recorded for fn and executed before upload returns.
+func (s *SpinningCube) upload(fn func()) {
+	if s.uploadCmd != nil {
+		fn()
+		return
+	}
+	rc := s.rc()
+	dev := rc.Device()
+	cmdPool := newCommandPool(dev, rc.GraphicsQueueFamilyIndex())
+	defer vk.DestroyCommandPool(dev, cmdPool, nil)
+	cmd := allocCommandBuffer(dev, cmdPool)
+	ret := vk.BeginCommandBuffer(cmd, &vk.CommandBufferBeginInfo{
+		SType: vk.StructureTypeCommandBufferBeginInfo,
+		Flags: vk.CommandBufferUsageFlags(vk.CommandBufferUsageOneTimeSubmitBit),
+	})
+	orPanic(as.NewError(ret))
+	s.uploadCmd = cmd
+	defer func() {
+		s.uploadCmd = nil
+	}()
+
+	fn()
+	s.uploads.finish(dev, s.deferDestroy)
+	ret = vk.EndCommandBuffer(cmd)
+	orPanic(as.NewError(ret))
+	submitAndWait(dev, rc.GraphicsQueue(), cmd)
+}
+
+// stage copies data into the staging buffers of the current upload batch.
+func (s *SpinningCube) stage(data []byte) (vk.Buffer, vk.DeviceSize) {
+	return s.uploads.stage(s.rc().Device(), s.memory, data)
+}
+
+// copyToImage records the copy of the levels of each image of layers into
+// the first mip levels of the matching array layer of dst, which must be in
+// vk.ImageLayoutUndefined. The layers must have the same number of levels.
+// The remaining levels up to mipLevels are blitted from the last one given.
+// All levels are left in layout.
+func (s *SpinningCube) copyToImage(dst vk.Image, layers []*TextureImage, mipLevels uint32,
+	layout vk.ImageLayout) {
+
+	cmd := s.setupCmd()
+	layerCount := uint32(len(layers))
+	imageBarrier(cmd, dst, 0, mipLevels, layerCount,
+		vk.ImageLayoutUndefined, vk.ImageLayoutTransferDstOptimal,
+		0, vk.AccessTransferWriteBit,
+		vk.PipelineStageTopOfPipeBit, vk.PipelineStageTransferBit)
+
+	var w, h int
+	given := uint32(0)
+	for layer, img := range layers {
+		levels := img.Levels
+		if len(levels) > int(mipLevels) {
+			levels = levels[:mipLevels]
+		}
+		given = uint32(len(levels))
+		w, h = img.Width, img.Height
+		for i, texels := range levels {
+			if i > 0 {
+				w, h = mipSize(w), mipSize(h)
+			}
+			buffer, offset := s.stage(texels)
+			vk.CmdCopyBufferToImage(cmd, buffer, dst, vk.ImageLayoutTransferDstOptimal,
+				1, []vk.BufferImageCopy{{
+					BufferOffset: offset,
+					ImageSubresource: vk.ImageSubresourceLayers{
+						AspectMask:     vk.ImageAspectFlags(vk.ImageAspectColorBit),
+						MipLevel:       uint32(i),
+						BaseArrayLayer: uint32(layer),
+						LayerCount:     1,
+					},
+					ImageExtent: vk.Extent3D{
+						Width:  uint32(w),
+						Height: uint32(h),
+						Depth:  1,
+					},
+				}})
+		}
+	}
+
+	if given < mipLevels {
+		s.generateMipmaps(dst, int32(w), int32(h), given-1, mipLevels, layerCount, layout)
+		// the blits leave the given levels but the last in layout
+		mipLevels = given - 1
+	}
+	if mipLevels > 0 {
+		imageBarrier(cmd, dst, 0, mipLevels, layerCount,
+			vk.ImageLayoutTransferDstOptimal, layout,
+			vk.AccessTransferWriteBit, vk.AccessShaderReadBit,
+			vk.PipelineStageTransferBit, vk.PipelineStageFragmentShaderBit)
+	}
+}
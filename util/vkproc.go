@@ -0,0 +1,52 @@
+package util
+
+/*
+#include <stdlib.h>
+
+typedef void (*vkVoidFunction)(void);
+typedef vkVoidFunction (*getInstanceProcAddrFunc)(void *instance, const char *name);
+typedef void (*physicalDeviceQueryFunc)(void *gpu, void *out);
+
+// vgo_vkGetInstanceProcAddr is the vkGetInstanceProcAddr of the instance
+// loaded by vk.InitInstance, which the binding doesn't export to Go.
+extern getInstanceProcAddrFunc vgo_vkGetInstanceProcAddr;
+
+static void *instanceProcAddr(void *instance, const char *name) {
+	if (vgo_vkGetInstanceProcAddr == NULL) {
+		return NULL;
+	}
+	return (void *)vgo_vkGetInstanceProcAddr(instance, name);
+}
+
+static void callPhysicalDeviceQuery(void *fn, void *gpu, void *out) {
+	((physicalDeviceQueryFunc)fn)(gpu, out);
+}
+*/
+import "C"
+
+import (
+	"unsafe"
+
+	vk "github.com/vulkan-go/vulkan"
+)
+
+// instanceProc returns the first of the functions names instance has, nil
+// when it has none of them. The Vulkan 1.1 functions the binding lacks are
+// loaded with it, vk.InitInstance must have been called on instance.
+func instanceProc(instance vk.Instance, names ...string) unsafe.Pointer {
+	for _, name := range names {
+		cname := C.CString(name)
+		fn := C.instanceProcAddr(unsafe.Pointer(instance), cname)
+		C.free(unsafe.Pointer(cname))
+		if fn != nil {
+			return fn
+		}
+	}
+	return nil
+}
+
+// callPhysicalDeviceQuery calls fn, a vkGetPhysicalDevice*2 function, with
+// gpu and out, the query structure with its pNext chain in C memory.
+func callPhysicalDeviceQuery(fn unsafe.Pointer, gpu vk.PhysicalDevice, out unsafe.Pointer) {
+	C.callPhysicalDeviceQuery(fn, unsafe.Pointer(gpu), out)
+}
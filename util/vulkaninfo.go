@@ -1,14 +1,9 @@
 package util
 
 import (
-	"bytes"
 	"errors"
-	"image"
-	"image/draw"
-	"image/png"
-	"io/ioutil"
+	"fmt"
 	"log"
-	"unsafe"
 
 	as "github.com/vulkan-go/asche"
 	vk "github.com/vulkan-go/vulkan"
@@ -21,12 +16,16 @@ func NewSpinningCube(spinAngle float32) *SpinningCube {
 		eyeVec:    &lin.Vec3{3.0, 0.0, 8.3},
 		originVec: &lin.Vec3{0.0, 0.0, 0.0},
 		upVec:     &lin.Vec3{0.0, 1.0, 0.0},
+		meshData:  []*MeshData{cubeMeshData()},
+		textures:  NewTextureManager(),
+
+		pipelineCacheDefault: true,
 	}
 
-	a.projectionMatrix.Perspective(lin.DegreesToRadians(45.0), 1.0, 0.1, 100.0)
+	// the projection matrix depends on the aspect ratio of the swapchain
+	// and is set up in VulkanContextPrepare.
 	a.viewMatrix.LookAt(a.eyeVec, a.originVec, a.upVec)
 	a.modelMatrix.Identity()
-	a.projectionMatrix[1][1] *= -1 // Flip projection matrix from GL to Vulkan orientation.
 	return a
 }
 
@@ -38,19 +37,52 @@ type SpinningCube struct {
 	format     vk.Format
 	colorSpace vk.ColorSpace
 
-	textures          []*Texture
-	depth             *Depth
-	useStagingBuffers bool
+	textures *TextureManager
+	meshData []*MeshData
+	meshes   []*Mesh
+	skybox   *skybox
+	depth    *Depth
+
+	headless *headlessContext
+	window   *windowContext
+	prepared bool
+	// record records the draws of each frame in the dynamic mode,
+	// see SetRecordFunc
+	record RecordFunc
+
+	// uploadCmd is the command buffer setup work is recorded into,
+	// see upload.
+	uploadCmd vk.CommandBuffer
+	uploads   uploader
+	// readback, when set, receives a copy of the image the frame
+	// being recorded renders to, see CaptureFrame.
+	readback *readback
+	// memory is the allocator the memory of the textures, buffers
+	// and the depth image is suballocated from
+	memory   *memoryAllocator
+	uniforms *uniformRing
 
 	descPool vk.DescriptorPool
 
 	pipelineLayout vk.PipelineLayout
+	bindlessLayout vk.PipelineLayout
 	descLayout     vk.DescriptorSetLayout
 	pipelineCache  vk.PipelineCache
 	renderPass     vk.RenderPass
 	pipeline       vk.Pipeline
+	// pipelines owns the pipelines built with NewPipeline
+	pipelines *pipelineSet
+	// pipelineCachePath is the file the pipeline cache is kept in
+	// between runs, see SetPipelineCachePath. Unless set, the default
+	// path of the device is used
+	pipelineCachePath    string
+	pipelineCacheDefault bool
 
 	frameIndex int
+	deletions  deletionQueue
+	imageIdx   int
+	// region is the region of the uniform ring of the current frame
+	region int
 
 	projectionMatrix lin.Mat4x4
 	viewMatrix       lin.Mat4x4
@@ -64,7 +96,7 @@ type SpinningCube struct {
 }
 
 func (s *SpinningCube) prepareDepth() {
-	dev := s.Context().Device()
+	dev := s.rc().Device()
 	depthFormat := vk.FormatD16Unorm
 	s.depth = &Depth{
 		format: depthFormat,
@@ -85,27 +117,8 @@ func (s *SpinningCube) prepareDepth() {
 		Usage:       vk.ImageUsageFlags(vk.ImageUsageDepthStencilAttachmentBit),
 	}, nil, &s.depth.image)
 	orPanic(as.NewError(ret))
-
-	var memReqs vk.MemoryRequirements
-	vk.GetImageMemoryRequirements(dev, s.depth.image, &memReqs)
-	memReqs.Deref()
-
-	memProps := s.Context().Platform().MemoryProperties()
-	memTypeIndex, _ := as.FindRequiredMemoryTypeFallback(memProps,
-		vk.MemoryPropertyFlagBits(memReqs.MemoryTypeBits), vk.MemoryPropertyDeviceLocalBit)
-	s.depth.memAlloc = &vk.MemoryAllocateInfo{
-		SType:           vk.StructureTypeMemoryAllocateInfo,
-		AllocationSize:  memReqs.Size,
-		MemoryTypeIndex: memTypeIndex,
-	}
-
-	var mem vk.DeviceMemory
-	ret = vk.AllocateMemory(dev, s.depth.memAlloc, nil, &mem)
-	orPanic(as.NewError(ret))
-	s.depth.mem = mem
-
-	ret = vk.BindImageMemory(dev, s.depth.image, s.depth.mem, 0)
-	orPanic(as.NewError(ret))
+	s.depth.mem = s.memory.bindImage(s.depth.image, vk.ImageTilingOptimal,
+		vk.MemoryPropertyDeviceLocalBit)
 
 	var view vk.ImageView
 	ret = vk.CreateImageView(dev, &vk.ImageViewCreateInfo{
@@ -123,28 +136,39 @@ func (s *SpinningCube) prepareDepth() {
 	s.depth.view = view
 }
 
-var texEnabled = []string{
-	"./util/textures/green.png",
-}
-
-func (s *SpinningCube) prepareTextureImage(path string, tiling vk.ImageTiling,
+// prepareTextureImage creates the image of a texture for img, with layers
+// array layers viewed as viewType. Host-visible images are created in
+// vk.ImageLayoutPreinitialized with the base level of img written to them,
+// others in vk.ImageLayoutUndefined.
+func (s *SpinningCube) prepareTextureImage(img *TextureImage, viewType vk.ImageViewType, layers uint32,
+	mipLevels uint32, tiling vk.ImageTiling,
 	usage vk.ImageUsageFlagBits, memoryProps vk.MemoryPropertyFlagBits) *Texture {
 
-	dev := s.Context().Device()
-	texFormat := vk.FormatR8g8b8a8Unorm
-	_, width, height, err := loadTextureData(path, 0)
-	if err != nil {
-		orPanic(err)
+	dev := s.rc().Device()
+	texFormat := img.Format
+	hostVisible := memoryProps&vk.MemoryPropertyHostVisibleBit != 0
+	initialLayout := vk.ImageLayoutUndefined
+	if hostVisible {
+		initialLayout = vk.ImageLayoutPreinitialized
 	}
+	width, height := img.Width, img.Height
 	tex := &Texture{
 		texWidth:    int32(width),
 		texHeight:   int32(height),
+		mipLevels:   mipLevels,
+		layers:      layers,
+		viewType:    viewType,
 		imageLayout: vk.ImageLayoutShaderReadOnlyOptimal,
 	}
+	var flags vk.ImageCreateFlags
+	if viewType == vk.ImageViewTypeCube {
+		flags = vk.ImageCreateFlags(vk.ImageCreateCubeCompatibleBit)
+	}
 
 	var image vk.Image
 	ret := vk.CreateImage(dev, &vk.ImageCreateInfo{
 		SType:     vk.StructureTypeImageCreateInfo,
+		Flags:     flags,
 		ImageType: vk.ImageType2d,
 		Format:    texFormat,
 		Extent: vk.Extent3D{
@@ -152,36 +176,17 @@ func (s *SpinningCube) prepareTextureImage(path string, tiling vk.ImageTiling,
 			Height: uint32(height),
 			Depth:  1,
 		},
-		MipLevels:     1,
-		ArrayLayers:   1,
+		MipLevels:     mipLevels,
+		ArrayLayers:   layers,
 		Samples:       vk.SampleCount1Bit,
 		Tiling:        tiling,
 		Usage:         vk.ImageUsageFlags(usage),
-		InitialLayout: vk.ImageLayoutPreinitialized,
+		InitialLayout: initialLayout,
 	}, nil, &image)
 	orPanic(as.NewError(ret))
 	tex.image = image
+	tex.mem = s.memory.bindImage(tex.image, tiling, memoryProps)
 
-	var memReqs vk.MemoryRequirements
-	vk.GetImageMemoryRequirements(dev, tex.image, &memReqs)
-	memReqs.Deref()
-
-	memProps := s.Context().Platform().MemoryProperties()
-	memTypeIndex, _ := as.FindRequiredMemoryTypeFallback(memProps,
-		vk.MemoryPropertyFlagBits(memReqs.MemoryTypeBits), memoryProps)
-	tex.memAlloc = &vk.MemoryAllocateInfo{
-		SType:           vk.StructureTypeMemoryAllocateInfo,
-		AllocationSize:  memReqs.Size,
-		MemoryTypeIndex: memTypeIndex,
-	}
-	var mem vk.DeviceMemory
-	ret = vk.AllocateMemory(dev, tex.memAlloc, nil, &mem)
-	orPanic(as.NewError(ret))
-	tex.mem = mem
-	ret = vk.BindImageMemory(dev, tex.image, tex.mem, 0)
-	orPanic(as.NewError(ret))
-
-	hostVisible := memoryProps&vk.MemoryPropertyHostVisibleBit != 0
 	if hostVisible {
 		var layout vk.SubresourceLayout
 		vk.GetImageSubresourceLayout(dev, tex.image, &vk.ImageSubresource{
@@ -189,20 +194,9 @@ func (s *SpinningCube) prepareTextureImage(path string, tiling vk.ImageTiling,
 		}, &layout)
 		layout.Deref()
 
-		data, _, _, err := loadTextureData(path, int(layout.RowPitch))
-		orPanic(err)
-		if len(data) > 0 {
-			var pData unsafe.Pointer
-			ret = vk.MapMemory(dev, tex.mem, 0, vk.DeviceSize(len(data)), 0, &pData)
-			if isError(ret) {
-				log.Printf("vulkan warning: failed to map device memory for data (len=%d)", len(data))
-				return tex
-			}
-			n := vk.Memcopy(pData, data)
-			if n != len(data) {
-				log.Printf("vulkan warning: failed to copy data, %d != %d", n, len(data))
-			}
-			vk.UnmapMemory(dev, tex.mem)
+		data := pitchRows(img, int(layout.RowPitch))
+		if n := copy(tex.mem.data(), data); n != len(data) {
+			log.Printf("vulkan warning: failed to copy data, %d != %d", n, len(data))
 		}
 	}
 	return tex
@@ -213,7 +207,7 @@ func (s *SpinningCube) setImageLayout(image vk.Image, aspectMask vk.ImageAspectF
 	srcAccessMask vk.AccessFlagBits,
 	srcStages, dstStages vk.PipelineStageFlagBits) {
 
-	cmd := s.Context().CommandBuffer()
+	cmd := s.setupCmd()
 	if cmd == nil {
 		orPanic(errors.New("vulkan: command buffer not initialized"))
 	}
@@ -255,142 +249,181 @@ func (s *SpinningCube) setImageLayout(image vk.Image, aspectMask vk.ImageAspectF
 		0, 0, nil, 0, nil, 1, []vk.ImageMemoryBarrier{imageMemoryBarrier})
 }
 
-func (s *SpinningCube) prepareTextures() {
-	dev := s.Context().Device()
-	texFormat := vk.FormatR8g8b8a8Unorm
-	var props vk.FormatProperties
-	gpu := s.Context().Platform().PhysicalDevice()
-	vk.GetPhysicalDeviceFormatProperties(gpu, texFormat, &props)
-	props.Deref()
-
-	prepareTex := func(path string) *Texture {
-		var tex *Texture
-
-		if (props.LinearTilingFeatures&vk.FormatFeatureFlags(vk.FormatFeatureSampledImageBit) != 0) &&
-			!s.useStagingBuffers {
-			// -> device can texture using linear textures
-
-			tex = s.prepareTextureImage(path, vk.ImageTilingLinear, vk.ImageUsageSampledBit,
-				vk.MemoryPropertyHostVisibleBit|vk.MemoryPropertyHostCoherentBit)
-
-			// Nothing in the pipeline needs to be complete to start, and don't allow fragment
-			// shader to run until layout transition completes
-			s.setImageLayout(tex.image, vk.ImageAspectColorBit,
-				vk.ImageLayoutPreinitialized, tex.imageLayout,
-				vk.AccessHostWriteBit,
-				vk.PipelineStageTopOfPipeBit, vk.PipelineStageFragmentShaderBit)
-
-		} else if props.OptimalTilingFeatures&vk.FormatFeatureFlags(vk.FormatFeatureSampledImageBit) != 0 {
-			//  Must use staging buffer to copy linear texture to optimized
-			log.Println("vulkan warn: using staging buffers")
-
-			staging := s.prepareTextureImage(path, vk.ImageTilingLinear, vk.ImageUsageTransferSrcBit,
-				vk.MemoryPropertyHostVisibleBit|vk.MemoryPropertyHostCoherentBit)
-			tex = s.prepareTextureImage(path, vk.ImageTilingOptimal,
-				vk.ImageUsageTransferDstBit|vk.ImageUsageSampledBit, vk.MemoryPropertyDeviceLocalBit)
-
-			s.setImageLayout(staging.image, vk.ImageAspectColorBit,
-				vk.ImageLayoutPreinitialized, vk.ImageLayoutTransferSrcOptimal,
-				vk.AccessHostWriteBit,
-				vk.PipelineStageTopOfPipeBit, vk.PipelineStageTransferBit)
-
-			s.setImageLayout(tex.image, vk.ImageAspectColorBit,
-				vk.ImageLayoutPreinitialized, vk.ImageLayoutTransferDstOptimal,
-				vk.AccessHostWriteBit,
-				vk.PipelineStageTopOfPipeBit, vk.PipelineStageTransferBit)
-
-			cmd := s.Context().CommandBuffer()
-			if cmd == nil {
-				orPanic(errors.New("vulkan: command buffer not initialized"))
-			}
-			vk.CmdCopyImage(cmd, staging.image, vk.ImageLayoutTransferSrcOptimal,
-				tex.image, vk.ImageLayoutTransferDstOptimal,
-				1, []vk.ImageCopy{{
-					SrcSubresource: vk.ImageSubresourceLayers{
-						AspectMask: vk.ImageAspectFlags(vk.ImageAspectColorBit),
-						LayerCount: 1,
-					},
-					SrcOffset: vk.Offset3D{
-						X: 0, Y: 0, Z: 0,
-					},
-					DstSubresource: vk.ImageSubresourceLayers{
-						AspectMask: vk.ImageAspectFlags(vk.ImageAspectColorBit),
-						LayerCount: 1,
-					},
-					DstOffset: vk.Offset3D{
-						X: 0, Y: 0, Z: 0,
-					},
-					Extent: vk.Extent3D{
-						Width:  uint32(staging.texWidth),
-						Height: uint32(staging.texHeight),
-						Depth:  1,
-					},
-				}})
-			s.setImageLayout(tex.image, vk.ImageAspectColorBit,
-				vk.ImageLayoutTransferDstOptimal, tex.imageLayout,
-				vk.AccessTransferWriteBit,
-				vk.PipelineStageTransferBit, vk.PipelineStageFragmentShaderBit)
-			// cannot destroy until cmd is submitted.. must keep a list somewhere
-			// staging.DestroyImage(dev)
-		} else {
-			orPanic(errors.New("vulkan: R8G8B8A8_UNORM not supported as texture image format"))
-		}
+// loadTexture creates the texture of ref for s.textures.
+func (s *SpinningCube) loadTexture(ref TextureRef) (tex *Texture) {
+	s.upload(func() {
+		tex = s.prepareTexture(ref)
+	})
+	return tex
+}
 
-		var sampler vk.Sampler
-		ret := vk.CreateSampler(dev, &vk.SamplerCreateInfo{
-			SType:                   vk.StructureTypeSamplerCreateInfo,
-			MagFilter:               vk.FilterNearest,
-			MinFilter:               vk.FilterNearest,
-			MipmapMode:              vk.SamplerMipmapModeNearest,
-			AddressModeU:            vk.SamplerAddressModeClampToEdge,
-			AddressModeV:            vk.SamplerAddressModeClampToEdge,
-			AddressModeW:            vk.SamplerAddressModeClampToEdge,
-			AnisotropyEnable:        vk.False,
-			MaxAnisotropy:           1,
-			CompareOp:               vk.CompareOpNever,
-			BorderColor:             vk.BorderColorFloatOpaqueWhite,
-			UnnormalizedCoordinates: vk.False,
-		}, nil, &sampler)
-		orPanic(as.NewError(ret))
-		tex.sampler = sampler
-
-		var view vk.ImageView
-		ret = vk.CreateImageView(dev, &vk.ImageViewCreateInfo{
-			SType:    vk.StructureTypeImageViewCreateInfo,
-			Image:    tex.image,
-			ViewType: vk.ImageViewType2d,
-			Format:   texFormat,
-			Components: vk.ComponentMapping{
-				R: vk.ComponentSwizzleR,
-				G: vk.ComponentSwizzleG,
-				B: vk.ComponentSwizzleB,
-				A: vk.ComponentSwizzleA,
-			},
-			SubresourceRange: vk.ImageSubresourceRange{
-				AspectMask: vk.ImageAspectFlags(vk.ImageAspectColorBit),
-				LevelCount: 1,
-				LayerCount: 1,
-			},
-		}, nil, &view)
-		orPanic(as.NewError(ret))
-		tex.view = view
+// loadCubemap creates the cube texture of ref for s.textures.
+func (s *SpinningCube) loadCubemap(ref CubemapRef) (tex *Texture) {
+	s.upload(func() {
+		tex = s.prepareCubemap(ref)
+	})
+	return tex
+}
+
+// loadTextureArray creates the array texture of ref for s.textures.
+func (s *SpinningCube) loadTextureArray(ref TextureArrayRef) (tex *Texture) {
+	s.upload(func() {
+		tex = s.prepareTextureArray(ref)
+	})
+	return tex
+}
 
-		return tex
+// loadTextureImage decodes the image of ref, decompressed on the CPU when
+// the device can't sample its compression format or the feature of the
+// format isn't enabled.
+func (s *SpinningCube) loadTextureImage(ref TextureRef) *TextureImage {
+	src, err := ref.load()
+	orPanic(err)
+	img, err := DecodeTexture(ref.Name, src)
+	orPanic(err)
+	orPanic(img.checkLevels())
+	img.setColorSpace(ref.Linear)
+
+	props := s.formatProperties(img.Format)
+	if isCompressed(img.Format) && (!compressionEnabled(img.Format, s.rc().Features()) ||
+		props.OptimalTilingFeatures&vk.FormatFeatureFlags(vk.FormatFeatureSampledImageBit) == 0) {
+		// the device lacks the compression format, upload the decoded texels
+		log.Printf("vulkan warn: format %d not supported, decompressing %s", img.Format, ref.Name)
+		img, err = decompress(img)
+		orPanic(err)
 	}
+	return img
+}
 
-	s.textures = make([]*Texture, 0, len(texEnabled))
-	for _, texFile := range texEnabled {
-		s.textures = append(s.textures, prepareTex(texFile))
+// prepareTexture loads the image of ref into a sampled texture. The layout
+// transitions and copies are recorded into the setup command buffer.
+func (s *SpinningCube) prepareTexture(ref TextureRef) *Texture {
+	img := s.loadTextureImage(ref)
+	props := s.formatProperties(img.Format)
+	sampled := vk.FormatFeatureFlags(vk.FormatFeatureSampledImageBit)
+	texFormat := img.Format
+
+	var tex *Texture
+
+	if props.OptimalTilingFeatures&sampled != 0 {
+		// copy the texels through a staging buffer into an optimal tiling image,
+		// with the missing mip levels blitted from them or computed on the CPU.
+		// Compressed textures only have the levels they were baked with.
+		levels := mipLevels(img.Width, img.Height)
+		usage := vk.ImageUsageTransferDstBit | vk.ImageUsageSampledBit
+		if isCompressed(texFormat) {
+			levels = uint32(len(img.Levels))
+		} else if len(img.Levels) < int(levels) {
+			if s.canBlitMipmaps(texFormat) {
+				usage |= vk.ImageUsageTransferSrcBit
+			} else {
+				mipChain(img)
+			}
+		}
+		tex = s.prepareTextureImage(img, vk.ImageViewType2d, 1, levels, vk.ImageTilingOptimal,
+			usage, vk.MemoryPropertyDeviceLocalBit)
+		s.copyToImage(tex.image, []*TextureImage{img}, tex.mipLevels, tex.imageLayout)
+
+	} else if props.LinearTilingFeatures&sampled != 0 {
+		// -> device can texture using linear textures only, without mipmaps
+		log.Println("vulkan warn: using linear textures")
+
+		tex = s.prepareTextureImage(img, vk.ImageViewType2d, 1, 1, vk.ImageTilingLinear, vk.ImageUsageSampledBit,
+			vk.MemoryPropertyHostVisibleBit|vk.MemoryPropertyHostCoherentBit)
+
+		// Nothing in the pipeline needs to be complete to start, and don't allow fragment
+		// shader to run until layout transition completes
+		s.setImageLayout(tex.image, vk.ImageAspectColorBit,
+			vk.ImageLayoutPreinitialized, tex.imageLayout,
+			vk.AccessHostWriteBit,
+			vk.PipelineStageTopOfPipeBit, vk.PipelineStageFragmentShaderBit)
+
+	} else {
+		orPanic(fmt.Errorf("vulkan: format %d not supported as texture image format", texFormat))
 	}
+
+	s.prepareTextureView(tex, texFormat)
+	return tex
+}
+
+// prepareTextureView creates the view of all levels and layers of tex.
+func (s *SpinningCube) prepareTextureView(tex *Texture, format vk.Format) {
+	var view vk.ImageView
+	ret := vk.CreateImageView(s.rc().Device(), &vk.ImageViewCreateInfo{
+		SType:    vk.StructureTypeImageViewCreateInfo,
+		Image:    tex.image,
+		ViewType: tex.viewType,
+		Format:   format,
+		Components: vk.ComponentMapping{
+			R: vk.ComponentSwizzleR,
+			G: vk.ComponentSwizzleG,
+			B: vk.ComponentSwizzleB,
+			A: vk.ComponentSwizzleA,
+		},
+		SubresourceRange: vk.ImageSubresourceRange{
+			AspectMask: vk.ImageAspectFlags(vk.ImageAspectColorBit),
+			LevelCount: tex.mipLevels,
+			LayerCount: tex.layers,
+		},
+	}, nil, &view)
+	orPanic(as.NewError(ret))
+	tex.view = view
 }
 
-func (s *SpinningCube) drawBuildCommandBuffer(res *as.SwapchainImageResources, cmd vk.CommandBuffer) {
+func (s *SpinningCube) drawBuildCommandBuffer(res imageResources, cmd vk.CommandBuffer, region int,
+	usage vk.CommandBufferUsageFlagBits) {
+
 	ret := vk.BeginCommandBuffer(cmd, &vk.CommandBufferBeginInfo{
 		SType: vk.StructureTypeCommandBufferBeginInfo,
-		Flags: vk.CommandBufferUsageFlags(vk.CommandBufferUsageSimultaneousUseBit),
+		Flags: vk.CommandBufferUsageFlags(usage),
 	})
 	orPanic(as.NewError(ret))
 
+	s.drawRenderPass(cmd, s.renderPass, res, region)
+	if s.readback != nil {
+		s.readback.record(cmd, res.Image(), s.rc().ColorFinalLayout())
+	}
+
+	graphicsQueueIndex := s.rc().GraphicsQueueFamilyIndex()
+	presentQueueIndex := s.rc().PresentQueueFamilyIndex()
+	if graphicsQueueIndex != presentQueueIndex {
+		// Separate Present Queue Case
+		//
+		// We have to transfer ownership from the graphics queue family to the
+		// present queue family to be able to present.  Note that we don't have
+		// to transfer from present queue family back to graphics queue family at
+		// the start of the next frame because we don't care about the image's
+		// contents at that point.
+		vk.CmdPipelineBarrier(cmd,
+			vk.PipelineStageFlags(vk.PipelineStageColorAttachmentOutputBit),
+			vk.PipelineStageFlags(vk.PipelineStageBottomOfPipeBit),
+			0, 0, nil, 0, nil, 1, []vk.ImageMemoryBarrier{{
+				SType:               vk.StructureTypeImageMemoryBarrier,
+				SrcAccessMask:       0,
+				DstAccessMask:       vk.AccessFlags(vk.AccessColorAttachmentWriteBit),
+				OldLayout:           vk.ImageLayoutPresentSrc,
+				NewLayout:           vk.ImageLayoutPresentSrc,
+				SrcQueueFamilyIndex: graphicsQueueIndex,
+				DstQueueFamilyIndex: presentQueueIndex,
+				SubresourceRange: vk.ImageSubresourceRange{
+					AspectMask: vk.ImageAspectFlags(vk.ImageAspectColorBit),
+					LayerCount: 1,
+					LevelCount: 1,
+				},
+				Image: res.Image(),
+			}})
+	}
+	ret = vk.EndCommandBuffer(cmd)
+	orPanic(as.NewError(ret))
+}
+
+// drawRenderPass records the scene into cmd as a single render pass instance
+// rendering to res, with the uniforms in slots of region of the uniform ring.
+func (s *SpinningCube) drawRenderPass(cmd vk.CommandBuffer, renderPass vk.RenderPass,
+	res imageResources, region int) {
+
+	s.uniforms.reset(region)
+
 	clearValues := make([]vk.ClearValue, 2)
 	clearValues[1].SetDepthStencil(1, 0)
 	clearValues[0].SetColor([]float32{
@@ -398,7 +431,7 @@ func (s *SpinningCube) drawBuildCommandBuffer(res *as.SwapchainImageResources, c
 	})
 	vk.CmdBeginRenderPass(cmd, &vk.RenderPassBeginInfo{
 		SType:       vk.StructureTypeRenderPassBeginInfo,
-		RenderPass:  s.renderPass,
+		RenderPass:  renderPass,
 		Framebuffer: res.Framebuffer(),
 		RenderArea: vk.Rect2D{
 			Offset: vk.Offset2D{
@@ -414,8 +447,7 @@ func (s *SpinningCube) drawBuildCommandBuffer(res *as.SwapchainImageResources, c
 	}, vk.SubpassContentsInline)
 
 	vk.CmdBindPipeline(cmd, vk.PipelineBindPointGraphics, s.pipeline)
-	vk.CmdBindDescriptorSets(cmd, vk.PipelineBindPointGraphics, s.pipelineLayout,
-		0, 1, []vk.DescriptorSet{res.DescriptorSet()}, 0, nil)
+	s.bindUniforms(cmd, s.pipelineLayout, res.DescriptorSet(), region)
 	vk.CmdSetViewport(cmd, 0, 1, []vk.Viewport{{
 		Width:    float32(s.width),
 		Height:   float32(s.height),
@@ -432,133 +464,87 @@ func (s *SpinningCube) drawBuildCommandBuffer(res *as.SwapchainImageResources, c
 		},
 	}})
 
-	vk.CmdDraw(cmd, 4*3, 1, 0, 0)
+	if s.record != nil {
+		s.recordFrame(cmd, res, region)
+	} else {
+		s.drawSkybox(cmd)
+		s.bindTextureTable(cmd)
+		layout := s.sceneLayout()
+		for _, m := range s.meshes {
+			m.draw(cmd, layout)
+		}
+	}
 	// Note that ending the renderpass changes the image's layout from
-	// vk.ImageLayoutColorAttachmentOptimal to vk.ImageLayoutPresentSrc
+	// vk.ImageLayoutColorAttachmentOptimal to the final layout of renderPass,
+	// vk.ImageLayoutPresentSrc for swapchain images.
 	vk.CmdEndRenderPass(cmd)
-
-	graphicsQueueIndex := s.Context().Platform().GraphicsQueueFamilyIndex()
-	presentQueueIndex := s.Context().Platform().PresentQueueFamilyIndex()
-	if graphicsQueueIndex != presentQueueIndex {
-		// Separate Present Queue Case
-		//
-		// We have to transfer ownership from the graphics queue family to the
-		// present queue family to be able to present.  Note that we don't have
-		// to transfer from present queue family back to graphics queue family at
-		// the start of the next frame because we don't care about the image's
-		// contents at that point.
-		vk.CmdPipelineBarrier(cmd,
-			vk.PipelineStageFlags(vk.PipelineStageColorAttachmentOutputBit),
-			vk.PipelineStageFlags(vk.PipelineStageBottomOfPipeBit),
-			0, 0, nil, 0, nil, 1, []vk.ImageMemoryBarrier{{
-				SType:               vk.StructureTypeImageMemoryBarrier,
-				SrcAccessMask:       0,
-				DstAccessMask:       vk.AccessFlags(vk.AccessColorAttachmentWriteBit),
-				OldLayout:           vk.ImageLayoutPresentSrc,
-				NewLayout:           vk.ImageLayoutPresentSrc,
-				SrcQueueFamilyIndex: graphicsQueueIndex,
-				DstQueueFamilyIndex: presentQueueIndex,
-				SubresourceRange: vk.ImageSubresourceRange{
-					AspectMask: vk.ImageAspectFlags(vk.ImageAspectColorBit),
-					LayerCount: 1,
-					LevelCount: 1,
-				},
-				Image: res.Image(),
-			}})
-	}
-	ret = vk.EndCommandBuffer(cmd)
-	orPanic(as.NewError(ret))
-}
-
-func (s *SpinningCube) prepareCubeDataBuffers() {
-	dev := s.Context().Device()
-
-	var VP lin.Mat4x4
-	var MVP lin.Mat4x4
-	VP.Mult(&s.projectionMatrix, &s.viewMatrix)
-	MVP.Mult(&VP, &s.modelMatrix)
-
-	data := vkTexCubeUniform{
-		mvp: MVP,
-	}
-	for i := 0; i < 4*3; i++ {
-		data.position[i][0] = gVertexBufferData[i*3]
-		data.position[i][1] = gVertexBufferData[i*3+1]
-		data.position[i][2] = gVertexBufferData[i*3+2]
-		data.position[i][3] = 1.0
-		data.attr[i][0] = gUVBufferData[2*i]
-		data.attr[i][1] = gUVBufferData[2*i+1]
-		data.attr[i][2] = 0
-		data.attr[i][3] = 0
-	}
-
-	dataRaw := data.Data()
-	memProps := s.Context().Platform().MemoryProperties()
-	swapchainImageResources := s.Context().SwapchainImageResources()
-	for _, res := range swapchainImageResources {
-		buf := as.CreateBuffer(dev, memProps, dataRaw, vk.BufferUsageUniformBufferBit)
-		res.SetUniformBuffer(buf.Buffer, buf.Memory)
-	}
 }
 
 func (s *SpinningCube) prepareDescriptorLayout() {
-	dev := s.Context().Device()
+	dev := s.rc().Device()
 
 	var descLayout vk.DescriptorSetLayout
 	ret := vk.CreateDescriptorSetLayout(dev, &vk.DescriptorSetLayoutCreateInfo{
 		SType:        vk.StructureTypeDescriptorSetLayoutCreateInfo,
-		BindingCount: 2,
+		BindingCount: 1,
 		PBindings: []vk.DescriptorSetLayoutBinding{
 			{
 				Binding:         0,
-				DescriptorType:  vk.DescriptorTypeUniformBuffer,
+				DescriptorType:  vk.DescriptorTypeUniformBufferDynamic,
 				DescriptorCount: 1,
 				StageFlags:      vk.ShaderStageFlags(vk.ShaderStageVertexBit),
-			}, {
-				Binding:         1,
-				DescriptorType:  vk.DescriptorTypeCombinedImageSampler,
-				DescriptorCount: uint32(len(texEnabled)),
-				StageFlags:      vk.ShaderStageFlags(vk.ShaderStageFragmentBit),
 			}},
 	}, nil, &descLayout)
 	orPanic(as.NewError(ret))
 	s.descLayout = descLayout
 
-	var pipelineLayout vk.PipelineLayout
-	ret = vk.CreatePipelineLayout(dev, &vk.PipelineLayoutCreateInfo{
-		SType:          vk.StructureTypePipelineLayoutCreateInfo,
-		SetLayoutCount: 1,
-		PSetLayouts: []vk.DescriptorSetLayout{
+	// set 1 holds the texture of the mesh group being drawn, the model
+	// matrix of the draw is pushed
+	s.pipelineLayout = createPipelineLayout(dev, []vk.DescriptorSetLayout{
+		s.descLayout,
+		s.textures.layout,
+	}, pushConstantRanges)
+
+	if t := s.textures.bindless; t != nil {
+		// the scene pipeline samples the texture table as set 1
+		s.bindlessLayout = createPipelineLayout(dev, []vk.DescriptorSetLayout{
 			s.descLayout,
-		},
-	}, nil, &pipelineLayout)
-	orPanic(as.NewError(ret))
-	s.pipelineLayout = pipelineLayout
+			t.layout,
+		}, pushConstantRanges)
+	}
 }
 
 func (s *SpinningCube) prepareRenderPass() {
-	dev := s.Context().Device()
+	s.renderPass = s.createRenderPass(s.rc().ColorFinalLayout())
+}
+
+// createRenderPass creates a render pass for the color and depth attachments
+// that leaves the color attachment in finalLayout. Render passes differing
+// only in finalLayout are compatible, so they can share s.pipeline.
+func (s *SpinningCube) createRenderPass(finalLayout vk.ImageLayout) vk.RenderPass {
+	dev := s.rc().Device()
 	// The initial layout for the color and depth attachments will be vk.LayoutUndefined
 	// because at the start of the renderpass, we don't care about their contents.
 	// At the start of the subpass, the color attachment's layout will be transitioned
 	// to vk.LayoutColorAttachmentOptimal and the depth stencil attachment's layout
 	// will be transitioned to vk.LayoutDepthStencilAttachmentOptimal.  At the end of
 	// the renderpass, the color attachment's layout will be transitioned to
-	// vk.LayoutPresentSrc to be ready to present.  This is all done as part of
+	// vk.LayoutPresentSrc to be ready to present (or vk.LayoutTransferSrcOptimal
+	// to be read back when rendering headless).  This is all done as part of
 	// the renderpass, no barriers are necessary.
 	var renderPass vk.RenderPass
 	ret := vk.CreateRenderPass(dev, &vk.RenderPassCreateInfo{
 		SType:           vk.StructureTypeRenderPassCreateInfo,
 		AttachmentCount: 2,
 		PAttachments: []vk.AttachmentDescription{{
-			Format:         s.Context().SwapchainDimensions().Format,
+			Format:         s.format,
 			Samples:        vk.SampleCount1Bit,
 			LoadOp:         vk.AttachmentLoadOpClear,
 			StoreOp:        vk.AttachmentStoreOpStore,
 			StencilLoadOp:  vk.AttachmentLoadOpDontCare,
 			StencilStoreOp: vk.AttachmentStoreOpDontCare,
 			InitialLayout:  vk.ImageLayoutUndefined,
-			FinalLayout:    vk.ImageLayoutPresentSrc,
+			FinalLayout:    finalLayout,
 		}, {
 			Format:         s.depth.format,
 			Samples:        vk.SampleCount1Bit,
@@ -584,124 +570,33 @@ func (s *SpinningCube) prepareRenderPass() {
 		}},
 	}, nil, &renderPass)
 	orPanic(as.NewError(ret))
-	s.renderPass = renderPass
+	return renderPass
 }
 
 func (s *SpinningCube) preparePipeline() {
-	dev := s.Context().Device()
+	dev := s.rc().Device()
 
-	shader, _ := ioutil.ReadFile("./util/shader/vert.spv")
-	vs, err := as.LoadShaderModule(dev, shader)
-	orPanic(err)
-	frag, _ := ioutil.ReadFile("./util/shader/frag.spv")
-	fs, err := as.LoadShaderModule(dev, frag)
-	orPanic(err)
+	s.pipelineCache = s.createPipelineCache()
+	s.pipelines = newPipelineSet(dev, s.pipelineCache)
 
-	var pipelineCache vk.PipelineCache
-	ret := vk.CreatePipelineCache(dev, &vk.PipelineCacheCreateInfo{
-		SType: vk.StructureTypePipelineCacheCreateInfo,
-	}, nil, &pipelineCache)
-	orPanic(as.NewError(ret))
-	s.pipelineCache = pipelineCache
-
-	pipelineCreateInfos := []vk.GraphicsPipelineCreateInfo{{
-		SType:      vk.StructureTypeGraphicsPipelineCreateInfo,
-		Layout:     s.pipelineLayout,
-		RenderPass: s.renderPass,
-
-		PDynamicState: &vk.PipelineDynamicStateCreateInfo{
-			SType:             vk.StructureTypePipelineDynamicStateCreateInfo,
-			DynamicStateCount: 2,
-			PDynamicStates: []vk.DynamicState{
-				vk.DynamicStateScissor,
-				vk.DynamicStateViewport,
-			},
-		},
-		PVertexInputState: &vk.PipelineVertexInputStateCreateInfo{
-			SType: vk.StructureTypePipelineVertexInputStateCreateInfo,
-		},
-		PInputAssemblyState: &vk.PipelineInputAssemblyStateCreateInfo{
-			SType:    vk.StructureTypePipelineInputAssemblyStateCreateInfo,
-			Topology: vk.PrimitiveTopologyTriangleList,
-		},
-		PRasterizationState: &vk.PipelineRasterizationStateCreateInfo{
-			SType:       vk.StructureTypePipelineRasterizationStateCreateInfo,
-			PolygonMode: vk.PolygonModeFill,
-			CullMode:    vk.CullModeFlags(vk.CullModeBackBit),
-			FrontFace:   vk.FrontFaceCounterClockwise,
-			LineWidth:   1.0,
-		},
-		PColorBlendState: &vk.PipelineColorBlendStateCreateInfo{
-			SType:           vk.StructureTypePipelineColorBlendStateCreateInfo,
-			AttachmentCount: 1,
-			PAttachments: []vk.PipelineColorBlendAttachmentState{{
-				ColorWriteMask: 0xF,
-				BlendEnable:    vk.False,
-			}},
-		},
-		PMultisampleState: &vk.PipelineMultisampleStateCreateInfo{
-			SType:                vk.StructureTypePipelineMultisampleStateCreateInfo,
-			RasterizationSamples: vk.SampleCount1Bit,
-		},
-		PViewportState: &vk.PipelineViewportStateCreateInfo{
-			SType:         vk.StructureTypePipelineViewportStateCreateInfo,
-			ScissorCount:  1,
-			ViewportCount: 1,
-		},
-		PDepthStencilState: &vk.PipelineDepthStencilStateCreateInfo{
-			SType:                 vk.StructureTypePipelineDepthStencilStateCreateInfo,
-			DepthTestEnable:       vk.True,
-			DepthWriteEnable:      vk.True,
-			DepthCompareOp:        vk.CompareOpLessOrEqual,
-			DepthBoundsTestEnable: vk.False,
-			Back: vk.StencilOpState{
-				FailOp:    vk.StencilOpKeep,
-				PassOp:    vk.StencilOpKeep,
-				CompareOp: vk.CompareOpAlways,
-			},
-			StencilTestEnable: vk.False,
-			Front: vk.StencilOpState{
-				FailOp:    vk.StencilOpKeep,
-				PassOp:    vk.StencilOpKeep,
-				CompareOp: vk.CompareOpAlways,
-			},
-		},
-		StageCount: 2,
-		PStages: []vk.PipelineShaderStageCreateInfo{{
-			SType:  vk.StructureTypePipelineShaderStageCreateInfo,
-			Stage:  vk.ShaderStageVertexBit,
-			Module: vs,
-			PName:  "main\x00",
-		}, {
-			SType:  vk.StructureTypePipelineShaderStageCreateInfo,
-			Stage:  vk.ShaderStageFragmentBit,
-			Module: fs,
-			PName:  "main\x00",
-		}},
-	}}
-	pipeline := make([]vk.Pipeline, 1)
-	ret = vk.CreateGraphicsPipelines(dev, s.pipelineCache, 1, pipelineCreateInfos, nil, pipeline)
-	orPanic(as.NewError(ret))
-	s.pipeline = pipeline[0]
-
-	vk.DestroyShaderModule(dev, vs, nil)
-	vk.DestroyShaderModule(dev, fs, nil)
+	vertPath, fragPath := "./util/shader/vert.spv", "./util/shader/frag.spv"
+	if s.textures.bindless != nil {
+		vertPath, fragPath = "./util/shader/bindless_vert.spv", "./util/shader/bindless_frag.spv"
+	}
+	s.pipeline = s.NewPipeline().Shaders(vertPath, fragPath).build()
 }
 
 func (s *SpinningCube) prepareDescriptorPool() {
-	dev := s.Context().Device()
-	swapchainImageResources := s.Context().SwapchainImageResources()
+	dev := s.rc().Device()
+	swapchainImageResources := s.rc().ImageResources()
 	var descPool vk.DescriptorPool
 	ret := vk.CreateDescriptorPool(dev, &vk.DescriptorPoolCreateInfo{
 		SType:         vk.StructureTypeDescriptorPoolCreateInfo,
 		MaxSets:       uint32(len(swapchainImageResources)),
-		PoolSizeCount: 2,
+		PoolSizeCount: 1,
 		PPoolSizes: []vk.DescriptorPoolSize{{
-			Type:            vk.DescriptorTypeUniformBuffer,
+			Type:            vk.DescriptorTypeUniformBufferDynamic,
 			DescriptorCount: uint32(len(swapchainImageResources)),
-		}, {
-			Type:            vk.DescriptorTypeCombinedImageSampler,
-			DescriptorCount: uint32(len(swapchainImageResources) * len(texEnabled)),
 		}},
 	}, nil, &descPool)
 	orPanic(as.NewError(ret))
@@ -709,17 +604,8 @@ func (s *SpinningCube) prepareDescriptorPool() {
 }
 
 func (s *SpinningCube) prepareDescriptorSet() {
-	dev := s.Context().Device()
-	swapchainImageResources := s.Context().SwapchainImageResources()
-
-	texInfos := make([]vk.DescriptorImageInfo, 0, len(s.textures))
-	for _, tex := range s.textures {
-		texInfos = append(texInfos, vk.DescriptorImageInfo{
-			Sampler:     tex.sampler,
-			ImageView:   tex.view,
-			ImageLayout: vk.ImageLayoutGeneral,
-		})
-	}
+	dev := s.rc().Device()
+	swapchainImageResources := s.rc().ImageResources()
 
 	for _, res := range swapchainImageResources {
 		var set vk.DescriptorSet
@@ -733,90 +619,143 @@ func (s *SpinningCube) prepareDescriptorSet() {
 
 		res.SetDescriptorSet(set)
 
-		vk.UpdateDescriptorSets(dev, 2, []vk.WriteDescriptorSet{{
+		vk.UpdateDescriptorSets(dev, 1, []vk.WriteDescriptorSet{{
 			SType:           vk.StructureTypeWriteDescriptorSet,
 			DstSet:          set,
 			DescriptorCount: 1,
-			DescriptorType:  vk.DescriptorTypeUniformBuffer,
+			DescriptorType:  vk.DescriptorTypeUniformBufferDynamic,
 			PBufferInfo: []vk.DescriptorBufferInfo{{
 				Offset: 0,
 				Range:  vk.DeviceSize(vkTexCubeUniformSize),
-				Buffer: res.UniformBuffer(),
+				Buffer: s.uniforms.buffer,
 			}},
-		}, {
-			SType:           vk.StructureTypeWriteDescriptorSet,
-			DstBinding:      1,
-			DstSet:          set,
-			DescriptorCount: uint32(len(texEnabled)),
-			DescriptorType:  vk.DescriptorTypeCombinedImageSampler,
-			PImageInfo:      texInfos,
 		}}, 0, nil)
 	}
 }
 
 func (s *SpinningCube) prepareFramebuffers() {
-	dev := s.Context().Device()
-	swapchainImageResources := s.Context().SwapchainImageResources()
+	swapchainImageResources := s.rc().ImageResources()
 
 	for _, res := range swapchainImageResources {
-		var fb vk.Framebuffer
-
-		ret := vk.CreateFramebuffer(dev, &vk.FramebufferCreateInfo{
-			SType:           vk.StructureTypeFramebufferCreateInfo,
-			RenderPass:      s.renderPass,
-			AttachmentCount: 2,
-			PAttachments: []vk.ImageView{
-				res.View(),
-				s.depth.view,
-			},
-			Width:  s.width,
-			Height: s.height,
-			Layers: 1,
-		}, nil, &fb)
-		orPanic(as.NewError(ret))
-
-		res.SetFramebuffer(fb)
+		res.SetFramebuffer(s.createFramebuffer(s.renderPass, res.View()))
 	}
 }
 
+func (s *SpinningCube) createFramebuffer(renderPass vk.RenderPass, view vk.ImageView) vk.Framebuffer {
+	dev := s.rc().Device()
+	var fb vk.Framebuffer
+	ret := vk.CreateFramebuffer(dev, &vk.FramebufferCreateInfo{
+		SType:           vk.StructureTypeFramebufferCreateInfo,
+		RenderPass:      renderPass,
+		AttachmentCount: 2,
+		PAttachments: []vk.ImageView{
+			view,
+			s.depth.view,
+		},
+		Width:  s.width,
+		Height: s.height,
+		Layers: 1,
+	}, nil, &fb)
+	orPanic(as.NewError(ret))
+	return fb
+}
+
+// VulkanContextPrepare is called by the context whenever the swapchain has been
+// (re)created. Resources that don't depend on the swapchain are prepared once,
+// the depth image, uniform ring, descriptor sets, framebuffers and command
+// buffers are rebuilt for the current swapchain extent and image count.
 func (s *SpinningCube) VulkanContextPrepare() error {
-	dim := s.Context().SwapchainDimensions()
+	dim := s.rc().Dimensions()
 	s.height = dim.Height
 	s.width = dim.Width
-
+	s.format = dim.Format
+	if !isSRGB(s.format) && s.colorSpace == vk.ColorSpaceSrgbNonlinear {
+		log.Printf("vulkan warn: color format %d is not sRGB, colors are blended in gamma space", s.format)
+	}
+	s.imageIdx = 0
+	s.region = 0
+	s.updateProjection()
+
+	s.uploadCmd = s.rc().CommandBuffer()
+	defer func() {
+		s.uploads.finish(s.rc().Device(), s.deferDestroy)
+		s.uploadCmd = nil
+	}()
+
+	if s.memory == nil {
+		rc := s.rc()
+		s.memory = newMemoryAllocator(rc.Device(), rc.PhysicalDevice(), rc.MemoryProperties())
+	}
 	s.prepareDepth()
-	s.prepareTextures()
-	s.prepareCubeDataBuffers()
-	s.prepareDescriptorLayout()
-	s.prepareRenderPass()
-	s.preparePipeline()
+	if !s.prepared {
+		rc := s.rc()
+		samplers := newSamplerCache(rc.Device(), rc.PhysicalDevice(), rc.Features())
+		s.textures.prepare(rc.Device(), samplers, s)
+		// the set layouts exist before the meshes allocate
+		// the descriptor sets of their materials
+		s.prepareDescriptorLayout()
+		s.prepareMeshes()
+		s.prepareRenderPass()
+		s.preparePipeline()
+		if s.skybox != nil {
+			s.prepareSkybox()
+		}
+		s.prepared = true
+	}
+	s.prepareUniforms()
 	s.prepareDescriptorPool()
 	s.prepareDescriptorSet()
 	s.prepareFramebuffers()
+	s.buildCommandBuffers()
+	return nil
+}
 
-	swapchainImageResources := s.Context().SwapchainImageResources()
-	for _, res := range swapchainImageResources {
-		s.drawBuildCommandBuffer(res, res.CommandBuffer())
+// buildCommandBuffers records the command buffers of the images, replayed
+// until they are recorded again. Images without a command buffer have their
+// frames recorded as they are rendered.
+func (s *SpinningCube) buildCommandBuffers() {
+	swapchainImageResources := s.rc().ImageResources()
+	for i, res := range swapchainImageResources {
+		if cmd := res.CommandBuffer(); cmd != nil {
+			s.drawBuildCommandBuffer(res, cmd, i, vk.CommandBufferUsageSimultaneousUseBit)
+		}
 	}
-	return nil
 }
 
+// VulkanContextCleanup releases the swapchain dependent resources before the
+// swapchain is recreated or destroyed. The framebuffers and command buffers
+// are owned by the swapchain image resources.
 func (s *SpinningCube) VulkanContextCleanup() error {
-	dev := s.Context().Device()
+	dev := s.rc().Device()
 	vk.DestroyDescriptorPool(dev, s.descPool, nil)
-	vk.DestroyPipeline(dev, s.pipeline, nil)
-	vk.DestroyPipelineCache(dev, s.pipelineCache, nil)
-	vk.DestroyRenderPass(dev, s.renderPass, nil)
-	vk.DestroyPipelineLayout(dev, s.pipelineLayout, nil)
-	vk.DestroyDescriptorSetLayout(dev, s.descLayout, nil)
-
-	for i := 0; i < len(s.textures); i++ {
-		s.textures[i].Destroy(dev)
-	}
 	s.depth.Destroy(dev)
+	s.uniforms.destroy(dev)
+	s.uniforms = nil
 	return nil
 }
 
+func (s *SpinningCube) updateProjection() {
+	aspect := float32(s.width) / float32(s.height)
+	s.projectionMatrix.Perspective(lin.DegreesToRadians(45.0), aspect, 0.1, 100.0)
+	s.projectionMatrix[1][1] *= -1 // Flip projection matrix from GL to Vulkan orientation.
+}
+
+// uniformData returns the uniforms of the current frame.
+func (s *SpinningCube) uniformData() *vkTexCubeUniform {
+	var u vkTexCubeUniform
+	var VP lin.Mat4x4
+	VP.Mult(&s.projectionMatrix, &s.viewMatrix)
+	u.mvp.Mult(&VP, &s.modelMatrix)
+
+	// the skybox is centered on the eye, without the view translation
+	var view, skyVP lin.Mat4x4
+	view.Dup(&s.viewMatrix)
+	view[3][0], view[3][1], view[3][2] = 0, 0, 0
+	skyVP.Mult(&s.projectionMatrix, &view)
+	u.skyInvViewProj.Invert(&skyVP)
+	return &u
+}
+
 func (s *SpinningCube) NextFrame() {
 	var Model lin.Mat4x4
 	Model.Dup(&s.modelMatrix)
@@ -825,66 +764,102 @@ func (s *SpinningCube) NextFrame() {
 }
 
 func (s *SpinningCube) VulkanContextInvalidate(imageIdx int) error {
-	dev := s.Context().Device()
-	res := s.Context().SwapchainImageResources()[imageIdx]
+	s.imageIdx = imageIdx
+	s.region = imageIdx
+	s.beginFrame()
 
-	var MVP, VP lin.Mat4x4
-	VP.Mult(&s.projectionMatrix, &s.viewMatrix)
-	MVP.Mult(&VP, &s.modelMatrix)
+	s.uniforms.update(imageIdx)
+	return nil
+}
 
-	data := MVP.Data()
-	var pData unsafe.Pointer
-	ret := vk.MapMemory(dev, res.UniformMemory(), 0, vk.DeviceSize(len(data)), 0, &pData)
-	orPanic(as.NewError(ret))
+// beginFrame starts a new frame, running the deletions no frame
+// in flight can depend on anymore.
+func (s *SpinningCube) beginFrame() {
+	s.frameIndex++
+	s.deletions.collect(s.frameIndex)
+}
 
-	n := vk.Memcopy(pData, data)
-	if n != len(data) {
-		log.Printf("vulkan warning: failed to copy data, %d != %d", n, len(data))
+// Destroy releases the resources that outlive swapchain recreation.
+// It must be called before the device is destroyed.
+func (s *SpinningCube) Destroy() {
+	if !s.prepared {
+		return
 	}
-	vk.UnmapMemory(dev, res.UniformMemory())
-	return nil
-}
+	dev := s.rc().Device()
+	vk.DeviceWaitIdle(dev)
+	s.deletions.flush()
+	if s.skybox != nil {
+		s.destroySkybox()
+	}
+	s.pipelines.destroy()
+	s.pipelines = nil
+	s.savePipelineCache()
+	vk.DestroyPipelineCache(dev, s.pipelineCache, nil)
+	vk.DestroyRenderPass(dev, s.renderPass, nil)
+	vk.DestroyPipelineLayout(dev, s.pipelineLayout, nil)
+	if s.bindlessLayout != nil {
+		vk.DestroyPipelineLayout(dev, s.bindlessLayout, nil)
+		s.bindlessLayout = nil
+	}
+	vk.DestroyDescriptorSetLayout(dev, s.descLayout, nil)
 
-func (s *SpinningCube) Destroy() {}
+	for _, m := range s.meshes {
+		m.Destroy(dev)
+	}
+	s.meshes = nil
+	s.textures.destroy()
+	s.memory.destroy()
+	s.memory = nil
+	s.prepared = false
+}
 
 type Texture struct {
+	key  textureKey
+	refs int
+	set  vk.DescriptorSet
+	pool *texturePool
+
 	sampler vk.Sampler
 
 	image       vk.Image
 	imageLayout vk.ImageLayout
 
-	memAlloc *vk.MemoryAllocateInfo
-	mem      vk.DeviceMemory
-	view     vk.ImageView
+	mem  *memoryAllocation
+	view vk.ImageView
 
 	texWidth  int32
 	texHeight int32
+	mipLevels uint32
+	layers    uint32
+	viewType  vk.ImageViewType
+
+	// index is the slot of the texture in the texture table, if bindless
+	bindless bool
+	index    uint32
 }
 
 func (t *Texture) Destroy(dev vk.Device) {
 	vk.DestroyImageView(dev, t.view, nil)
-	vk.FreeMemory(dev, t.mem, nil)
 	vk.DestroyImage(dev, t.image, nil)
-	vk.DestroySampler(dev, t.sampler, nil)
+	t.mem.free()
 }
 
 func (t *Texture) DestroyImage(dev vk.Device) {
-	vk.FreeMemory(dev, t.mem, nil)
 	vk.DestroyImage(dev, t.image, nil)
+	t.mem.free()
 }
 
 type Depth struct {
-	format   vk.Format
-	image    vk.Image
-	memAlloc *vk.MemoryAllocateInfo
-	mem      vk.DeviceMemory
-	view     vk.ImageView
+	format vk.Format
+	image  vk.Image
+	mem    *memoryAllocation
+	view   vk.ImageView
 }
 
 func (d *Depth) Destroy(dev vk.Device) {
 	vk.DestroyImageView(dev, d.view, nil)
 	vk.DestroyImage(dev, d.image, nil)
-	vk.FreeMemory(dev, d.mem, nil)
+	d.mem.free()
 }
 
 // func loadTextureSize(name string) (w int, h int, err error) {
@@ -910,19 +885,20 @@ func (d *Depth) Destroy(dev vk.Device) {
 // 	return []byte(newImg.Pix), nil
 // }
 
-func loadTextureData(pathToTexture string, rowPitch int) ([]byte, int, int, error) {
-	data, err := ioutil.ReadFile(pathToTexture)
-	img, err := png.Decode(bytes.NewReader(data))
-	if err != nil {
-		return nil, 0, 0, err
+// pitchRows returns the base level of img with rows rowPitch bytes apart,
+// as laid out in a linear image.
+func pitchRows(img *TextureImage, rowPitch int) []byte {
+	bw, bh, size, err := formatBlock(img.Format)
+	orPanic(err)
+	texels := img.Levels[0]
+	rowSize := size * ((img.Width + bw - 1) / bw)
+	if rowPitch <= rowSize {
+		return texels
 	}
-	newImg := image.NewRGBA(img.Bounds())
-	if rowPitch <= 4*img.Bounds().Dy() {
-		// apply the proposed row pitch only if supported,
-		// as we're using only optimal textures.
-		newImg.Stride = rowPitch
+	rows := (img.Height + bh - 1) / bh
+	data := make([]byte, rowPitch*(rows-1)+rowSize)
+	for y := 0; y < rows; y++ {
+		copy(data[y*rowPitch:], texels[y*rowSize:(y+1)*rowSize])
 	}
-	draw.Draw(newImg, newImg.Bounds(), img, image.ZP, draw.Src)
-	size := newImg.Bounds().Size()
-	return []byte(newImg.Pix), size.X, size.Y, nil
-}
\ No newline at end of file
+	return data
+}
@@ -0,0 +1,406 @@
+package util
+
+import (
+	"math"
+
+	as "github.com/vulkan-go/asche"
+	vk "github.com/vulkan-go/vulkan"
+)
+
+// defaultFramesInFlight is the number of frames in flight of a Window
+// unless WindowOptions ask for another.
+const defaultFramesInFlight = 2
+
+// Window renders a SpinningCube to the surface of a window, managing the
+// swapchain and the frames in flight itself instead of leaving them to asche.
+// Each frame is recorded anew into the command buffer of a frame the GPU has
+// finished with, so the CPU prepares the next frame while the GPU is still
+// rendering the previous ones.
+type Window struct {
+	cube *SpinningCube
+	ctx  *windowContext
+}
+
+// WindowOptions configure the device and swapchain of a Window.
+type WindowOptions struct {
+	// InstanceExtensions are the instance extensions the surface needs,
+	// such as those returned by GetRequiredInstanceExtensions of glfw.
+	InstanceExtensions []string
+	// CreateSurface creates the surface of the window for instance.
+	CreateSurface func(instance vk.Instance) (vk.Surface, error)
+	// Size returns the framebuffer size of the window, the swapchain
+	// extent when the surface leaves it up to the application. The
+	// swapchain is recreated whenever it changes, as some surfaces are
+	// not reported outdated on resize.
+	Size func() (width, height uint32)
+	// FramesInFlight is the number of frames the CPU may record ahead of
+	// the GPU, 2 if zero, at most 3.
+	FramesInFlight int
+}
+
+// NewWindow creates a Vulkan device presenting to the surface of opts and
+// prepares cube to render to it. vk.Init must have been called.
+func NewWindow(cube *SpinningCube, opts WindowOptions) (w *Window, err error) {
+	defer checkErr(&err)
+
+	n := opts.FramesInFlight
+	if n == 0 {
+		n = defaultFramesInFlight
+	}
+	ctx := &windowContext{
+		size: opts.Size,
+	}
+	ctx.prepareDevice(opts.InstanceExtensions, opts.CreateSurface)
+	ctx.prepareCommandPool()
+	ctx.frames = newFrameRing(ctx.device, ctx.queueFamilyIndex, n)
+	ctx.format = cube.ChooseSurfaceFormat(ctx.gpu, ctx.surface)
+	ctx.colorSpace = cube.colorSpace
+	ctx.prepareSwapchain()
+
+	cube.window = ctx
+	ctx.beginInitCmd()
+	orPanic(cube.VulkanContextPrepare())
+	ctx.flushInitCmd()
+	cube.deletions.flush()
+
+	w = &Window{
+		cube: cube,
+		ctx:  ctx,
+	}
+	return w, nil
+}
+
+// Frame records and submits the next frame and presents it. It only waits
+// for the GPU when all frames are in flight. When the swapchain no longer
+// matches the surface, e.g. after the window was resized, it is recreated
+// and the frame may be skipped. The caller skips frames while the window is
+// minimized, there is no surface area to present to.
+func (w *Window) Frame() (err error) {
+	defer checkErr(&err)
+
+	w.ctx.frame(w.cube, nil)
+	return nil
+}
+
+// frame renders and presents the next frame of s, with a copy of the
+// swapchain image into rb unless nil. It reports whether the frame was
+// presented, rather than skipped for the swapchain to be recreated.
+func (c *windowContext) frame(s *SpinningCube, rb *readback) bool {
+	dev := c.device
+	if c.resized() {
+		c.recreate(s)
+	}
+	f, slot := c.frames.next(dev)
+	var imageIdx uint32
+	ret := vk.AcquireNextImage(dev, c.swapchain, vk.MaxUint64, f.imageAvailable, vk.NullFence, &imageIdx)
+	switch ret {
+	case vk.Success, vk.Suboptimal:
+	case vk.ErrorOutOfDate:
+		c.recreate(s)
+		return false
+	default:
+		orPanic(as.NewError(ret))
+	}
+
+	f.begin(dev)
+	s.imageIdx = int(imageIdx)
+	s.region = slot
+	s.beginFrame()
+	s.readback = rb
+	img := c.images[imageIdx]
+	s.drawBuildCommandBuffer(img, f.cmd, slot, vk.CommandBufferUsageOneTimeSubmitBit)
+	s.readback = nil
+
+	ret = vk.QueueSubmit(c.queue, 1, []vk.SubmitInfo{{
+		SType:              vk.StructureTypeSubmitInfo,
+		WaitSemaphoreCount: 1,
+		PWaitSemaphores:    []vk.Semaphore{f.imageAvailable},
+		PWaitDstStageMask: []vk.PipelineStageFlags{
+			vk.PipelineStageFlags(vk.PipelineStageColorAttachmentOutputBit),
+		},
+		CommandBufferCount:   1,
+		PCommandBuffers:      []vk.CommandBuffer{f.cmd},
+		SignalSemaphoreCount: 1,
+		PSignalSemaphores:    []vk.Semaphore{img.renderFinished},
+	}}, f.fence)
+	orPanic(as.NewError(ret))
+
+	ret = vk.QueuePresent(c.queue, &vk.PresentInfo{
+		SType:              vk.StructureTypePresentInfo,
+		WaitSemaphoreCount: 1,
+		PWaitSemaphores:    []vk.Semaphore{img.renderFinished},
+		SwapchainCount:     1,
+		PSwapchains:        []vk.Swapchain{c.swapchain},
+		PImageIndices:      []uint32{imageIdx},
+	})
+	switch ret {
+	case vk.Success:
+	case vk.Suboptimal, vk.ErrorOutOfDate:
+		c.recreate(s)
+	default:
+		orPanic(as.NewError(ret))
+	}
+	return true
+}
+
+// recreate recreates the swapchain for the current surface extent and
+// prepares s for it again.
+func (c *windowContext) recreate(s *SpinningCube) {
+	ret := vk.DeviceWaitIdle(c.device)
+	orPanic(as.NewError(ret))
+	orPanic(s.VulkanContextCleanup())
+	c.destroyImages()
+	c.prepareSwapchain()
+
+	c.beginInitCmd()
+	orPanic(s.VulkanContextPrepare())
+	c.flushInitCmd()
+	s.deletions.flush()
+}
+
+// Destroy releases the cube resources, the swapchain and the device.
+func (w *Window) Destroy() {
+	vk.DeviceWaitIdle(w.ctx.device)
+	orPanic(w.cube.VulkanContextCleanup())
+	w.cube.Destroy()
+	w.cube.window = nil
+	w.ctx.Destroy()
+}
+
+// Dimensions returns the extent and format of the current swapchain.
+func (w *Window) Dimensions() *as.SwapchainDimensions {
+	return w.ctx.dimensions
+}
+
+// windowContext implements renderContext on a device presenting to
+// a surface, with a swapchain of its own.
+type windowContext struct {
+	deviceContext
+
+	size       func() (width, height uint32)
+	format     vk.Format
+	colorSpace vk.ColorSpace
+	// sizeAtCreate is the size reported when the swapchain
+	// was created, see resized
+	sizeAtCreate [2]uint32
+
+	// transferSrc is set when the swapchain images can be
+	// copied from, for frames to be captured as presented
+	transferSrc bool
+
+	swapchain  vk.Swapchain
+	dimensions *as.SwapchainDimensions
+	images     []*swapchainImage
+	frames     *frameRing
+}
+
+func (c *windowContext) Dimensions() *as.SwapchainDimensions {
+	return c.dimensions
+}
+
+func (c *windowContext) ImageResources() []imageResources {
+	res := make([]imageResources, 0, len(c.images))
+	for _, img := range c.images {
+		res = append(res, img)
+	}
+	return res
+}
+
+func (c *windowContext) ColorFinalLayout() vk.ImageLayout {
+	return vk.ImageLayoutPresentSrc
+}
+
+func (c *windowContext) FramesInFlight() int {
+	return len(c.frames.frames)
+}
+
+// prepareSwapchain creates the swapchain for the current extent of the
+// surface, replacing the previous one, and the views of its images.
+func (c *windowContext) prepareSwapchain() {
+	dev := c.device
+	var caps vk.SurfaceCapabilities
+	ret := vk.GetPhysicalDeviceSurfaceCapabilities(c.gpu, c.surface, &caps)
+	orPanic(as.NewError(ret))
+	caps.Deref()
+	caps.CurrentExtent.Deref()
+	caps.MinImageExtent.Deref()
+	caps.MaxImageExtent.Deref()
+
+	var width, height uint32
+	if c.size != nil {
+		width, height = c.size()
+	}
+	c.sizeAtCreate = [2]uint32{width, height}
+	extent := caps.CurrentExtent
+	if extent.Width == math.MaxUint32 {
+		// the surface leaves the extent up to the application
+		extent.Width = clampUint32(width, caps.MinImageExtent.Width, caps.MaxImageExtent.Width)
+		extent.Height = clampUint32(height, caps.MinImageExtent.Height, caps.MaxImageExtent.Height)
+	}
+	// an image more than the minimum, for acquiring not to wait
+	// on the presentation engine
+	imageCount := caps.MinImageCount + 1
+	if caps.MaxImageCount > 0 && imageCount > caps.MaxImageCount {
+		imageCount = caps.MaxImageCount
+	}
+	transform := caps.CurrentTransform
+	if caps.SupportedTransforms&vk.SurfaceTransformFlags(vk.SurfaceTransformIdentityBit) != 0 {
+		transform = vk.SurfaceTransformIdentityBit
+	}
+	compositeAlpha := vk.CompositeAlphaOpaqueBit
+	for _, alpha := range []vk.CompositeAlphaFlagBits{
+		vk.CompositeAlphaOpaqueBit,
+		vk.CompositeAlphaPreMultipliedBit,
+		vk.CompositeAlphaPostMultipliedBit,
+		vk.CompositeAlphaInheritBit,
+	} {
+		if caps.SupportedCompositeAlpha&vk.CompositeAlphaFlags(alpha) != 0 {
+			compositeAlpha = alpha
+			break
+		}
+	}
+
+	usage := vk.ImageUsageFlags(vk.ImageUsageColorAttachmentBit)
+	c.transferSrc = caps.SupportedUsageFlags&vk.ImageUsageFlags(vk.ImageUsageTransferSrcBit) != 0
+	if c.transferSrc {
+		usage |= vk.ImageUsageFlags(vk.ImageUsageTransferSrcBit)
+	}
+
+	oldSwapchain := c.swapchain
+	var swapchain vk.Swapchain
+	ret = vk.CreateSwapchain(dev, &vk.SwapchainCreateInfo{
+		SType:            vk.StructureTypeSwapchainCreateInfo,
+		Surface:          c.surface,
+		MinImageCount:    imageCount,
+		ImageFormat:      c.format,
+		ImageColorSpace:  c.colorSpace,
+		ImageExtent:      extent,
+		ImageArrayLayers: 1,
+		ImageUsage:       usage,
+		ImageSharingMode: vk.SharingModeExclusive,
+		PreTransform:     transform,
+		CompositeAlpha:   compositeAlpha,
+		// FIFO is the one present mode every surface supports
+		PresentMode:  vk.PresentModeFifo,
+		Clipped:      vk.True,
+		OldSwapchain: oldSwapchain,
+	}, nil, &swapchain)
+	orPanic(as.NewError(ret))
+	if oldSwapchain != vk.NullSwapchain {
+		vk.DestroySwapchain(dev, oldSwapchain, nil)
+	}
+	c.swapchain = swapchain
+	c.dimensions = &as.SwapchainDimensions{
+		Width: extent.Width, Height: extent.Height, Format: c.format,
+	}
+
+	var count uint32
+	ret = vk.GetSwapchainImages(dev, swapchain, &count, nil)
+	orPanic(as.NewError(ret))
+	images := make([]vk.Image, count)
+	ret = vk.GetSwapchainImages(dev, swapchain, &count, images)
+	orPanic(as.NewError(ret))
+	c.images = make([]*swapchainImage, 0, count)
+	for _, image := range images {
+		var view vk.ImageView
+		ret = vk.CreateImageView(dev, &vk.ImageViewCreateInfo{
+			SType:    vk.StructureTypeImageViewCreateInfo,
+			Image:    image,
+			ViewType: vk.ImageViewType2d,
+			Format:   c.format,
+			SubresourceRange: vk.ImageSubresourceRange{
+				AspectMask: vk.ImageAspectFlags(vk.ImageAspectColorBit),
+				LevelCount: 1,
+				LayerCount: 1,
+			},
+		}, nil, &view)
+		orPanic(as.NewError(ret))
+		c.images = append(c.images, &swapchainImage{
+			image:          image,
+			view:           view,
+			renderFinished: newSemaphore(dev),
+		})
+	}
+}
+
+// resized reports whether the window has been resized since the swapchain
+// was created. Surfaces leaving the extent up to the application are never
+// reported outdated, others not on every platform.
+func (c *windowContext) resized() bool {
+	if c.size == nil {
+		return false
+	}
+	width, height := c.size()
+	return width != c.sizeAtCreate[0] || height != c.sizeAtCreate[1]
+}
+
+func clampUint32(v, min, max uint32) uint32 {
+	if v < min {
+		return min
+	}
+	if v > max {
+		return max
+	}
+	return v
+}
+
+func (c *windowContext) destroyImages() {
+	for _, img := range c.images {
+		img.Destroy(c.device)
+	}
+	c.images = nil
+}
+
+func (c *windowContext) Destroy() {
+	c.destroyImages()
+	vk.DestroySwapchain(c.device, c.swapchain, nil)
+	c.frames.destroy(c.device)
+	c.deviceContext.destroy()
+}
+
+// swapchainImage are the resources of a swapchain image of a window. It has
+// no command buffer of its own, frames are recorded into the command buffer
+// of the frame in flight. renderFinished is signalled when the frame drawn
+// into it has been rendered, for presenting it.
+type swapchainImage struct {
+	image          vk.Image
+	view           vk.ImageView
+	renderFinished vk.Semaphore
+
+	framebuffer vk.Framebuffer
+	descSet     vk.DescriptorSet
+}
+
+func (i *swapchainImage) Image() vk.Image {
+	return i.image
+}
+
+func (i *swapchainImage) View() vk.ImageView {
+	return i.view
+}
+
+func (i *swapchainImage) Framebuffer() vk.Framebuffer {
+	return i.framebuffer
+}
+
+func (i *swapchainImage) SetFramebuffer(fb vk.Framebuffer) {
+	i.framebuffer = fb
+}
+
+func (i *swapchainImage) DescriptorSet() vk.DescriptorSet {
+	return i.descSet
+}
+
+func (i *swapchainImage) SetDescriptorSet(set vk.DescriptorSet) {
+	i.descSet = set
+}
+
+func (i *swapchainImage) CommandBuffer() vk.CommandBuffer {
+	return nil
+}
+
+func (i *swapchainImage) Destroy(dev vk.Device) {
+	vk.DestroyFramebuffer(dev, i.framebuffer, nil)
+	vk.DestroyImageView(dev, i.view, nil)
+	vk.DestroySemaphore(dev, i.renderFinished, nil)
+}